@@ -4,6 +4,16 @@ import (
 	"net/http"
 	"fmt"
 	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"encoding/json"
+	"strings"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/transform"
 )
 
 /********************** Request 相关基本函数 **********************/
@@ -25,18 +35,136 @@ func (req *Request) HttpReq() *http.Request {
 	return req.httpReq
 }
 
+//New，创建带方法、请求头和Body的Request
+func NewRequestWithBody(method, url string, header http.Header, body []byte, depth int) (*Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	httpReq, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		for _, v := range vs {
+			httpReq.Header.Add(k, v)
+		}
+	}
+	return &Request{
+		httpReq: httpReq,
+		depth:   depth,
+		body:    body,
+	}, nil
+}
+
 //获取深度值
 func (req *Request) Depth() int {
 	return req.depth
 }
 
+//获取请求方法
+func (req *Request) Method() string {
+	if req.httpReq == nil || req.httpReq.Method == "" {
+		return http.MethodGet
+	}
+	return req.httpReq.Method
+}
+
+//获取请求头
+func (req *Request) Header() http.Header {
+	return req.httpReq.Header
+}
+
+//获取请求Body
+func (req *Request) Body() []byte {
+	return req.body
+}
+
+//重置httpReq的Body, 每次发送之前调用, 保证重试的时候Body完整
+func (req *Request) ResetBody() {
+	if req.httpReq != nil && req.body != nil {
+		req.httpReq.Body = ioutil.NopCloser(bytes.NewReader(req.body))
+		req.httpReq.ContentLength = int64(len(req.body))
+	}
+}
+
+//获取优先级
+func (req *Request) Priority() int {
+	return req.priority
+}
+
+//设置优先级, 返回自身, 方便链式调用
+func (req *Request) SetPriority(priority int) *Request {
+	req.priority = priority
+	return req
+}
+
+//获取上下文信息
+func (req *Request) Meta() map[string]interface{} {
+	return req.meta
+}
+
+//设置一项上下文信息, 返回自身, 方便链式调用
+func (req *Request) SetMeta(key string, value interface{}) *Request {
+	if req.meta == nil {
+		req.meta = make(map[string]interface{})
+	}
+	req.meta[key] = value
+	return req
+}
+
+//获取分析函数名
+func (req *Request) Callback() string {
+	return req.callback
+}
+
+//指定分析响应的分析函数名, 返回自身, 方便链式调用
+//名字需要由插件通过CallbackPlugin接口注册, 未注册的名字会退回到默认分析链
+func (req *Request) SetCallback(name string) *Request {
+	req.callback = name
+	return req
+}
+
+//用新的Url复制一个请求, 方法、请求头、Body、优先级、上下文信息都保留
+func (req *Request) CloneWithUrl(url string) (*Request, error) {
+	newReq, err := NewRequestWithBody(req.Method(), url, req.httpReq.Header, req.body, req.depth)
+	if err != nil {
+		return nil, err
+	}
+	newReq.priority = req.priority
+	newReq.meta = req.meta
+	newReq.callback = req.callback
+	return newReq, nil
+}
+
+//请求指纹, 用于去重
+//GET且没有Body的请求, 指纹就是Url本身; 其他的请求, 指纹包含方法和Body的摘要
+func (req *Request) Fingerprint() string {
+	url := req.httpReq.URL.String()
+	method := req.Method()
+	if method == http.MethodGet && len(req.body) == 0 {
+		return url
+	}
+	if len(req.body) == 0 {
+		return method + " " + url
+	}
+	sum := sha1.Sum(req.body)
+	return method + " " + url + " #" + hex.EncodeToString(sum[:8])
+}
+
 /************************** 响应体相关 **************************/
 //New，创建响应
 func NewResponse(body []byte, depth int, ct, url string) *Response {
+	mimeType, declared := ParseContentType(ct)
+	if mimeType == "" && len(body) > 0 {
+		mimeType, _ = ParseContentType(http.DetectContentType(body))
+	}
 	return &Response{
 		Body        :body,
 		Depth       :depth,
 		ContentType :ct,
+		MimeType    :mimeType,
+		DeclaredCharset :declared,
 		ReqUrl      :url,
 	}
 }
@@ -46,6 +174,182 @@ func (resp *Response) Valid() bool {
 	return true
 }
 
+//不带解析缓存的副本, 原响应仍可能被其他goroutine使用(比如超时后仍在运行的分析函数)时,
+//用副本避免并发读写缓存; 副本需要时重新转码和解析
+func (resp *Response) Detach() *Response {
+	return &Response{
+		Body:        resp.Body,
+		Depth:       resp.Depth,
+		ContentType: resp.ContentType,
+		MimeType:    resp.MimeType,
+		DeclaredCharset: resp.DeclaredCharset,
+		ReqUrl:      resp.ReqUrl,
+		Header:      resp.Header,
+		Fingerprint: resp.Fingerprint,
+		Media:       resp.Media,
+		Meta:        resp.Meta,
+		Callback:    resp.Callback,
+	}
+}
+
+//是否是JSON响应
+func (resp *Response) IsJSON() bool {
+	return IsJSONContentType(resp.ContentType)
+}
+
+//解析JSON Body, 多个分析函数共享同一个解析结果
+//数字解析为json.Number, 防止大整数ID和游标丢失精度
+func (resp *Response) JSON() (interface{}, error) {
+	if !resp.jsonParsed {
+		d := json.NewDecoder(bytes.NewReader(resp.Body))
+		d.UseNumber()
+		resp.jsonErr = d.Decode(&resp.jsonData)
+		resp.jsonParsed = true
+	}
+	return resp.jsonData, resp.jsonErr
+}
+
+//按charset转码为utf8后的Body和原始编码, 多个分析函数共享同一个转码结果
+//原始字节仍然在resp.Body中
+func (resp *Response) Utf8Body() ([]byte, string, error) {
+	if !resp.decoded {
+		resp.utf8Body, resp.charset, resp.decodeErr = decodeCharset(resp.Body, resp.ContentType)
+		resp.decoded = true
+	}
+	return resp.utf8Body, resp.charset, resp.decodeErr
+}
+
+//原始编码, 比如utf8, gbk
+func (resp *Response) Charset() string {
+	_, cs, _ := resp.Utf8Body()
+	return cs
+}
+
+//转码并解析DOM, 多个分析函数共享同一个文档, 分析函数不应修改它
+//需要修改的(比如删除节点)应先Clone
+func (resp *Response) Document() (*goquery.Document, error) {
+	if !resp.docParsed {
+		body, _, err := resp.Utf8Body()
+		if err != nil {
+			resp.docErr = err
+		} else {
+			resp.doc, resp.docErr = goquery.NewDocumentFromReader(bytes.NewReader(body))
+		}
+		resp.docParsed = true
+	}
+	return resp.doc, resp.docErr
+}
+
+//网页编码智能判断, 非utf8 => utf8
+func decodeCharset(body []byte, contentType string) ([]byte, string, error) {
+	//先尝试从http header的content-type中直接猜测
+	ct := strings.ToLower(contentType)
+	if strings.Contains(ct, "utf8") || strings.Contains(ct, "utf-8") {
+		return body, "utf8", nil
+	}
+
+	//利用golang.org/x/net/html/charset包提供的方法, 根据前1024字节开始猜
+	//Body可能不足1024字节, 直接截取而不是用bufio.Peek(不足时会返回错误)
+	prefix := body
+	if len(prefix) > 1024 {
+		prefix = prefix[:1024]
+	}
+	encoding, charSet, _ := charset.DetermineEncoding(prefix, contentType)
+	cs := strings.ToLower(charSet)
+	if strings.Contains(cs, "utf8") || strings.Contains(cs, "utf-8") {
+		return body, "utf8", nil
+	}
+
+	//需要转码
+	utf8Body, err := ioutil.ReadAll(transform.NewReader(bytes.NewReader(body), encoding.NewDecoder()))
+	if err != nil {
+		return nil, charSet, err
+	}
+	return utf8Body, charSet, nil
+}
+
+//缓存的RSS/Atom解析结果, 没有则用parse解析一次; basic不依赖具体的feed类型, 由plugin.ParseFeed使用
+func (resp *Response) CachedFeed(parse func() (interface{}, error)) (interface{}, error) {
+	if !resp.feedParsed {
+		resp.feed, resp.feedErr = parse()
+		resp.feedParsed = true
+	}
+	return resp.feed, resp.feedErr
+}
+
+//是否是feed可能使用的Content-Type, 包括通用的XML类型
+func IsFeedContentType(contentType string) bool {
+	mimeType := mediaType(contentType)
+	return mimeType == "application/rss+xml" || mimeType == "application/atom+xml" ||
+		mimeType == "application/rdf+xml" || mimeType == "text/xml" || mimeType == "application/xml"
+}
+
+//去掉参数的MIME类型, 比如"text/html; charset=utf-8" => "text/html"
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
+//解析Content-Type, 得到小写的MIME类型和声明的charset(小写)
+//不规范的Content-Type(比如参数格式错误)退回到按分号切分
+func ParseContentType(contentType string) (string, string) {
+	if strings.TrimSpace(contentType) == "" {
+		return "", ""
+	}
+	mimeType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return mediaType(contentType), ""
+	}
+	return mimeType, strings.ToLower(strings.Trim(params["charset"], `"' `))
+}
+
+//默认接受的MIME类型: HTML页面, JSON接口和RSS/Atom
+var DefaultAcceptMime = []string{
+	"text/html", "application/xhtml+xml",
+	"application/json", "text/json", "+json",
+	"application/rss+xml", "application/atom+xml", "application/rdf+xml", "text/xml", "application/xml",
+}
+
+//MIME类型是否匹配列表中的某一项
+//列表项可以是完整的类型(text/html), 大类通配(text/*), 或者结构化后缀(+json, +xml)
+func MatchMime(mimeType string, patterns []string) bool {
+	return MimePattern(mimeType, patterns) != ""
+}
+
+//返回MIME类型匹配的列表项, 完整类型优先于后缀, 后缀优先于大类通配; 没有匹配返回空
+func MimePattern(mimeType string, patterns []string) string {
+	if mimeType == "" {
+		return ""
+	}
+	suffix, wildcard := "", ""
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		switch {
+		case p == "":
+		case p == mimeType:
+			return p
+		case strings.HasPrefix(p, "+"):
+			if suffix == "" && strings.HasSuffix(mimeType, p) {
+				suffix = p
+			}
+		case strings.HasSuffix(p, "/*"):
+			if wildcard == "" && strings.HasPrefix(mimeType, strings.TrimSuffix(p, "*")) {
+				wildcard = p
+			}
+		}
+	}
+	if suffix != "" {
+		return suffix
+	}
+	return wildcard
+}
+
+//是否是JSON的Content-Type: application/json, text/json, 以及application/xxx+json
+func IsJSONContentType(contentType string) bool {
+	mimeType := mediaType(contentType)
+	return mimeType == "application/json" || mimeType == "text/json" ||
+		(strings.HasPrefix(mimeType, "application/") && strings.HasSuffix(mimeType, "+json"))
+}
+
 /*************************** 条目相关 ***************************/
 func (e Item) Valid() bool {
 	return e != nil
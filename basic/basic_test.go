@@ -0,0 +1,109 @@
+package basic
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	get, _ := NewRequestWithBody(http.MethodGet, "http://www.360.cn/search", nil, nil, 0)
+	if get.Fingerprint() != "http://www.360.cn/search" {
+		t.Fatal("Wrong GET fingerprint:", get.Fingerprint())
+	}
+
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	p1, _ := NewRequestWithBody(http.MethodPost, "http://www.360.cn/search", header, []byte("q=a"), 0)
+	p2, _ := NewRequestWithBody(http.MethodPost, "http://www.360.cn/search", header, []byte("q=b"), 0)
+	if p1.Fingerprint() == p2.Fingerprint() || p1.Fingerprint() == get.Fingerprint() {
+		t.Fatal("Fingerprint should differ:", p1.Fingerprint(), p2.Fingerprint())
+	}
+
+	//复制请求保留方法、请求头、Body、上下文信息
+	p1.SetMeta("list", "page1").SetPriority(3)
+	c, err := p1.CloneWithUrl("http://www.360.cn/search2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Method() != http.MethodPost || c.Header().Get("Content-Type") == "" ||
+		c.Meta()["list"] != "page1" || c.Priority() != 3 {
+		t.Fatal("Wrong clone")
+	}
+
+	//Body可以反复读取
+	for i := 0; i < 2; i++ {
+		c.ResetBody()
+		b, _ := ioutil.ReadAll(c.HttpReq().Body)
+		if string(b) != "q=a" {
+			t.Fatal("Wrong body:", string(b))
+		}
+	}
+}
+
+func TestResponseDocument(t *testing.T) {
+	//不足1024字节且Content-Type中没有编码的Body
+	resp := NewResponse([]byte(`<html><body><p>hello</p></body></html>`), 0, "text/html", "http://www.360.cn/")
+	doc, err := resp.Document()
+	if err != nil || doc.Find("p").Text() != "hello" {
+		t.Fatal("Wrong short body:", err)
+	}
+	//同一个Response只解析一次
+	if doc2, _ := resp.Document(); doc2 != doc {
+		t.Fatal("Document parsed twice")
+	}
+
+	//gbk编码的"你好", 由meta声明编码, 原始字节保持不变
+	raw := append([]byte(`<html><head><meta charset="gbk"></head><body><p>`), 0xc4, 0xe3, 0xba, 0xc3)
+	raw = append(raw, []byte(`</p></body></html>`)...)
+	resp = NewResponse(raw, 0, "text/html", "http://www.360.cn/")
+	doc, err = resp.Document()
+	if err != nil || doc.Find("p").Text() != "你好" || resp.Charset() != "gbk" {
+		t.Fatal("Wrong gbk body:", err, resp.Charset())
+	}
+	if !bytes.Equal(resp.Body, raw) {
+		t.Fatal("Raw body changed")
+	}
+
+	//空Body
+	resp = NewResponse(nil, 0, "", "http://www.360.cn/")
+	if _, err := resp.Document(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResponseMime(t *testing.T) {
+	resp := NewResponse([]byte("a,b\n1,2\n"), 0, `Text/CSV; charset="GBK"`, "http://www.360.cn/a.csv")
+	if resp.MimeType != "text/csv" || resp.DeclaredCharset != "gbk" {
+		t.Fatal("Wrong mime:", resp.MimeType, resp.DeclaredCharset)
+	}
+	//没有声明Content-Type的按Body嗅探
+	resp = NewResponse([]byte("<html><body></body></html>"), 0, "", "http://www.360.cn/")
+	if resp.MimeType != "text/html" || resp.DeclaredCharset != "" {
+		t.Fatal("Wrong sniffed mime:", resp.MimeType, resp.DeclaredCharset)
+	}
+	//不规范的参数
+	if m, cs := ParseContentType("text/html; charset"); m != "text/html" || cs != "" {
+		t.Fatal("Wrong invalid content type:", m, cs)
+	}
+}
+
+func TestMatchMime(t *testing.T) {
+	patterns := []string{"text/html", "text/*", "+json"}
+	cases := map[string]string{
+		"text/html":           "text/html",
+		"text/plain":          "text/*",
+		"application/ld+json": "+json",
+		"application/xml":     "",
+		"":                    "",
+	}
+	for mimeType, expect := range cases {
+		if p := MimePattern(mimeType, patterns); p != expect {
+			t.Fatal("Wrong pattern:", mimeType, p)
+		}
+	}
+	//后缀优先于大类通配
+	if p := MimePattern("text/foo+json", []string{"text/*", "+json"}); p != "+json" {
+		t.Fatal("Wrong priority:", p)
+	}
+}
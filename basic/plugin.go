@@ -30,3 +30,50 @@ type SpiderPlugin interface {
 	//生成Item处理函数链
 	GenItemProcessors()     []ProcessItemFunc
 }
+
+/*
+ * CallbackPlugin接口定义, 可选
+ * 插件实现了这个接口, 就可以按名字注册分析函数, 比如"listing"、"article"
+ * 分析函数产出的请求通过SetCallback指定由哪个分析函数分析其响应, 不必在一个分析函数中猜测页面类型
+ */
+type CallbackPlugin interface {
+	//生成按名字注册的分析函数
+	GenNamedAnalysers()     map[string]AnalyzeResponseFunc
+}
+
+/*
+ * 分析规则, 声明式的路由: 按Url正则或者Content-Type正则, 把Response交给指定的分析函数, 并控制是否跟进
+ * 第一个匹配的规则生效, 没有规则匹配则使用默认分析链
+ */
+type AnalyzeRule struct {
+	Name          string                //规则名, 用于日志
+	UrlPattern    string                //匹配Response的Url的正则, 为空表示不限制
+	ContentType   string                //匹配Response的Content-Type的正则, 为空表示不限制
+	Analyzers     []string              //按名字引用的分析函数, 名字需要由CallbackPlugin注册
+	AnalyzeFuncs  []AnalyzeResponseFunc //直接指定的分析函数, 插件定义规则时使用
+	Follow        bool                  //是否跟进分析出的请求, false则丢弃全部请求
+	FollowPattern string                //只跟进Url匹配这个正则的请求, 为空表示不限制
+}
+
+/*
+ * RulePlugin接口定义, 可选
+ * 插件实现了这个接口, 就可以用声明式的规则代替在分析函数中手写的Url过滤
+ */
+type RulePlugin interface {
+	//生成分析规则
+	GenAnalyzeRules()       []AnalyzeRule
+}
+
+/*
+ * ClosablePlugin接口定义, 可选
+ * 插件持有需要在爬取结束时关闭的资源(比如批量写入的输出), 爬取结束后main调用Close
+ * 调度器每处理完一个条目取出一次异步产生的错误放入错误通道, 摘要放入调度器的summary
+ */
+type ClosablePlugin interface {
+	//关闭资源, 写入剩余的数据, 返回尚未取出的错误
+	Close() error
+	//取出异步产生的错误(比如批量写入失败的文档), 这些错误不属于当前处理的条目
+	TakeErrors() []error
+	//摘要
+	Summary(prefix string) string
+}
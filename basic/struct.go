@@ -4,14 +4,20 @@ package basic
  * 基本数据类型的定义
  */
 import (
+	"github.com/PuerkitoBio/goquery"
 	"net/http"
 )
 
 /************************************** Request ***************************************/
 //请求体结构
+//方法和请求头直接保存在httpReq中, Body单独保存一份, 因为httpReq.Body只能读一次, 重试的时候需要重建
 type Request struct {
-	httpReq *http.Request //HTTP请求的指针，为了避免零值填充和实例复制，成员用指针
-	depth   int           //请求深度，初始请求深度是0，然后逐渐递增
+	httpReq  *http.Request          //HTTP请求的指针，为了避免零值填充和实例复制，成员用指针
+	depth    int                    //请求深度，初始请求深度是0，然后逐渐递增
+	body     []byte                 //请求Body, 比如POST的表单
+	priority int                    //优先级, 越大越先被调度, 默认0
+	meta     map[string]interface{} //自由的上下文信息, 会被传递到对应的Response和Item, 比如从列表页传递到详情页
+	callback string                 //指定分析响应的分析函数名, 为空则使用默认分析链
 }
 
 /**************************************** 响应 ****************************************/
@@ -20,7 +26,36 @@ type Response struct {
 	Body     	 []byte         //Http: ResponseBody
 	Depth   	 int            //深度
 	ContentType  string         //HttpHeader: content-type
+	MimeType     string         //解析后的MIME类型, 小写不带参数, 比如text/html; 没有声明的按Body嗅探
+	DeclaredCharset string      //Content-Type中声明的charset, 没有声明为空; 实际使用的编码见Charset()
 	ReqUrl       string         //对应的请求url
+	Header       http.Header    //HTTP响应头
+	Fingerprint  string         //对应请求的指纹, 用于在urlMap中找到对应的UrlInfo
+	Media        *MediaFile     //二进制/媒体文件的存储信息, 非nil时Body为空
+	Meta         map[string]interface{} //对应请求的上下文信息
+	Callback     string         //对应请求指定的分析函数名
+	jsonData     interface{}    //JSON响应解析后的结果, 只解析一次
+	jsonErr      error
+	jsonParsed   bool
+	utf8Body     []byte         //按charset转码为utf8后的Body, 只转码一次
+	charset      string         //原始编码
+	decodeErr    error
+	decoded      bool
+	doc          *goquery.Document //解析后的DOM, 只解析一次, 分析链上的分析函数共享
+	docErr       error
+	docParsed    bool
+	feed         interface{}    //RSS/Atom解析后的结果(*feed.Feed), 由plugin.ParseFeed解析, 只解析一次
+	feedErr      error
+	feedParsed   bool
+}
+
+//二进制/媒体文件, 下载时直接流式存储到本地, 不进入内存
+type MediaFile struct {
+	Path   string //本地存储路径
+	Size   int64  //文件大小
+	Mime   string //MIME类型
+	Sha256 string //内容校验和
+	Dedup  bool   //内容已经存在, 本次没有实际写入
 }
 
 /*************************************** 条目 *****************************************/
@@ -74,7 +109,12 @@ type SpiderPool interface {
 type SpiderConf struct {
 	GrabMaxDepth        int    //抓取最大深度
 
-	PluginKey           string //插件名字，根据这个值，框架会自动选择对应的插件
+	PluginKey           string   //插件名字，根据这个值，框架会自动选择对应的插件
+	MainContent         bool     //base插件是否只保留正文(类readability抽取)
+	Metadata            bool     //base插件的item中是否加上页面元数据和结构化数据
+	Pagination          bool     //base插件是否识别列表页翻页, 下一页不计入深度
+	MaxPages            int      //每个列表最多翻多少页(包括首页), 0表示不限制
+	LinkSources         []string //base插件跟进的链接来源: a, area, link, frame, meta-refresh, header, script, feed, text; 为空表示全部跟进
 
 	RequestChanCapcity  int    //请求通道容量
 	ResponseChanCapcity int    //响应通道容量
@@ -89,6 +129,7 @@ type SpiderConf struct {
 
 	RequestTimeout      int    //Http请求超时时间(同时也用于readAll(body)的超时
 	RetryTimes          int    //重试次数, 请求超时的时候, 会将请求重新放入队列
+	AnalyzeTimeout      int    //单个分析函数的时间预算，单位：毫秒，0表示不限制
 
 	SummaryDetail       bool   //是否打印详细Url
 	SummaryInterval     int    //打印summary的间隔，单位：秒
@@ -104,8 +145,93 @@ type SpiderConf struct {
 	CrossSite			bool   //是否跨站爬取
 
 	SkipBinFile			bool   //抓取的时候跳过二进制下载文件, 否则会把spider撑挂了, 再大的内存也不够
+	Preflight           string //二进制文件的判断方式: sniff(直接GET嗅探), head(先发HEAD请求)
+	MaxBodySize         int64  //Body大小上限，单位：字节，超过则跳过, 0表示不限制
+	AcceptMime          []string            //接受的MIME类型, 其他类型被跳过; 支持text/*和+json, 为空则使用DefaultAcceptMime
+	MimeDispatch        map[string][]string //分派表: MIME类型 => 分析函数名, default表示默认分析链
+
+	SaveMedia           bool     //是否把匹配的二进制/媒体文件存储到本地, 而不是跳过
+	MediaDir            string   //媒体文件存储目录
+	MediaExt            []string //按扩展名匹配, 比如pdf,jpg
+	MediaMime           []string //按MIME前缀匹配, 比如application/pdf,image/
+	MediaMaxSize        int64    //媒体文件大小上限，单位：字节, 0表示不限制
+
+	HeaderMode          string          //请求头模式: none(不设置), rotate(轮换), host(按host固定)
+	RefererPolicy       string          //Referer策略: none, full, origin, same-origin
+	HeaderProfiles      []HeaderProfile //请求头profile列表
+
+	AutoThrottle        bool    //是否开启按host自适应限速
+	TargetConcurrency   float64 //单host目标并发度
+	ThrottleStartDelay  int     //单host初始请求间隔，单位：毫秒
+	ThrottleMinDelay    int     //单host最小请求间隔，单位：毫秒
+	ThrottleMaxDelay    int     //单host最大请求间隔，单位：毫秒
+	HostMaxConcurrency  int     //单host最大并发度
+
+	HttpCacheMode       string  //HTTP缓存模式: off, record, replay, cache-first
+	HttpCacheDir        string  //HTTP缓存目录
+
+	DnsCache            bool                //是否开启进程内DNS缓存
+	DnsTtl              int                 //解析成功的缓存时间，单位：秒
+	DnsNegativeTtl      int                 //解析失败的缓存时间，单位：秒
+	DnsMaxConcurrent    int                 //同时进行的DNS解析数量上限
+	StaticHosts         map[string][]string //静态host => ip映射, 类似/etc/hosts
+
+	AnalyzeRules        []AnalyzeRule       //配置的分析规则, 优先于插件定义的规则
+
+	RobotsMode          string              //robots指令(nofollow, meta robots, X-Robots-Tag)的处理模式: off, strict, record
+
+	Sinks               []string            //条目输出: jsonl, csv, elastic, 可以同时配置多个, 为空表示不输出
+	SinkDir             string              //输出目录
+	SinkPrefix          string              //文件名前缀
+	SinkFields          []string            //csv的字段, 用.访问嵌套字段
+	SinkMaxSize         int64               //单个文件的大小上限(未压缩)，单位：字节，0表示不限制
+	SinkInterval        int                 //单个文件的时间跨度，单位：秒，0表示不按时间切分
+	SinkGzip            bool                //是否gzip压缩
+	SinkFsync           bool                //切分和关闭时是否fsync
+
+	ElasticUrl          string              //Elasticsearch地址, 请求发往其/_bulk接口
+	ElasticIndex        string              //索引名
+	ElasticIdField      string              //作为文档id的字段, 为空表示由服务生成id
+	ElasticBatchSize    int                 //每批的文档数
+	ElasticInterval     int                 //定时写入的间隔，单位：毫秒，0表示只按批量写入
+	ElasticMaxRetries   int                 //429/5xx的最大重试次数
+	ElasticBackoff      int                 //首次重试的等待时间，单位：毫秒，之后每次翻倍
+	ElasticTimeout      int                 //单个请求的超时，单位：秒
+
+	EngineDb            string              //engine插件写入的Spider-Engine库名
+	EngineTable         string              //表名
+	EngineKeyField      string              //作为主键的字段
+	EngineBatchSize     int                 //每批的文档数
+	EngineInterval      int                 //定时写入的间隔，单位：毫秒，0表示只按批量写入
+	EngineConcurrency   int                 //一批内的并发请求数
+	EngineMaxRetries    int                 //网络错误和429/5xx的最大重试次数
+	EngineBackoff       int                 //首次重试的等待时间，单位：毫秒，之后每次翻倍
+	EngineTimeout       int                 //单个请求的超时，单位：秒
 }
 
+//请求头profile, 每个请求会按照HeaderMode选择其中一个
+type HeaderProfile struct {
+	Name           string
+	UserAgent      string
+	Accept         string
+	AcceptLanguage string
+}
+
+//请求头模式常量
+const (
+	HEADER_MODE_NONE   = "none"   //不设置, 使用Go默认的User-Agent
+	HEADER_MODE_ROTATE = "rotate" //每个请求轮换profile
+	HEADER_MODE_HOST   = "host"   //同一个host固定使用同一个profile
+)
+
+//Referer策略常量
+const (
+	REFERER_POLICY_NONE        = "none"        //不发送Referer
+	REFERER_POLICY_FULL        = "full"        //发送完整的父Url
+	REFERER_POLICY_ORIGIN      = "origin"      //只发送父Url的scheme://host/
+	REFERER_POLICY_SAME_ORIGIN = "same-origin" //同host才发送完整的父Url
+)
+
 //URL请求状态常量
 const (
 	URL_STATUS_DOWNLOADING  int8 = 0    //下载中
@@ -118,11 +244,15 @@ const (
 )
 
 type UrlInfo struct {
+	Url    string       //请求的Url, urlMap的key是请求指纹, 对于非GET的请求, 指纹和Url不同
+	Method string
 	Status int8
 	Ref    string       //父Url, 即从哪个Url分析出来的本Url
 	Msg    string       //一些信息, 比如错误原因, 跳过原因等等
 	Depth  int
 	Retry  int          //已经重试的次数
+	Robots     string   //页面上的robots指令及来源, 比如"meta:noindex,header:nofollow,nofollow-links:3"
+	Suppressed string   //因robots指令被压制的内容, 比如"items:2,links:10"; record模式下以"ignored "开头, 表示只记录未压制
 }
 
 /************************************ 全局Conf变量 **********************************/
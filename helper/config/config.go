@@ -3,6 +3,10 @@ package config
 import (
 	"github.com/Unknwon/goconfig"
 	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/robots"
+	"github.com/hq-cml/spider-man/helper/sink"
+	"net"
+	"strings"
 )
 
 //解析配置文件
@@ -56,6 +60,7 @@ func ParseConfig(confPath string) (*basic.SpiderConf, error) {
 	if c.RetryTimes, err = cfg.Int("spider", "retryTimes"); err != nil {
 		panic("Load conf retryTimes failed!")
 	}
+	c.AnalyzeTimeout = cfg.MustInt("spider", "analyzeTimeout", 10000)
 
 	if c.SummaryDetail, err = cfg.Bool("spider", "summaryDetail"); err != nil {
 		panic("Load conf summaryDetail failed!" + err.Error())
@@ -72,6 +77,11 @@ func ParseConfig(confPath string) (*basic.SpiderConf, error) {
 	if c.PluginKey, err = cfg.GetValue("plugin", "pluginKey"); err != nil {
 		panic("Load conf pluginKey failed!")
 	}
+	c.MainContent = cfg.MustBool("plugin", "mainContent", false)
+	c.Metadata = cfg.MustBool("plugin", "metadata", false)
+	c.Pagination = cfg.MustBool("plugin", "pagination", false)
+	c.MaxPages = cfg.MustInt("plugin", "maxPages", 50)
+	c.LinkSources = cfg.MustValueArray("plugin", "linkSources", ",")
 
 	if c.LogPath, err = cfg.GetValue("log", "logPath"); err != nil {
 		panic("Load conf logPath failed!")
@@ -96,6 +106,140 @@ func ParseConfig(confPath string) (*basic.SpiderConf, error) {
 	if c.SkipBinFile, err = cfg.Bool("skip", "skipBinFile"); err != nil {
 		panic("Load conf skipBinFile failed!" + err.Error())
 	}
+	c.Preflight = cfg.MustValue("skip", "preflight", "sniff")
+	c.MaxBodySize = cfg.MustInt64("skip", "maxBodySize", 10 * 1024 * 1024)
+	c.AcceptMime = cfg.MustValueArray("skip", "acceptMime", ",")
+
+	//按MIME类型分派分析函数, 可选, 每一项是: MIME类型=分析函数名(逗号分隔)
+	c.MimeDispatch = make(map[string][]string)
+	if table, err := cfg.GetSection("dispatch"); err == nil {
+		for mimeType, names := range table {
+			for _, name := range strings.Split(names, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					c.MimeDispatch[strings.ToLower(mimeType)] = append(c.MimeDispatch[strings.ToLower(mimeType)], name)
+				}
+			}
+			if len(c.MimeDispatch[strings.ToLower(mimeType)]) == 0 {
+				panic("Load conf dispatch failed! No analyzer for:" + mimeType)
+			}
+		}
+	}
+
+	//媒体文件存储配置, 可选
+	c.SaveMedia = cfg.MustBool("media", "saveMedia", false)
+	c.MediaDir = cfg.MustValue("media", "mediaDir", "/tmp/spider-media")
+	c.MediaExt = cfg.MustValueArray("media", "mediaExt", ",")
+	c.MediaMime = cfg.MustValueArray("media", "mediaMime", ",")
+	c.MediaMaxSize = cfg.MustInt64("media", "mediaMaxSize", 100 * 1024 * 1024)
+
+	//请求头配置, 可选, 不配置则保持Go默认的请求头
+	c.HeaderMode = cfg.MustValue("header", "headerMode", basic.HEADER_MODE_NONE)
+	c.RefererPolicy = cfg.MustValue("header", "refererPolicy", basic.REFERER_POLICY_NONE)
+	for _, name := range cfg.MustValueArray("header", "profiles", ",") {
+		section := "header." + name
+		if _, err := cfg.GetSection(section); err != nil {
+			panic("Load conf header profile failed! Not found section:" + section)
+		}
+		c.HeaderProfiles = append(c.HeaderProfiles, basic.HeaderProfile{
+			Name:           name,
+			UserAgent:      cfg.MustValue(section, "userAgent"),
+			Accept:         cfg.MustValue(section, "accept"),
+			AcceptLanguage: cfg.MustValue(section, "acceptLanguage"),
+		})
+	}
+	if c.HeaderMode != basic.HEADER_MODE_NONE && len(c.HeaderProfiles) == 0 {
+		panic("Load conf header failed! headerMode is " + c.HeaderMode + ", but no profiles")
+	}
+
+	//自适应限速配置, 可选
+	c.AutoThrottle = cfg.MustBool("throttle", "autoThrottle", false)
+	c.TargetConcurrency = cfg.MustFloat64("throttle", "targetConcurrency", 1.0)
+	c.ThrottleStartDelay = cfg.MustInt("throttle", "startDelay", 1000)
+	c.ThrottleMinDelay = cfg.MustInt("throttle", "minDelay", 0)
+	c.ThrottleMaxDelay = cfg.MustInt("throttle", "maxDelay", 60000)
+	c.HostMaxConcurrency = cfg.MustInt("throttle", "hostMaxConcurrency", 8)
+
+	//录制/回放HTTP缓存配置, 可选
+	c.HttpCacheMode = cfg.MustValue("httpcache", "mode", "off")
+	c.HttpCacheDir = cfg.MustValue("httpcache", "dir", "/tmp/spider-cache")
+
+	//DNS缓存配置, 可选
+	c.DnsCache = cfg.MustBool("dns", "dnsCache", false)
+	c.DnsTtl = cfg.MustInt("dns", "ttl", 300)
+	c.DnsNegativeTtl = cfg.MustInt("dns", "negativeTtl", 30)
+	c.DnsMaxConcurrent = cfg.MustInt("dns", "maxConcurrent", 10)
+
+	//静态host映射, 可选, 一个host可以配置多个ip, 逗号分隔
+	c.StaticHosts = make(map[string][]string)
+	if hosts, err := cfg.GetSection("hosts"); err == nil {
+		for host, ips := range hosts {
+			for _, ip := range strings.Split(ips, ",") {
+				ip = strings.TrimSpace(ip)
+				if net.ParseIP(ip) == nil {
+					panic("Load conf hosts failed! Invalid ip:" + ip)
+				}
+				c.StaticHosts[host] = append(c.StaticHosts[host], ip)
+			}
+		}
+	}
+
+	//分析规则, 可选, 每个规则对应一个[rule.xxx]段, 分析函数按名字引用
+	for _, name := range cfg.MustValueArray("rules", "rules", ",") {
+		section := "rule." + name
+		if _, err := cfg.GetSection(section); err != nil {
+			panic("Load conf analyze rule failed! Not found section:" + section)
+		}
+		c.AnalyzeRules = append(c.AnalyzeRules, basic.AnalyzeRule{
+			Name:          name,
+			UrlPattern:    cfg.MustValue(section, "urlPattern"),
+			ContentType:   cfg.MustValue(section, "contentType"),
+			Analyzers:     cfg.MustValueArray(section, "analyzers", ","),
+			Follow:        cfg.MustBool(section, "follow", true),
+			FollowPattern: cfg.MustValue(section, "followPattern"),
+		})
+	}
+
+	//robots指令的处理模式, 可选
+	c.RobotsMode = cfg.MustValue("robots", "mode", robots.MODE_OFF)
+	switch c.RobotsMode {
+	case robots.MODE_OFF, robots.MODE_STRICT, robots.MODE_RECORD:
+	default:
+		panic("Load conf robots mode failed! Unsupported mode:" + c.RobotsMode)
+	}
+
+	//条目输出到文件或者Elasticsearch, 可选
+	c.Sinks = cfg.MustValueArray("sink", "sinks", ",")
+	for _, s := range c.Sinks {
+		if s != sink.FORMAT_JSONL && s != sink.FORMAT_CSV && s != sink.FORMAT_ELASTIC {
+			panic("Load conf sinks failed! Unsupported sink:" + s)
+		}
+	}
+	c.SinkDir = cfg.MustValue("sink", "dir", "/tmp/spider-data")
+	c.SinkPrefix = cfg.MustValue("sink", "prefix", "items")
+	c.SinkFields = cfg.MustValueArray("sink", "csvFields", ",")
+	c.SinkMaxSize = cfg.MustInt64("sink", "maxSize", 100 * 1024 * 1024)
+	c.SinkInterval = cfg.MustInt("sink", "interval", 3600)
+	c.SinkGzip = cfg.MustBool("sink", "gzip", false)
+	c.SinkFsync = cfg.MustBool("sink", "fsync", true)
+	c.ElasticUrl = cfg.MustValue("elastic", "url", "http://127.0.0.1:9200")
+	c.ElasticIndex = cfg.MustValue("elastic", "index", "spider")
+	c.ElasticIdField = cfg.MustValue("elastic", "idField", "url")
+	c.ElasticBatchSize = cfg.MustInt("elastic", "batchSize", 500)
+	c.ElasticInterval = cfg.MustInt("elastic", "flushInterval", 5000)
+	c.ElasticMaxRetries = cfg.MustInt("elastic", "maxRetries", 3)
+	c.ElasticBackoff = cfg.MustInt("elastic", "backoff", 500)
+	c.ElasticTimeout = cfg.MustInt("elastic", "timeout", 60)
+
+	//engine插件写入Spider-Engine, 可选
+	c.EngineDb = cfg.MustValue("engine", "db", "sp_db")
+	c.EngineTable = cfg.MustValue("engine", "table", "360news")
+	c.EngineKeyField = cfg.MustValue("engine", "keyField", "id")
+	c.EngineBatchSize = cfg.MustInt("engine", "batchSize", 100)
+	c.EngineInterval = cfg.MustInt("engine", "flushInterval", 3000)
+	c.EngineConcurrency = cfg.MustInt("engine", "concurrency", 4)
+	c.EngineMaxRetries = cfg.MustInt("engine", "maxRetries", 3)
+	c.EngineBackoff = cfg.MustInt("engine", "backoff", 500)
+	c.EngineTimeout = cfg.MustInt("engine", "timeout", 30)
 
 	return c, nil
 }
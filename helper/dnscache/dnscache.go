@@ -0,0 +1,230 @@
+package dnscache
+
+/*
+ * 进程内DNS缓存
+ * 爬取过程中大量的超时其实是DNS超时, 同一个host反复解析也是浪费
+ * 1. 解析结果按TTL缓存, 解析失败的结果也按较短的TTL缓存(负缓存), 防止反复解析不存在的域名
+ * 2. 限制同时进行的解析数量, 同一个host同时只有一个解析在进行, 其他的等待结果
+ * 3. 支持静态的host => ip映射, 类似/etc/hosts, 可以用线上域名爬取测试服务器
+ * host不区分大小写; 共享的解析使用独立的context(带超时), 某个调用方取消不影响其他等待者
+ */
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//共享解析的超时时间
+const lookupTimeout = 30 * time.Second
+
+//缓存的一条解析结果
+type record struct {
+	addrs  []string
+	err    error
+	expire time.Time
+}
+
+//正在进行的解析
+type call struct {
+	done  chan struct{}
+	addrs []string
+	err   error
+}
+
+//DNS缓存解析器
+type Resolver struct {
+	ttl         time.Duration         //成功结果的缓存时间, 0表示不缓存
+	negativeTtl time.Duration         //失败结果的缓存时间, 0表示不缓存
+	static      map[string][]string   //静态映射
+	sem         chan struct{}         //并发解析的信号量
+	lookup      func(ctx context.Context, host string) ([]string, error)
+	cache       map[string]*record
+	inflight    map[string]*call
+	mutex       sync.Mutex
+
+	hit         uint64 //缓存命中, 包括等待同一个host的解析并成功
+	negativeHit uint64 //负缓存命中
+	miss        uint64 //缓存未命中, 实际解析的次数
+	staticHit   uint64 //静态映射命中
+	failed      uint64 //解析失败的次数, 包括等待同一个host的解析并失败
+}
+
+//New
+func NewResolver(ttl, negativeTtl time.Duration, maxConcurrent int, static map[string][]string) *Resolver {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	//静态映射的host统一小写
+	normalized := make(map[string][]string, len(static))
+	for host, addrs := range static {
+		normalized[normalizeHost(host)] = addrs
+	}
+	return &Resolver{
+		ttl:         ttl,
+		negativeTtl: negativeTtl,
+		static:      normalized,
+		sem:         make(chan struct{}, maxConcurrent),
+		lookup:      net.DefaultResolver.LookupHost,
+		cache:       make(map[string]*record),
+		inflight:    make(map[string]*call),
+	}
+}
+
+//host规范化: 小写, 去掉末尾的.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+//解析host, 返回ip列表
+func (r *Resolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	host = normalizeHost(host)
+
+	//静态映射优先
+	if addrs, ok := r.static[host]; ok {
+		atomic.AddUint64(&r.staticHit, 1)
+		return addrs, nil
+	}
+
+	r.mutex.Lock()
+	if rec, ok := r.cache[host]; ok && time.Now().Before(rec.expire) {
+		r.mutex.Unlock()
+		if rec.err != nil {
+			atomic.AddUint64(&r.negativeHit, 1)
+			return nil, rec.err
+		}
+		atomic.AddUint64(&r.hit, 1)
+		return rec.addrs, nil
+	}
+	//同一个host已经在解析, 则等待其结果; 否则发起一个共享的解析
+	c, ok := r.inflight[host]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		r.inflight[host] = c
+		atomic.AddUint64(&r.miss, 1)
+		go r.resolve(host, c)
+	}
+	r.mutex.Unlock()
+
+	select {
+	case <-c.done:
+		//发起者的统计在resolve中
+		if ok {
+			if c.err != nil {
+				atomic.AddUint64(&r.failed, 1)
+			} else {
+				atomic.AddUint64(&r.hit, 1)
+			}
+		}
+		return c.addrs, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//共享的解析, 使用独立的context, 不受任何一个调用方取消的影响
+func (r *Resolver) resolve(host string, c *call) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	c.addrs, c.err = r.doLookup(ctx, host)
+	if c.err != nil {
+		atomic.AddUint64(&r.failed, 1)
+	}
+
+	r.mutex.Lock()
+	delete(r.inflight, host)
+	ttl := r.ttl
+	if c.err != nil {
+		ttl = r.negativeTtl
+	}
+	if ttl > 0 {
+		r.cache[host] = &record{addrs: c.addrs, err: c.err, expire: time.Now().Add(ttl)}
+	}
+	r.mutex.Unlock()
+	close(c.done)
+}
+
+//受信号量限制的实际解析
+func (r *Resolver) doLookup(ctx context.Context, host string) ([]string, error) {
+	select {
+	case r.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-r.sem }()
+	return r.lookup(ctx, host)
+}
+
+//生成拨号函数, 供http.Transport.DialContext使用
+func (r *Resolver) DialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		//本身就是IP, 无需解析
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		if len(addrs) == 0 {
+			return nil, errors.New("No address for host: " + host)
+		}
+
+		//逐个尝试, 返回第一个成功的连接
+		var conn net.Conn
+		for _, ip := range addrs {
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+//用DNS缓存包装一个http.Client, 返回新的Client, 不修改原Client
+//只支持*http.Transport, 其他类型的Transport原样返回
+func (r *Resolver) WrapClient(client *http.Client) (*http.Client, bool) {
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone() //插件多半用的是http.DefaultTransport, 不能直接修改
+	default:
+		return client, false
+	}
+	transport.DialContext = r.DialContext(&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+
+	c := *client
+	c.Transport = transport
+	return &c, true
+}
+
+//摘要信息
+func (r *Resolver) Summary(prefix string) string {
+	if r == nil {
+		return prefix + "Disabled\n"
+	}
+	r.mutex.Lock()
+	cached := len(r.cache)
+	r.mutex.Unlock()
+	return fmt.Sprintf(prefix+"Cached: %d, Static: %d\n"+
+		prefix+"Hit: %d, NegativeHit: %d, StaticHit: %d, Miss: %d, Failed: %d\n",
+		cached, len(r.static),
+		atomic.LoadUint64(&r.hit), atomic.LoadUint64(&r.negativeHit), atomic.LoadUint64(&r.staticHit),
+		atomic.LoadUint64(&r.miss), atomic.LoadUint64(&r.failed))
+}
@@ -0,0 +1,127 @@
+package dnscache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLookupCache(t *testing.T) {
+	var cnt int32
+	r := NewResolver(time.Minute, time.Minute, 2, map[string][]string{"static.com": {"10.0.0.1"}})
+	r.lookup = func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt32(&cnt, 1)
+		if host == "bad.com" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"1.2.3.4"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		if addrs, err := r.LookupHost(context.Background(), "a.com"); err != nil || addrs[0] != "1.2.3.4" {
+			t.Fatal("Wrong lookup:", addrs, err)
+		}
+		if _, err := r.LookupHost(context.Background(), "bad.com"); err == nil {
+			t.Fatal("Should be error")
+		}
+	}
+	if addrs, _ := r.LookupHost(context.Background(), "Static.COM."); addrs[0] != "10.0.0.1" {
+		t.Fatal("Wrong static:", addrs)
+	}
+	//a.com和bad.com各只解析了一次
+	if cnt != 2 {
+		t.Fatal("Wrong lookup count:", cnt)
+	}
+	t.Log(r.Summary("    "))
+}
+
+func TestSharedLookup(t *testing.T) {
+	release := make(chan struct{})
+	r := NewResolver(0, 0, 2, nil)
+	r.lookup = func(ctx context.Context, host string) ([]string, error) {
+		<-release
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if host == "bad.com" {
+			return nil, errors.New("no such host")
+		}
+		return []string{"1.2.3.4"}, nil
+	}
+
+	//发起者取消, 等待者仍然拿到结果
+	ctx, cancel := context.WithCancel(context.Background())
+	leader := make(chan error)
+	go func() {
+		_, err := r.LookupHost(ctx, "a.com")
+		leader <- err
+	}()
+	waiter := make(chan []string)
+	go func() {
+		for atomic.LoadUint64(&r.miss) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		addrs, _ := r.LookupHost(context.Background(), "A.com")
+		waiter <- addrs
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-leader; err != context.Canceled {
+		t.Fatal("Leader should be canceled:", err)
+	}
+	close(release)
+	if addrs := <-waiter; len(addrs) != 1 || addrs[0] != "1.2.3.4" {
+		t.Fatal("Waiter should get the result:", addrs)
+	}
+
+	//等待同一个失败的解析, 算失败而不是命中
+	gate := make(chan struct{})
+	r = NewResolver(0, 0, 2, nil)
+	r.lookup = func(ctx context.Context, host string) ([]string, error) {
+		<-gate
+		return nil, errors.New("no such host")
+	}
+	done := make(chan error)
+	lookup := func() {
+		_, err := r.LookupHost(context.Background(), "bad.com")
+		done <- err
+	}
+	go lookup()
+	for atomic.LoadUint64(&r.miss) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go lookup()
+	time.Sleep(20 * time.Millisecond)
+	close(gate)
+	if <-done == nil || <-done == nil {
+		t.Fatal("Should be error")
+	}
+	if r.miss != 1 || r.hit != 0 || r.failed != 2 {
+		t.Fatal("Wrong stats:", r.Summary(""))
+	}
+}
+
+func TestStaticHostDial(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+	defer ts.Close()
+
+	//用一个不存在的域名访问测试服务器
+	port := ts.URL[len("http://127.0.0.1:"):]
+	r := NewResolver(0, 0, 1, map[string][]string{"WWW.Staging.test": {"127.0.0.1"}})
+	client, ok := r.WrapClient(&http.Client{})
+	if !ok {
+		t.Fatal("Wrap failed")
+	}
+	resp, err := client.Get("http://www.staging.test:" + port + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
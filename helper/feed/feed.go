@@ -0,0 +1,324 @@
+package feed
+
+/*
+ * RSS 2.0 / Atom 解析
+ * 新闻站点的feed比HTML列表页便宜得多: 一个请求就能拿到最新文章的链接、标题、时间和作者
+ * 解析结果统一为Feed和Entry, 不区分RSS和Atom
+ * 非utf8编码的feed(比如gbk)按XML声明中的encoding转码
+ */
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"golang.org/x/net/html/charset"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	TYPE_RSS  = "rss"
+	TYPE_ATOM = "atom"
+)
+
+//统一的feed结构
+type Feed struct {
+	Type        string //rss, atom
+	Title       string
+	Link        string //站点链接
+	Description string
+	Updated     string //原样保留
+	Entries     []*Entry
+}
+
+//一篇文章
+type Entry struct {
+	Title      string
+	Link       string //补全后的绝对Url
+	Id         string //RSS的guid, Atom的id
+	Published  string //原样保留, 需要时间可以用ParseTime
+	Updated    string
+	Author     string
+	Summary    string
+	Content    string //RSS的content:encoded, Atom的content
+	Categories []string
+}
+
+/************************** RSS 2.0 **************************/
+type rssDoc struct {
+	Channel rssChannel `xml:"channel"`
+	Items   []rssItem  `xml:"item"` //RSS 1.0(RDF)的item和channel平级
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	PubDate       string    `xml:"pubDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Author      string   `xml:"author"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Categories  []string `xml:"category"`
+}
+
+/************************** Atom **************************/
+type atomDoc struct {
+	Title    atomText    `xml:"title"`
+	Subtitle atomText    `xml:"subtitle"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Author   atomPerson  `xml:"author"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title      atomText       `xml:"title"`
+	Id         string         `xml:"id"`
+	Links      []atomLink     `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomPerson   `xml:"author"`
+	Summary    atomText       `xml:"summary"`
+	Content    atomText       `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Body  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+//xhtml类型的内容是子元素, 取innerxml; text和html类型取字符数据
+func (t atomText) String() string {
+	if t.Type == "xhtml" {
+		return strings.TrimSpace(t.Inner)
+	}
+	return strings.TrimSpace(t.Body)
+}
+
+//Atom的alternate链接, 没有rel的等同于alternate
+func alternateLink(links []atomLink) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+//解析feed, feedUrl用于补全相对链接, 可以为空
+func Parse(body []byte, feedUrl string) (*Feed, error) {
+	root, err := rootElement(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var f *Feed
+	switch root {
+	case "rss", "RDF":
+		doc := rssDoc{}
+		if err := newDecoder(body).Decode(&doc); err != nil {
+			return nil, err
+		}
+		f = fromRss(&doc)
+	case "feed":
+		doc := atomDoc{}
+		if err := newDecoder(body).Decode(&doc); err != nil {
+			return nil, err
+		}
+		f = fromAtom(&doc)
+	default:
+		return nil, errors.New("Not a feed, root element: " + root)
+	}
+
+	//补全相对链接
+	if base, err := url.Parse(feedUrl); err == nil && feedUrl != "" {
+		f.Link = resolve(base, f.Link)
+		for _, e := range f.Entries {
+			e.Link = resolve(base, e.Link)
+		}
+	}
+	return f, nil
+}
+
+//是否是feed: 根元素是rss, feed或者rdf:RDF
+func Sniff(body []byte) bool {
+	root, err := rootElement(body)
+	return err == nil && (root == "rss" || root == "feed" || root == "RDF")
+}
+
+func fromRss(doc *rssDoc) *Feed {
+	ch := doc.Channel
+	f := &Feed{
+		Type:        TYPE_RSS,
+		Title:       strings.TrimSpace(ch.Title),
+		Link:        strings.TrimSpace(ch.Link),
+		Description: strings.TrimSpace(ch.Description),
+		Updated:     firstNonEmpty(ch.LastBuildDate, ch.PubDate),
+	}
+	for _, it := range append(ch.Items, doc.Items...) {
+		e := &Entry{
+			Title:      strings.TrimSpace(it.Title),
+			Link:       strings.TrimSpace(it.Link),
+			Id:         strings.TrimSpace(it.Guid),
+			Published:  firstNonEmpty(it.PubDate, it.Date),
+			Author:     firstNonEmpty(it.Creator, it.Author),
+			Summary:    strings.TrimSpace(it.Description),
+			Content:    strings.TrimSpace(it.Content),
+			Categories: trimAll(it.Categories),
+		}
+		//没有link的, guid可能就是链接
+		if e.Link == "" && (strings.HasPrefix(e.Id, "http://") || strings.HasPrefix(e.Id, "https://")) {
+			e.Link = e.Id
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f
+}
+
+func fromAtom(doc *atomDoc) *Feed {
+	f := &Feed{
+		Type:        TYPE_ATOM,
+		Title:       doc.Title.String(),
+		Link:        strings.TrimSpace(alternateLink(doc.Links)),
+		Description: doc.Subtitle.String(),
+		Updated:     strings.TrimSpace(doc.Updated),
+	}
+	for _, it := range doc.Entries {
+		e := &Entry{
+			Title:     it.Title.String(),
+			Link:      strings.TrimSpace(alternateLink(it.Links)),
+			Id:        strings.TrimSpace(it.Id),
+			Published: firstNonEmpty(it.Published, it.Updated),
+			Updated:   strings.TrimSpace(it.Updated),
+			Summary:   it.Summary.String(),
+			Content:   it.Content.String(),
+		}
+		//entry没有作者的, 继承feed的作者
+		names := []string{}
+		for _, a := range it.Authors {
+			if n := strings.TrimSpace(a.Name); n != "" {
+				names = append(names, n)
+			}
+		}
+		if len(names) == 0 && strings.TrimSpace(doc.Author.Name) != "" {
+			names = append(names, strings.TrimSpace(doc.Author.Name))
+		}
+		e.Author = strings.Join(names, ", ")
+		for _, c := range it.Categories {
+			if t := strings.TrimSpace(c.Term); t != "" {
+				e.Categories = append(e.Categories, t)
+			}
+		}
+		f.Entries = append(f.Entries, e)
+	}
+	return f
+}
+
+//feed中常见的时间格式
+var timeLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	time.RFC3339Nano,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon, 02 Jan 2006 15:04 -0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+//解析feed中的时间
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Unknown time format: " + s)
+}
+
+//按XML声明中的encoding转码
+func newDecoder(body []byte) *xml.Decoder {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	d.CharsetReader = charset.NewReaderLabel
+	d.Strict = false
+	return d
+}
+
+//根元素的名字(不含命名空间)
+func rootElement(body []byte) (string, error) {
+	d := newDecoder(body)
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				return "", errors.New("Empty xml")
+			}
+			return "", err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name.Local, nil
+		}
+	}
+}
+
+func resolve(base *url.URL, href string) string {
+	if href == "" {
+		return href
+	}
+	u, err := base.Parse(href)
+	if err != nil {
+		return href
+	}
+	return u.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func trimAll(values []string) []string {
+	r := []string{}
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			r = append(r, v)
+		}
+	}
+	return r
+}
@@ -0,0 +1,100 @@
+package feed
+
+import (
+	"testing"
+)
+
+const testRss = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+<channel>
+	<title>360新闻</title>
+	<link>http://www.360.cn/news.html</link>
+	<description>最新新闻</description>
+	<item>
+		<title>第一条</title>
+		<link>/n/1.html</link>
+		<pubDate>Tue, 10 Jun 2003 04:00:00 GMT</pubDate>
+		<dc:creator>老周</dc:creator>
+		<description><![CDATA[<p>摘要</p>]]></description>
+		<content:encoded><![CDATA[<p>全文</p>]]></content:encoded>
+		<category>安全</category>
+		<category>公司</category>
+	</item>
+	<item>
+		<title>第二条</title>
+		<guid>http://www.360.cn/n/2.html</guid>
+		<author>a@360.cn</author>
+	</item>
+</channel>
+</rss>`
+
+const testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Example Feed</title>
+	<link href="http://example.org/"/>
+	<link rel="self" href="http://example.org/feed.atom"/>
+	<updated>2003-12-13T18:30:02Z</updated>
+	<author><name>John Doe</name></author>
+	<entry>
+		<title type="html">Atom &amp; more</title>
+		<link rel="alternate" href="/2003/12/13/atom03"/>
+		<link rel="edit" href="/edit/1"/>
+		<id>urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a</id>
+		<updated>2003-12-13T18:30:02Z</updated>
+		<summary>Some text.</summary>
+		<category term="tech"/>
+	</entry>
+</feed>`
+
+func TestParseRss(t *testing.T) {
+	f, err := Parse([]byte(testRss), "http://www.360.cn/rss.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != TYPE_RSS || f.Title != "360新闻" || len(f.Entries) != 2 {
+		t.Fatalf("Wrong feed: %+v", f)
+	}
+	e := f.Entries[0]
+	if e.Link != "http://www.360.cn/n/1.html" || e.Author != "老周" || e.Summary != "<p>摘要</p>" ||
+		e.Content != "<p>全文</p>" || len(e.Categories) != 2 || e.Published != "Tue, 10 Jun 2003 04:00:00 GMT" {
+		t.Fatalf("Wrong entry: %+v", e)
+	}
+	if _, err := ParseTime(e.Published); err != nil {
+		t.Fatal(err)
+	}
+	if e := f.Entries[1]; e.Link != "http://www.360.cn/n/2.html" || e.Author != "a@360.cn" {
+		t.Fatalf("Wrong entry: %+v", e)
+	}
+}
+
+func TestParseAtom(t *testing.T) {
+	f, err := Parse([]byte(testAtom), "http://example.org/feed.atom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Type != TYPE_ATOM || f.Title != "Example Feed" || f.Link != "http://example.org/" || len(f.Entries) != 1 {
+		t.Fatalf("Wrong feed: %+v", f)
+	}
+	e := f.Entries[0]
+	if e.Title != "Atom & more" || e.Link != "http://example.org/2003/12/13/atom03" || e.Author != "John Doe" ||
+		e.Published != "2003-12-13T18:30:02Z" || e.Categories[0] != "tech" {
+		t.Fatalf("Wrong entry: %+v", e)
+	}
+}
+
+func TestParseGbk(t *testing.T) {
+	//gbk编码的"你好"
+	body := append([]byte(`<?xml version="1.0" encoding="gbk"?><rss><channel><title>`), 0xc4, 0xe3, 0xba, 0xc3)
+	body = append(body, []byte(`</title></channel></rss>`)...)
+	f, err := Parse(body, "")
+	if err != nil || f.Title != "你好" {
+		t.Fatalf("Wrong gbk feed: %v %v", f, err)
+	}
+}
+
+func TestSniff(t *testing.T) {
+	if !Sniff([]byte(testRss)) || !Sniff([]byte(testAtom)) || Sniff([]byte(`<html><body></body></html>`)) ||
+		Sniff([]byte(`<?xml version="1.0"?><urlset></urlset>`)) || Sniff(nil) {
+		t.Fatal("Wrong sniff")
+	}
+}
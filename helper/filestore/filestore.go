@@ -0,0 +1,99 @@
+package filestore
+
+/*
+ * 内容寻址的本地文件存储
+ * 文件以内容的sha256命名, 相同内容只存一份(去重)
+ * 写入过程是流式的, 边读边写边计算校验和, 不会把整个文件读进内存
+ */
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+var ErrTooLarge = errors.New("File too large")
+
+//存储结果
+type SaveResult struct {
+	Path   string //存储路径
+	Size   int64  //文件大小
+	Sha256 string //内容校验和
+	Dedup  bool   //内容已经存在, 本次没有实际写入
+}
+
+//文件存储实现类型
+type Store struct {
+	dir    string
+	saved  uint64 //实际写入的文件数
+	dedup  uint64 //去重的文件数
+	bytes  uint64 //实际写入的字节数
+}
+
+//New
+func NewStore(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, errors.New("The store dir can not be empty!")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+//流式存储, maxSize为0表示不限制大小, ext是文件扩展名(不含.)
+//超过maxSize返回ErrTooLarge, 已写入的临时文件会被删除
+func (s *Store) Save(r io.Reader, maxSize int64, ext string) (*SaveResult, error) {
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name()) //rename成功之后, 这里的删除会失败, 无影响
+
+	if maxSize > 0 {
+		r = io.LimitReader(r, maxSize+1) //多读一个字节, 用来判断是否超过上限
+	}
+	h := sha256.New()
+	size, err := io.Copy(tmp, io.TeeReader(r, h))
+	tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && size > maxSize {
+		return nil, ErrTooLarge
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	name := sum
+	if ext = strings.Trim(strings.ToLower(ext), ". /"); ext != "" {
+		name += "." + ext
+	}
+	//按校验和前四位分两级目录, 防止单目录文件过多
+	p := filepath.Join(s.dir, sum[:2], sum[2:4], name)
+	result := &SaveResult{Path: p, Size: size, Sha256: sum}
+
+	if _, err := os.Stat(p); err == nil {
+		result.Dedup = true
+		atomic.AddUint64(&s.dedup, 1)
+		return result, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&s.saved, 1)
+	atomic.AddUint64(&s.bytes, uint64(size))
+	return result, nil
+}
+
+//实际写入的文件数, 去重的文件数, 实际写入的字节数
+func (s *Store) Stats() (saved, dedup, bytes uint64) {
+	return atomic.LoadUint64(&s.saved), atomic.LoadUint64(&s.dedup), atomic.LoadUint64(&s.bytes)
+}
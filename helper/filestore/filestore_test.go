@@ -0,0 +1,46 @@
+package filestore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r1, err := s.Save(strings.NewReader("%PDF-1.4 hello"), 100, ".PDF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r1.Dedup || r1.Size != 14 || !strings.HasSuffix(r1.Path, r1.Sha256+".pdf") {
+		t.Fatal("Wrong result:", r1)
+	}
+
+	//相同内容去重
+	r2, err := s.Save(strings.NewReader("%PDF-1.4 hello"), 100, "pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r2.Dedup || r2.Path != r1.Path {
+		t.Fatal("Should dedup:", r2)
+	}
+
+	//超过上限
+	if _, err := s.Save(strings.NewReader(strings.Repeat("a", 101)), 100, ""); err != ErrTooLarge {
+		t.Fatal("Should be too large:", err)
+	}
+	if saved, dedup, bytes := s.Stats(); saved != 1 || dedup != 1 || bytes != 14 {
+		t.Fatal("Wrong stats:", saved, dedup, bytes)
+	}
+}
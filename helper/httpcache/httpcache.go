@@ -0,0 +1,308 @@
+package httpcache
+
+/*
+ * 录制/回放HTTP缓存
+ * 实现http.RoundTripper, 把每次抓取到的响应(状态码, 头, Body)存储到本地磁盘, key是方法、规范化之后的Url和请求Body的摘要
+ * 开发分析函数的时候, 不必每次都重新爬取线上站点, 分析结果也是确定的
+ * Body在调用方读取的同时写入缓存(不预先读取), 大小限制、嗅探终止和读超时仍由调用方控制;
+ * 只有完整读完的、状态码为2xx/3xx的、文本类型(含JSON/XML)且不超过大小上限的响应才会被存储
+ * 三种模式:
+ * 1. record: 总是请求网络, 并把结果存储下来
+ * 2. replay: 完全离线, 只从缓存中读取, 未命中则返回错误
+ * 3. cache-first: 优先读缓存, 未命中再请求网络并存储
+ */
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+//缓存模式常量
+const (
+	MODE_OFF         = "off"
+	MODE_RECORD      = "record"
+	MODE_REPLAY      = "replay"
+	MODE_CACHE_FIRST = "cache-first"
+)
+
+//缓存中存储的一条响应
+type entry struct {
+	Method     string      `json:"method"`
+	Url        string      `json:"url"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+//*Transport实现http.RoundTripper接口
+type Transport struct {
+	mode    string
+	dir     string
+	next    http.RoundTripper //实际发送请求的RoundTripper
+	maxSize int64             //存储的Body大小上限, 超过则不存储, 0表示不限制
+	hit     uint64
+	miss    uint64
+	stored  uint64
+}
+
+//New, next为nil则使用http.DefaultTransport
+func NewTransport(mode, dir string, maxSize int64, next http.RoundTripper) (*Transport, error) {
+	switch mode {
+	case MODE_RECORD, MODE_REPLAY, MODE_CACHE_FIRST:
+	default:
+		return nil, errors.New("Unsupported httpcache mode: " + mode)
+	}
+	if dir == "" {
+		return nil, errors.New("The httpcache dir can not be empty!")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{
+		mode:    mode,
+		dir:     dir,
+		next:    next,
+		maxSize: maxSize,
+	}, nil
+}
+
+//用缓存包装一个http.Client, 返回新的Client, 不修改原Client
+//mode为off或者空时, 直接返回原Client
+func WrapClient(client *http.Client, mode, dir string, maxSize int64) (*http.Client, error) {
+	if mode == "" || mode == MODE_OFF {
+		return client, nil
+	}
+	t, err := NewTransport(mode, dir, maxSize, client.Transport)
+	if err != nil {
+		return nil, err
+	}
+	c := *client
+	c.Transport = t
+	return &c, nil
+}
+
+//实现http.RoundTripper接口
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := requestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := Key(req.Method, req.URL, body)
+
+	if t.mode == MODE_REPLAY || t.mode == MODE_CACHE_FIRST {
+		if resp, ok := t.load(key, req); ok {
+			atomic.AddUint64(&t.hit, 1)
+			return resp, nil
+		}
+		atomic.AddUint64(&t.miss, 1)
+		if t.mode == MODE_REPLAY {
+			return nil, errors.New("httpcache miss in replay mode: " + req.Method + " " + req.URL.String())
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if !cacheable(resp) {
+		return resp, nil
+	}
+
+	//Body在调用方读取的同时写入缓冲, 读到EOF时存储; 超过上限或者没有读完(调用方提前终止)则放弃
+	e := &entry{
+		Method:     req.Method,
+		Url:        req.URL.String(),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+	}
+	resp.Body = &teeBody{rc: resp.Body, max: t.maxSize, onEOF: func(data []byte) {
+		e.Body = data
+		if err := t.store(key, e); err == nil {
+			atomic.AddUint64(&t.stored, 1)
+		}
+	}}
+	return resp, nil
+}
+
+//读取请求的Body用于计算key, 优先用GetBody取副本, 否则读出后重新封装
+func requestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+//只存储2xx/3xx的文本类型(含JSON/XML)响应, 二进制和错误响应不存储
+func cacheable(resp *http.Response) bool {
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return false
+	}
+	ct := resp.Header.Get("Content-Type")
+	if ct == "" {
+		return true //由调用方嗅探
+	}
+	mimeType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mimeType, "text/") || strings.HasSuffix(mimeType, "json") ||
+		strings.HasSuffix(mimeType, "xml") || strings.HasSuffix(mimeType, "javascript")
+}
+
+//边读边缓冲的Body
+type teeBody struct {
+	rc       io.ReadCloser
+	buf      bytes.Buffer
+	max      int64
+	overflow bool
+	done     bool
+	onEOF    func(data []byte)
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if !b.overflow && n > 0 {
+		if b.max > 0 && int64(b.buf.Len()+n) > b.max {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !b.overflow && !b.done {
+		b.done = true
+		b.onEOF(b.buf.Bytes())
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	return b.rc.Close()
+}
+
+//从磁盘加载缓存的响应
+func (t *Transport) load(key string, req *http.Request) (*http.Response, bool) {
+	data, err := ioutil.ReadFile(t.path(key))
+	if err != nil {
+		return nil, false
+	}
+	e := entry{}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, false
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}, true
+}
+
+//存储响应到磁盘, 先写临时文件再rename, 防止并发读到半截的文件
+func (t *Transport) store(key string, e *entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	p := t.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	tmp.Close()
+	return os.Rename(tmp.Name(), p)
+}
+
+//缓存文件路径, 按key前两位分目录, 防止单目录文件过多
+func (t *Transport) path(key string) string {
+	return filepath.Join(t.dir, key[:2], key+".json")
+}
+
+//命中、未命中、存储的次数
+func (t *Transport) Stats() (hit, miss, stored uint64) {
+	return atomic.LoadUint64(&t.hit), atomic.LoadUint64(&t.miss), atomic.LoadUint64(&t.stored)
+}
+
+//生成缓存的key: 方法 + 规范化的Url + Body的摘要(和basic.Request.Fingerprint一致), 再取sha1
+func Key(method string, u *url.URL, body []byte) string {
+	s := strings.ToUpper(method) + " " + CanonicalUrl(u)
+	if len(body) > 0 {
+		sum := sha1.Sum(body)
+		s += " #" + hex.EncodeToString(sum[:8])
+	}
+	h := sha1.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+//规范化Url: scheme和host小写, 去掉默认端口和#片段, 查询参数排序, 去掉末尾的/
+func CanonicalUrl(u *url.URL) string {
+	c := *u
+	c.Scheme = strings.ToLower(c.Scheme)
+	c.Host = strings.ToLower(c.Host)
+	if (c.Scheme == "http" && strings.HasSuffix(c.Host, ":80")) ||
+		(c.Scheme == "https" && strings.HasSuffix(c.Host, ":443")) {
+		c.Host = c.Host[:strings.LastIndex(c.Host, ":")]
+	}
+	c.Fragment = ""
+	if c.RawQuery != "" {
+		q := c.Query()
+		keys := make([]string, 0, len(q))
+		for k := range q {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, 0, len(keys))
+		for _, k := range keys {
+			vs := q[k]
+			sort.Strings(vs)
+			for _, v := range vs {
+				parts = append(parts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+			}
+		}
+		c.RawQuery = strings.Join(parts, "&")
+	}
+	return strings.TrimRight(c.String(), "/")
+}
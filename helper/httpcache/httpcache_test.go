@@ -0,0 +1,135 @@
+package httpcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html>"+r.URL.Path+"</html>")
+	}))
+
+	//录制
+	client, err := WrapClient(&http.Client{}, MODE_RECORD, dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(ts.URL + "/a?y=2&x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body) //读完才会存储
+	resp.Body.Close()
+	ts.Close()
+
+	//回放, 服务已经关闭, 完全离线
+	client, _ = WrapClient(&http.Client{}, MODE_REPLAY, dir, 0)
+	resp, err = client.Get(ts.URL + "/a?x=1&y=2#top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "<html>/a</html>" || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatal("Wrong replay:", string(body), resp.Header)
+	}
+
+	//回放未命中是错误
+	if _, err = client.Get(ts.URL + "/b"); err == nil {
+		t.Fatal("Replay miss should be error")
+	}
+}
+
+func TestStoreFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+		case "/pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+		case "/big":
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, strings.Repeat("x", 100))
+			return
+		case "/post":
+			w.Header().Set("Content-Type", "application/json")
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Write(body)
+			return
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	tr, _ := NewTransport(MODE_RECORD, dir, 50, nil)
+	client := &http.Client{Transport: tr}
+	fetch := func(method, path, body string, readAll bool) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if readAll {
+			ioutil.ReadAll(resp.Body)
+		} else {
+			resp.Body.Read(make([]byte, 1))
+		}
+		resp.Body.Close()
+	}
+	//错误响应, 二进制类型, 超过大小上限, 没有读完的, 都不存储
+	fetch("GET", "/404", "", true)
+	fetch("GET", "/pdf", "", true)
+	fetch("GET", "/big", "", true)
+	fetch("GET", "/partial", "", false)
+	if _, _, stored := tr.Stats(); stored != 0 {
+		t.Fatal("Should not store:", stored)
+	}
+
+	//相同Url不同Body的POST分别存储
+	fetch("POST", "/post", `{"page":1}`, true)
+	fetch("POST", "/post", `{"page":2}`, true)
+	if _, _, stored := tr.Stats(); stored != 2 {
+		t.Fatal("Should store both posts:", stored)
+	}
+	replay, _ := NewTransport(MODE_REPLAY, dir, 0, nil)
+	client = &http.Client{Transport: replay}
+	req, _ := http.NewRequest("POST", ts.URL+"/post", strings.NewReader(`{"page":2}`))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != `{"page":2}` {
+		t.Fatal("Wrong replay body:", string(body))
+	}
+}
+
+func TestCanonicalUrl(t *testing.T) {
+	u, _ := url.Parse("HTTP://WWW.360.cn:80/n/?b=2&a=1#x")
+	if c := CanonicalUrl(u); c != "http://www.360.cn/n/?a=1&b=2" {
+		t.Fatal("Wrong canonical url:", c)
+	}
+}
@@ -0,0 +1,260 @@
+package jsonpath
+
+/*
+ * JSONPath求值
+ * 作用在encoding/json解析出来的数据上(map[string]interface{}, []interface{}, string, json.Number/float64, bool, nil)
+ *
+ * 支持的语法:
+ * $                根
+ * .name ['name']   成员
+ * .* [*]           全部成员或元素
+ * ..name ..*       递归下降
+ * [0] [-1]         下标, 负数从末尾算起
+ * [1:3] [::2]      切片
+ * [0,2] ['a','b']  并集
+ * [?(@.price < 10 && @.tag == 'x')]  过滤, 支持 == != < <= > >= && || ! 和括号, 单独的路径表示存在且不是false/null
+ * 省略开头的$则视为从根开始, 比如data.items等价于$.data.items
+ */
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//编译好的JSONPath, 可以并发使用
+type Path struct {
+	src  string
+	segs []*segment
+}
+
+//路径中的一段
+type segment struct {
+	recursive bool //..递归下降
+	selectors []selector
+}
+
+//选择器类型
+const (
+	selName = iota
+	selWildcard
+	selIndex
+	selSlice
+	selFilter
+)
+
+type selector struct {
+	kind             int
+	name             string
+	index            int
+	start, end, step int
+	hasStart, hasEnd bool
+	filter           filterNode
+}
+
+//编译
+func Compile(src string) (p *Path, err error) {
+	path := strings.TrimSpace(src)
+	if path == "" {
+		return nil, errors.New("jsonpath: empty path")
+	}
+	switch path[0] {
+	case '$':
+		path = path[1:]
+	case '.', '[':
+	default:
+		path = "." + path
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*syntaxError)
+			if !ok {
+				panic(r)
+			}
+			p, err = nil, se
+		}
+	}()
+	s := &scanner{src: src, text: path}
+	segs := s.parsePath()
+	if s.pos < len(s.text) {
+		s.fail("unexpected character")
+	}
+	return &Path{src: src, segs: segs}, nil
+}
+
+//编译, 出错则panic, 用于路径是常量的场景
+func MustCompile(src string) *Path {
+	p, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p *Path) String() string {
+	return p.src
+}
+
+//求值, 返回全部匹配的值
+func (p *Path) Find(data interface{}) []interface{} {
+	return apply(p.segs, []interface{}{data}, data)
+}
+
+//返回第一个匹配的值
+func (p *Path) First(data interface{}) (interface{}, bool) {
+	values := p.Find(data)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[0], true
+}
+
+//编译并求值
+func Find(data interface{}, src string) ([]interface{}, error) {
+	p, err := Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return p.Find(data), nil
+}
+
+//把值转换成字符串: 字符串和数字原样输出, null为空串, 对象和数组编码成JSON
+func ToString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+//依次对每一段求值
+func apply(segs []*segment, nodes []interface{}, root interface{}) []interface{} {
+	for _, seg := range segs {
+		out := []interface{}{}
+		for _, n := range nodes {
+			if seg.recursive {
+				walk(n, func(v interface{}) {
+					out = seg.selectFrom(v, root, out)
+				})
+			} else {
+				out = seg.selectFrom(n, root, out)
+			}
+		}
+		nodes = out
+	}
+	return nodes
+}
+
+//先序遍历v及其全部后代, 对象的成员按key排序, 保证结果确定
+func walk(v interface{}, fn func(interface{})) {
+	fn(v)
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(v) {
+			walk(v[k], fn)
+		}
+	case []interface{}:
+		for _, e := range v {
+			walk(e, fn)
+		}
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (seg *segment) selectFrom(v interface{}, root interface{}, out []interface{}) []interface{} {
+	for i := range seg.selectors {
+		out = seg.selectors[i].selectFrom(v, root, out)
+	}
+	return out
+}
+
+func (sel *selector) selectFrom(v interface{}, root interface{}, out []interface{}) []interface{} {
+	switch sel.kind {
+	case selName:
+		if m, ok := v.(map[string]interface{}); ok {
+			if e, ok := m[sel.name]; ok {
+				out = append(out, e)
+			}
+		}
+	case selWildcard:
+		out = append(out, children(v)...)
+	case selIndex:
+		if a, ok := v.([]interface{}); ok {
+			i := sel.index
+			if i < 0 {
+				i += len(a)
+			}
+			if i >= 0 && i < len(a) {
+				out = append(out, a[i])
+			}
+		}
+	case selSlice:
+		if a, ok := v.([]interface{}); ok {
+			start, end := 0, len(a)
+			if sel.hasStart {
+				start = normalizeIndex(sel.start, len(a))
+			}
+			if sel.hasEnd {
+				end = normalizeIndex(sel.end, len(a))
+			}
+			for i := start; i < end; i += sel.step {
+				out = append(out, a[i])
+			}
+		}
+	case selFilter:
+		for _, e := range children(v) {
+			if truth(sel.filter, root, e) {
+				out = append(out, e)
+			}
+		}
+	}
+	return out
+}
+
+//切片下标: 负数从末尾算起, 然后限制在[0, n]之内
+func normalizeIndex(i, n int) int {
+	if i < 0 {
+		i += n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i > n {
+		return n
+	}
+	return i
+}
+
+//对象的全部成员, 或者数组的全部元素
+func children(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make([]interface{}, 0, len(v))
+		for _, k := range sortedKeys(v) {
+			out = append(out, v[k])
+		}
+		return out
+	case []interface{}:
+		return v
+	}
+	return nil
+}
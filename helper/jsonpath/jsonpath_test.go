@@ -0,0 +1,84 @@
+package jsonpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testData = `{
+	"data": {
+		"items": [
+			{"id": 9007199254740993, "title": "a", "price": 8, "tags": ["x", "y"]},
+			{"id": 2, "title": "b", "price": 12.5, "hot": true},
+			{"id": 3, "title": "c", "price": 3, "hot": false, "author": {"name": "z"}}
+		],
+		"next_cursor": "abc",
+		"has_more": true
+	},
+	"author": {"name": "root"}
+}`
+
+func decode(t *testing.T) interface{} {
+	d := json.NewDecoder(bytes.NewReader([]byte(testData)))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestFind(t *testing.T) {
+	data := decode(t)
+	cases := []struct {
+		path string
+		want string
+	}{
+		{"$.data.next_cursor", "abc"},
+		{"data.has_more", "true"},
+		{"$['data']['next_cursor']", "abc"},
+		{"$.data.items[0].id", "9007199254740993"},
+		{"$.data.items[-1].title", "c"},
+		{"$.data.items[*].title", "a,b,c"},
+		{"$.data.items[0,2].title", "a,c"},
+		{"$.data.items[1:].title", "b,c"},
+		{"$.data.items[:2].title", "a,b"},
+		{"$.data.items[::2].title", "a,c"},
+		{"$.data.items[0].tags[*]", "x,y"},
+		{"$..name", "root,z"},
+		{"$.data.items[?(@.price > 5)].title", "a,b"},
+		{"$.data.items[?(@.price >= 3 && @.price < 10)].title", "a,c"},
+		{"$.data.items[?(@.hot)].title", "b"},
+		{"$.data.items[?(!@.hot)].title", "a,c"},
+		{"$.data.items[?(@.title == 'b' || @.author.name == \"z\")].id", "2,3"},
+		{"$.data.items[?(@.id == 2)].title", "b"},
+		{"$.data.items[?(@.title != 'a')].title", "b,c"},
+		{"$.data.items[?(@.price < $.data.items[1].price)].title", "a,c"},
+		{"$.data.items[0].tags", `["x","y"]`},
+		{"$.data.missing", ""},
+	}
+	for _, c := range cases {
+		values, err := Find(data, c.path)
+		if err != nil {
+			t.Errorf("%s: %s", c.path, err)
+			continue
+		}
+		strs := []string{}
+		for _, v := range values {
+			strs = append(strs, ToString(v))
+		}
+		if got := strings.Join(strs, ","); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.path, got, c.want)
+		}
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, src := range []string{"", "$.", "$[", "$['a'", "$[?(@.a ==)]", "$[::0]", "$.a]"} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("%q: expect error", src)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//解析错误, 解析过程中以panic抛出, 在Compile中recover
+type syntaxError struct {
+	msg string
+}
+
+func (e *syntaxError) Error() string {
+	return e.msg
+}
+
+type scanner struct {
+	src  string //原始路径, 用于错误信息
+	text string //去掉开头$之后的部分
+	pos  int
+}
+
+func (s *scanner) peek() byte {
+	if s.pos >= len(s.text) {
+		return 0
+	}
+	return s.text[s.pos]
+}
+
+func (s *scanner) hasPrefix(p string) bool {
+	return strings.HasPrefix(s.text[s.pos:], p)
+}
+
+func (s *scanner) skipSpace() {
+	for s.pos < len(s.text) && (s.text[s.pos] == ' ' || s.text[s.pos] == '\t') {
+		s.pos++
+	}
+}
+
+func (s *scanner) expect(c byte) {
+	s.skipSpace()
+	if s.peek() != c {
+		s.fail("expect " + string(c))
+	}
+	s.pos++
+}
+
+func (s *scanner) fail(msg string) {
+	panic(&syntaxError{fmt.Sprintf("jsonpath: %s at %d in %q", msg, s.pos, s.src)})
+}
+
+//解析若干段, 直到不是.或者[开头
+func (s *scanner) parsePath() []*segment {
+	segs := []*segment{}
+	for {
+		seg := &segment{}
+		switch {
+		case s.hasPrefix(".."):
+			s.pos += 2
+			seg.recursive = true
+			if s.peek() == '[' {
+				seg.selectors = s.parseBracket()
+			} else {
+				seg.selectors = []selector{s.parseDotName()}
+			}
+		case s.peek() == '.':
+			s.pos++
+			seg.selectors = []selector{s.parseDotName()}
+		case s.peek() == '[':
+			seg.selectors = s.parseBracket()
+		default:
+			return segs
+		}
+		segs = append(segs, seg)
+	}
+}
+
+//.之后的名字或者*
+func (s *scanner) parseDotName() selector {
+	if s.peek() == '*' {
+		s.pos++
+		return selector{kind: selWildcard}
+	}
+	start := s.pos
+	for s.pos < len(s.text) && !strings.ContainsRune(".[]()=!<>&|, \t", rune(s.text[s.pos])) {
+		s.pos++
+	}
+	if s.pos == start {
+		s.fail("expect name")
+	}
+	return selector{kind: selName, name: s.text[start:s.pos]}
+}
+
+//[...]中的一个或多个选择器
+func (s *scanner) parseBracket() []selector {
+	s.expect('[')
+	sels := []selector{}
+	for {
+		s.skipSpace()
+		sels = append(sels, s.parseSelector())
+		s.skipSpace()
+		if s.peek() == ',' {
+			s.pos++
+			continue
+		}
+		s.expect(']')
+		return sels
+	}
+}
+
+func (s *scanner) parseSelector() selector {
+	c := s.peek()
+	switch {
+	case c == '*':
+		s.pos++
+		return selector{kind: selWildcard}
+	case c == '\'' || c == '"':
+		return selector{kind: selName, name: s.parseString()}
+	case c == '?':
+		s.pos++
+		s.expect('(')
+		f := s.parseOr()
+		s.expect(')')
+		return selector{kind: selFilter, filter: f}
+	case c == '-' || c == ':' || isDigit(c):
+		sel := selector{kind: selIndex, step: 1}
+		if c != ':' {
+			sel.start, sel.hasStart = s.parseInt(), true
+			if s.skipSpace(); s.peek() != ':' {
+				sel.index = sel.start
+				return sel
+			}
+		}
+		//切片 start:end:step
+		sel.kind = selSlice
+		s.pos++
+		if s.skipSpace(); s.peek() == '-' || isDigit(s.peek()) {
+			sel.end, sel.hasEnd = s.parseInt(), true
+		}
+		if s.skipSpace(); s.peek() == ':' {
+			s.pos++
+			if s.skipSpace(); s.peek() == '-' || isDigit(s.peek()) {
+				sel.step = s.parseInt()
+			}
+		}
+		if sel.step <= 0 {
+			s.fail("slice step must be positive")
+		}
+		return sel
+	}
+	s.fail("unexpected character")
+	return selector{}
+}
+
+func (s *scanner) parseInt() int {
+	start := s.pos
+	if s.peek() == '-' {
+		s.pos++
+	}
+	for isDigit(s.peek()) {
+		s.pos++
+	}
+	i, err := strconv.Atoi(s.text[start:s.pos])
+	if err != nil {
+		s.fail("bad integer")
+	}
+	return i
+}
+
+//单引号或双引号的字符串, 支持\转义
+func (s *scanner) parseString() string {
+	quote := s.peek()
+	s.pos++
+	var b strings.Builder
+	for {
+		c := s.peek()
+		switch {
+		case c == 0:
+			s.fail("unterminated string")
+		case c == '\\' && s.pos+1 < len(s.text):
+			b.WriteByte(s.text[s.pos+1])
+			s.pos += 2
+		case c == quote:
+			s.pos++
+			return b.String()
+		default:
+			b.WriteByte(c)
+			s.pos++
+		}
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+//过滤表达式的节点, 返回值以及是否有值(路径没有匹配则没有值)
+type filterNode interface {
+	eval(root, cur interface{}) (interface{}, bool)
+}
+
+//过滤表达式的真假: 有值且不是false和null
+func truth(n filterNode, root, cur interface{}) bool {
+	v, ok := n.eval(root, cur)
+	return ok && v != nil && v != false
+}
+
+//@或者$开头的路径, 取第一个匹配的值
+type pathOperand struct {
+	fromRoot bool
+	segs     []*segment
+}
+
+func (o *pathOperand) eval(root, cur interface{}) (interface{}, bool) {
+	start := cur
+	if o.fromRoot {
+		start = root
+	}
+	values := apply(o.segs, []interface{}{start}, root)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[0], true
+}
+
+type literalOperand struct {
+	v interface{}
+}
+
+func (o *literalOperand) eval(root, cur interface{}) (interface{}, bool) {
+	return o.v, true
+}
+
+type logicNode struct {
+	op   string
+	l, r filterNode
+}
+
+func (n *logicNode) eval(root, cur interface{}) (interface{}, bool) {
+	if n.op == "&&" {
+		return truth(n.l, root, cur) && truth(n.r, root, cur), true
+	}
+	return truth(n.l, root, cur) || truth(n.r, root, cur), true
+}
+
+type notNode struct {
+	n filterNode
+}
+
+func (n *notNode) eval(root, cur interface{}) (interface{}, bool) {
+	return !truth(n.n, root, cur), true
+}
+
+type compareNode struct {
+	op   string
+	l, r filterNode
+}
+
+func (n *compareNode) eval(root, cur interface{}) (interface{}, bool) {
+	lv, lok := n.l.eval(root, cur)
+	rv, rok := n.r.eval(root, cur)
+	if !lok || !rok {
+		return n.op == "!=" && lok != rok, true
+	}
+	return compareValues(n.op, lv, rv), true
+}
+
+//比较: 数字按数值, 字符串按字典序, 其他类型只能判断相等
+func compareValues(op string, l, r interface{}) bool {
+	if lf, ok := toFloat(l); ok {
+		if rf, ok := toFloat(r); ok {
+			switch op {
+			case "==":
+				return lf == rf
+			case "!=":
+				return lf != rf
+			case "<":
+				return lf < rf
+			case "<=":
+				return lf <= rf
+			case ">":
+				return lf > rf
+			case ">=":
+				return lf >= rf
+			}
+		}
+	}
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			switch op {
+			case "==":
+				return ls == rs
+			case "!=":
+				return ls != rs
+			case "<":
+				return ls < rs
+			case "<=":
+				return ls <= rs
+			case ">":
+				return ls > rs
+			case ">=":
+				return ls >= rs
+			}
+		}
+	}
+	switch op {
+	case "==":
+		return isScalar(l) && isScalar(r) && l == r
+	case "!=":
+		return !(isScalar(l) && isScalar(r) && l == r)
+	}
+	return false
+}
+
+//只有标量可以直接用==比较, map和slice比较会panic
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+//Or := And ('||' And)*
+func (s *scanner) parseOr() filterNode {
+	left := s.parseAnd()
+	for s.skipSpace(); s.hasPrefix("||"); s.skipSpace() {
+		s.pos += 2
+		left = &logicNode{op: "||", l: left, r: s.parseAnd()}
+	}
+	return left
+}
+
+//And := Unary ('&&' Unary)*
+func (s *scanner) parseAnd() filterNode {
+	left := s.parseUnary()
+	for s.skipSpace(); s.hasPrefix("&&"); s.skipSpace() {
+		s.pos += 2
+		left = &logicNode{op: "&&", l: left, r: s.parseUnary()}
+	}
+	return left
+}
+
+//Unary := '!' Unary | '(' Or ')' | Operand (CompareOp Operand)?
+func (s *scanner) parseUnary() filterNode {
+	s.skipSpace()
+	switch {
+	case s.peek() == '!' && !s.hasPrefix("!="):
+		s.pos++
+		return &notNode{n: s.parseUnary()}
+	case s.peek() == '(':
+		s.pos++
+		n := s.parseOr()
+		s.expect(')')
+		return n
+	}
+
+	left := s.parseOperand()
+	s.skipSpace()
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if s.hasPrefix(op) {
+			s.pos += len(op)
+			s.skipSpace()
+			return &compareNode{op: op, l: left, r: s.parseOperand()}
+		}
+	}
+	return left
+}
+
+//Operand := '@' Path | '$' Path | 字符串 | 数字 | true | false | null
+func (s *scanner) parseOperand() filterNode {
+	c := s.peek()
+	switch {
+	case c == '@' || c == '$':
+		s.pos++
+		return &pathOperand{fromRoot: c == '$', segs: s.parsePath()}
+	case c == '\'' || c == '"':
+		return &literalOperand{v: s.parseString()}
+	case c == '-' || isDigit(c):
+		start := s.pos
+		s.pos++
+		for isDigit(s.peek()) || strings.IndexByte(".eE+-", s.peek()) >= 0 {
+			s.pos++
+		}
+		if _, err := strconv.ParseFloat(s.text[start:s.pos], 64); err != nil {
+			s.fail("bad number")
+		}
+		return &literalOperand{v: json.Number(s.text[start:s.pos])}
+	}
+	for word, v := range map[string]interface{}{"true": true, "false": false, "null": nil} {
+		if s.hasPrefix(word) {
+			s.pos += len(word)
+			return &literalOperand{v: v}
+		}
+	}
+	s.fail("expect operand")
+	return nil
+}
@@ -0,0 +1,415 @@
+package keyword
+
+/*
+ * 关键字查询
+ * 语法:
+ *   老周 360          多个词, 默认AND
+ *   老周 OR 红衣教主   OR, 也可以写成 ||
+ *   老周 AND NOT 广告  AND/NOT, 也可以写成 && 和 !, 词前面加-等同于NOT: 老周 -广告
+ *   (360 OR 奇虎) 老周 括号分组, 优先级 NOT > AND > OR
+ *   "red team"        引号括起来的短语, 可以包含空格和关键字
+ *   /周.{0,2}祎/      斜杠括起来的正则
+ * 匹配时忽略大小写和全角半角的区别(比如"ＡＢＣ"和"abc"), 返回每个词的命中次数和命中处的上下文
+ */
+import (
+	"errors"
+	"golang.org/x/text/width"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+//默认上下文长度(命中处前后各多少个字)和每个词最多保留的上下文数量
+const (
+	DEFAULT_SNIPPET_LEN  = 30
+	DEFAULT_MAX_SNIPPETS = 5
+)
+
+//编译后的查询
+type Query struct {
+	src         string
+	root        node
+	terms       []*term //全部词, 按出现顺序
+	SnippetLen  int     //上下文长度, 单位: 字
+	MaxSnippets int     //每个词最多保留的上下文数量, 0表示不保留
+}
+
+//匹配结果
+type Result struct {
+	Matched  bool
+	Terms    []string            //命中的词(NOT中的词不算), 按查询中的顺序
+	Hits     map[string]int      //每个命中的词的命中次数
+	Snippets map[string][]string //每个命中的词的上下文
+}
+
+//一个词: 字面量或者正则
+type term struct {
+	src      string         //查询中的写法, 用于结果展示
+	literal  string         //归一化后的字面量
+	re       *regexp.Regexp //正则
+	negative bool           //是否在NOT中
+}
+
+//编译查询
+func Compile(query string) (*Query, error) {
+	p := &parser{src: query}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	if len(p.tokens) == 0 {
+		return nil, errors.New("Empty query")
+	}
+	q := &Query{src: query, SnippetLen: DEFAULT_SNIPPET_LEN, MaxSnippets: DEFAULT_MAX_SNIPPETS}
+	p.query = q
+	root, err := p.parseOr(false)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errors.New("Unexpected '" + p.tokens[p.pos].text + "' in query: " + query)
+	}
+	q.root = root
+	return q, nil
+}
+
+//编译查询, 出错则panic
+func MustCompile(query string) *Query {
+	q, err := Compile(query)
+	if err != nil {
+		panic(err)
+	}
+	return q
+}
+
+func (q *Query) String() string {
+	return q.src
+}
+
+//匹配文本
+func (q *Query) Match(text string) *Result {
+	norm, offsets := normalize(text)
+
+	//每个词的命中位置(原文中的字节偏移)
+	positions := make(map[*term][][2]int, len(q.terms))
+	for _, t := range q.terms {
+		positions[t] = t.find(norm, offsets, len(text))
+	}
+
+	result := &Result{
+		Matched:  q.root.eval(positions),
+		Terms:    []string{},
+		Hits:     map[string]int{},
+		Snippets: map[string][]string{},
+	}
+	if !result.Matched {
+		return result
+	}
+	for _, t := range q.terms {
+		pos := positions[t]
+		if t.negative || len(pos) == 0 {
+			continue
+		}
+		if _, ok := result.Hits[t.src]; ok {
+			continue
+		}
+		result.Terms = append(result.Terms, t.src)
+		result.Hits[t.src] = len(pos)
+		snippets := []string{}
+		for i := 0; i < len(pos) && i < q.MaxSnippets; i++ {
+			snippets = append(snippets, snippet(text, pos[i][0], pos[i][1], q.SnippetLen))
+		}
+		result.Snippets[t.src] = snippets
+	}
+	return result
+}
+
+//查找全部命中, 返回原文中的[起始, 结束)字节偏移
+func (t *term) find(norm string, offsets []int, textLen int) [][2]int {
+	var locs [][]int
+	if t.re != nil {
+		locs = t.re.FindAllStringIndex(norm, -1)
+	} else if t.literal != "" {
+		start := 0
+		for {
+			idx := strings.Index(norm[start:], t.literal)
+			if idx < 0 {
+				break
+			}
+			locs = append(locs, []int{start + idx, start + idx + len(t.literal)})
+			start += idx + len(t.literal)
+		}
+	}
+	pos := make([][2]int, 0, len(locs))
+	for _, l := range locs {
+		if l[0] == l[1] {
+			continue //正则的空匹配
+		}
+		end := textLen
+		if l[1] < len(offsets) {
+			end = offsets[l[1]]
+		}
+		pos = append(pos, [2]int{offsets[l[0]], end})
+	}
+	return pos
+}
+
+//归一化: 全角转半角, 小写; 同时记录归一化后每个字节对应的原文偏移
+func normalize(text string) (string, []int) {
+	var b strings.Builder
+	offsets := make([]int, 0, len(text))
+	for i, r := range text {
+		n := foldString(string(r))
+		b.WriteString(n)
+		for j := 0; j < len(n); j++ {
+			offsets = append(offsets, i)
+		}
+	}
+	return b.String(), offsets
+}
+
+func foldString(s string) string {
+	return strings.ToLower(width.Fold.String(s))
+}
+
+//命中处前后各n个字的上下文, 空白合并
+func snippet(text string, start, end, n int) string {
+	from := start
+	for i := 0; i < n && from > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:from])
+		from -= size
+	}
+	to := end
+	for i := 0; i < n && to < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[to:])
+		to += size
+	}
+	s := strings.Join(strings.Fields(text[from:to]), " ")
+	if from > 0 {
+		s = "..." + s
+	}
+	if to < len(text) {
+		s = s + "..."
+	}
+	return s
+}
+
+/************************** 语法树 **************************/
+type node interface {
+	eval(positions map[*term][][2]int) bool
+}
+
+type termNode struct{ t *term }
+type notNode struct{ n node }
+type andNode struct{ children []node }
+type orNode struct{ children []node }
+
+func (n *termNode) eval(positions map[*term][][2]int) bool {
+	return len(positions[n.t]) > 0
+}
+
+func (n *notNode) eval(positions map[*term][][2]int) bool {
+	return !n.n.eval(positions)
+}
+
+func (n *andNode) eval(positions map[*term][][2]int) bool {
+	for _, c := range n.children {
+		if !c.eval(positions) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *orNode) eval(positions map[*term][][2]int) bool {
+	for _, c := range n.children {
+		if c.eval(positions) {
+			return true
+		}
+	}
+	return false
+}
+
+/************************** 解析 **************************/
+const (
+	tokWord = iota
+	tokPhrase
+	tokRegex
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+	query  *Query
+}
+
+func (p *parser) tokenize() error {
+	s := p.src
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			p.tokens = append(p.tokens, token{tokLParen, "("})
+			i++
+		case c == ')':
+			p.tokens = append(p.tokens, token{tokRParen, ")"})
+			i++
+		case strings.HasPrefix(s[i:], "&&"):
+			p.tokens = append(p.tokens, token{tokAnd, "&&"})
+			i += 2
+		case strings.HasPrefix(s[i:], "||"):
+			p.tokens = append(p.tokens, token{tokOr, "||"})
+			i += 2
+		case c == '!' || (c == '-' && i+1 < len(s) && s[i+1] != ' ' && (i == 0 || s[i-1] == ' ' || s[i-1] == '(')):
+			p.tokens = append(p.tokens, token{tokNot, string(c)})
+			i++
+		case c == '"':
+			end := strings.IndexByte(s[i+1:], '"')
+			if end < 0 {
+				return errors.New("Unterminated phrase in query: " + p.src)
+			}
+			p.tokens = append(p.tokens, token{tokPhrase, s[i+1 : i+1+end]})
+			i += end + 2
+		case c == '/':
+			//正则中的\/表示/
+			j := i + 1
+			var b strings.Builder
+			for ; j < len(s) && s[j] != '/'; j++ {
+				if s[j] == '\\' && j+1 < len(s) && s[j+1] == '/' {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return errors.New("Unterminated regex in query: " + p.src)
+			}
+			p.tokens = append(p.tokens, token{tokRegex, b.String()})
+			i = j + 1
+		default:
+			j := i
+			for j < len(s) && !strings.ContainsRune(" \t\n\r()\"", rune(s[j])) {
+				j++
+			}
+			word := s[i:j]
+			switch word {
+			case "AND":
+				p.tokens = append(p.tokens, token{tokAnd, word})
+			case "OR":
+				p.tokens = append(p.tokens, token{tokOr, word})
+			case "NOT":
+				p.tokens = append(p.tokens, token{tokNot, word})
+			default:
+				p.tokens = append(p.tokens, token{tokWord, word})
+			}
+			i = j
+		}
+	}
+	return nil
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+//or := and (OR and)*
+func (p *parser) parseOr(negative bool) (node, error) {
+	first, err := p.parseAnd(negative)
+	if err != nil {
+		return nil, err
+	}
+	children := []node{first}
+	for t := p.peek(); t != nil && t.kind == tokOr; t = p.peek() {
+		p.pos++
+		n, err := p.parseAnd(negative)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &orNode{children}, nil
+}
+
+//and := unary ((AND)? unary)*
+func (p *parser) parseAnd(negative bool) (node, error) {
+	first, err := p.parseUnary(negative)
+	if err != nil {
+		return nil, err
+	}
+	children := []node{first}
+	for t := p.peek(); t != nil && t.kind != tokOr && t.kind != tokRParen; t = p.peek() {
+		if t.kind == tokAnd {
+			p.pos++
+		}
+		n, err := p.parseUnary(negative)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, n)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &andNode{children}, nil
+}
+
+//unary := NOT unary | ( or ) | 词
+func (p *parser) parseUnary(negative bool) (node, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, errors.New("Unexpected end of query: " + p.src)
+	}
+	p.pos++
+	switch t.kind {
+	case tokNot:
+		n, err := p.parseUnary(!negative)
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{n}, nil
+	case tokLParen:
+		n, err := p.parseOr(negative)
+		if err != nil {
+			return nil, err
+		}
+		if r := p.peek(); r == nil || r.kind != tokRParen {
+			return nil, errors.New("Missing ')' in query: " + p.src)
+		}
+		p.pos++
+		return n, nil
+	case tokWord, tokPhrase:
+		tm := &term{src: t.text, literal: foldString(t.text), negative: negative}
+		if tm.literal == "" {
+			return nil, errors.New("Empty phrase in query: " + p.src)
+		}
+		p.query.terms = append(p.query.terms, tm)
+		return &termNode{tm}, nil
+	case tokRegex:
+		re, err := regexp.Compile("(?i)" + width.Fold.String(t.text))
+		if err != nil {
+			return nil, err
+		}
+		tm := &term{src: "/" + t.text + "/", re: re, negative: negative}
+		p.query.terms = append(p.query.terms, tm)
+		return &termNode{tm}, nil
+	}
+	return nil, errors.New("Unexpected '" + t.text + "' in query: " + p.src)
+}
@@ -0,0 +1,77 @@
+package keyword
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testText = `３６０公司董事长周鸿祎今天在北京发布了新产品。Red Team负责人表示, 周鸿祎对产品很满意。`
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		query   string
+		matched bool
+		terms   []string
+	}{
+		{`周鸿祎`, true, []string{"周鸿祎"}},
+		{`周鸿祎 北京`, true, []string{"周鸿祎", "北京"}},
+		{`周鸿祎 AND 上海`, false, nil},
+		{`上海 OR 北京`, true, []string{"北京"}},
+		{`上海 || 北京`, true, []string{"北京"}},
+		{`周鸿祎 NOT 上海`, true, []string{"周鸿祎"}},
+		{`周鸿祎 -北京`, false, nil},
+		{`周鸿祎 && !北京`, false, nil},
+		{`(上海 OR 北京) 360`, true, []string{"北京", "360"}},
+		{`"red team"`, true, []string{"red team"}},
+		{`/周.{0,2}祎/`, true, []string{"/周.{0,2}祎/"}},
+		{`/ＲＥＤ\s+team/`, true, []string{"/ＲＥＤ\\s+team/"}},
+		{`NOT (上海 OR 广州)`, true, []string{}},
+		{`anti-virus OR 360`, true, []string{"360"}},
+	}
+	for _, c := range cases {
+		r := MustCompile(c.query).Match(testText)
+		if r.Matched != c.matched {
+			t.Fatalf("%s: expect matched=%v", c.query, c.matched)
+		}
+		if c.matched && !reflect.DeepEqual(r.Terms, c.terms) {
+			t.Fatalf("%s: expect terms %v, got %v", c.query, c.terms, r.Terms)
+		}
+	}
+}
+
+func TestHitsAndSnippets(t *testing.T) {
+	q := MustCompile(`周鸿祎 ３６０`)
+	q.SnippetLen = 2
+	r := q.Match(testText)
+	if r.Hits["周鸿祎"] != 2 || r.Hits["３６０"] != 1 {
+		t.Fatalf("Wrong hits: %v", r.Hits)
+	}
+	//上下文来自原文, 全角字符保持原样
+	if s := r.Snippets["３６０"]; len(s) != 1 || s[0] != "３６０公司..." {
+		t.Fatalf("Wrong snippets: %v", s)
+	}
+	if s := r.Snippets["周鸿祎"]; len(s) != 2 || s[0] != "...事长周鸿祎今天..." || s[1] != "..., 周鸿祎对产..." {
+		t.Fatalf("Wrong snippets: %v", s)
+	}
+
+	q.MaxSnippets = 1
+	if s := q.Match(testText).Snippets["周鸿祎"]; len(s) != 1 {
+		t.Fatalf("Wrong snippets: %v", s)
+	}
+}
+
+func TestWidthAndCase(t *testing.T) {
+	//半角片假名和全角片假名, 全角字母和半角字母
+	r := MustCompile(`ｶﾀｶﾅ abc`).Match(`カタカナ ＡＢＣ`)
+	if !r.Matched || r.Hits["ｶﾀｶﾅ"] != 1 || r.Hits["abc"] != 1 {
+		t.Fatalf("Wrong result: %+v", r)
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, q := range []string{``, `(周鸿祎`, `周鸿祎)`, `"周鸿祎`, `/周(/`, `/周`, `周鸿祎 AND`, `""`} {
+		if _, err := Compile(q); err == nil {
+			t.Fatalf("%s: expect error", q)
+		}
+	}
+}
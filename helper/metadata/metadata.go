@@ -0,0 +1,274 @@
+package metadata
+
+/*
+ * 页面元数据和结构化数据抽取
+ * 1. <title>, meta description/keywords, canonical, lang
+ * 2. OpenGraph(og:xxx, article:xxx等)和Twitter card(twitter:xxx)
+ * 3. JSON-LD(<script type="application/ld+json">), 解析为嵌套的map
+ * 4. schema.org microdata(itemscope/itemprop), 解析为嵌套的map, 类型记录在"@type"
+ * 同一个属性出现多次的, 值为数组
+ */
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"net/url"
+	"strings"
+)
+
+//页面元数据
+type Metadata struct {
+	Title       string
+	Description string
+	Keywords    []string
+	Canonical   string                 //补全后的绝对Url
+	Lang        string
+	OpenGraph   map[string]interface{} //og:title => title, 重复的属性(比如og:image)为[]interface{}
+	Twitter     map[string]interface{} //twitter:card => card
+	JsonLd      []interface{}          //每个JSON-LD块, 块本身是数组的展开
+	Microdata   []interface{}          //顶层的microdata条目
+}
+
+//OpenGraph的属性前缀, 除了og:还有article:, book:, profile:等
+var ogPrefixes = []string{"og:", "article:", "book:", "profile:", "music:", "video:", "product:"}
+
+//抽取元数据, base用于补全相对链接(canonical, microdata中的Url)
+//JSON-LD解析失败不影响其他部分, 错误单独返回
+func Extract(doc *goquery.Document, base *url.URL) (*Metadata, []error) {
+	m := &Metadata{
+		Title:     collapse(doc.Find("title").First().Text()),
+		OpenGraph: map[string]interface{}{},
+		Twitter:   map[string]interface{}{},
+		JsonLd:    []interface{}{},
+		Microdata: []interface{}{},
+	}
+	errs := make([]error, 0)
+
+	m.Lang, _ = doc.Find("html").First().Attr("lang")
+	m.Lang = strings.TrimSpace(m.Lang)
+
+	doc.Find("meta").Each(func(i int, sel *goquery.Selection) {
+		content, ok := sel.Attr("content")
+		if !ok {
+			return
+		}
+		content = strings.TrimSpace(content)
+		name, _ := sel.Attr("name")
+		property, _ := sel.Attr("property")
+		equiv, _ := sel.Attr("http-equiv")
+		name = strings.ToLower(strings.TrimSpace(name))
+		property = strings.ToLower(strings.TrimSpace(property))
+
+		switch {
+		case name == "description" && m.Description == "":
+			m.Description = content
+		case name == "keywords" && len(m.Keywords) == 0:
+			m.Keywords = splitKeywords(content)
+		case strings.EqualFold(strings.TrimSpace(equiv), "content-language") && m.Lang == "":
+			m.Lang = content
+		}
+		//twitter:xxx有的站点用property, 有的用name
+		for _, key := range []string{property, name} {
+			if strings.HasPrefix(key, "twitter:") {
+				add(m.Twitter, strings.TrimPrefix(key, "twitter:"), content)
+				return
+			}
+		}
+		for _, prefix := range ogPrefixes {
+			if strings.HasPrefix(property, prefix) {
+				add(m.OpenGraph, strings.TrimPrefix(property, "og:"), content)
+				return
+			}
+		}
+	})
+
+	if href, ok := doc.Find(`link[rel~="canonical"][href]`).First().Attr("href"); ok {
+		m.Canonical = resolve(base, href)
+	}
+
+	doc.Find("script").Each(func(i int, sel *goquery.Selection) {
+		typ, _ := sel.Attr("type")
+		if !strings.EqualFold(strings.TrimSpace(typ), "application/ld+json") {
+			return
+		}
+		v, err := parseJsonLd(sel.Text())
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if arr, ok := v.([]interface{}); ok {
+			m.JsonLd = append(m.JsonLd, arr...)
+		} else if v != nil {
+			m.JsonLd = append(m.JsonLd, v)
+		}
+	})
+
+	//顶层的microdata条目: 有itemscope但没有itemprop(否则是其他条目的属性)
+	doc.Find("[itemscope]").Each(func(i int, sel *goquery.Selection) {
+		if _, ok := sel.Attr("itemprop"); ok {
+			return
+		}
+		m.Microdata = append(m.Microdata, microdataItem(sel.Get(0), base))
+	})
+	return m, errs
+}
+
+//转换为item中的map, 空的字段不输出
+func (m *Metadata) ToMap() map[string]interface{} {
+	r := map[string]interface{}{}
+	set := func(k string, v interface{}, empty bool) {
+		if !empty {
+			r[k] = v
+		}
+	}
+	set("title", m.Title, m.Title == "")
+	set("description", m.Description, m.Description == "")
+	set("keywords", m.Keywords, len(m.Keywords) == 0)
+	set("canonical", m.Canonical, m.Canonical == "")
+	set("lang", m.Lang, m.Lang == "")
+	set("opengraph", m.OpenGraph, len(m.OpenGraph) == 0)
+	set("twitter", m.Twitter, len(m.Twitter) == 0)
+	set("jsonld", m.JsonLd, len(m.JsonLd) == 0)
+	set("microdata", m.Microdata, len(m.Microdata) == 0)
+	return r
+}
+
+//解析一个JSON-LD块, 有的站点会用<!-- -->或者CDATA包起来
+func parseJsonLd(text string) (interface{}, error) {
+	text = strings.TrimSpace(text)
+	for _, wrap := range [][2]string{{"<!--", "-->"}, {"//<![CDATA[", "//]]>"}, {"<![CDATA[", "]]>"}} {
+		if strings.HasPrefix(text, wrap[0]) && strings.HasSuffix(text, wrap[1]) {
+			text = strings.TrimSpace(text[len(wrap[0]) : len(text)-len(wrap[1])])
+		}
+	}
+	if text == "" {
+		return nil, nil
+	}
+	d := json.NewDecoder(bytes.NewReader([]byte(text)))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, errors.New("Bad JSON-LD: " + err.Error())
+	}
+	return v, nil
+}
+
+//一个microdata条目, 属性按itemprop收集, 嵌套的itemscope递归解析
+func microdataItem(scope *html.Node, base *url.URL) map[string]interface{} {
+	item := map[string]interface{}{}
+	if t := attr(scope, "itemtype"); t != "" {
+		item["@type"] = t
+	}
+	if id := attr(scope, "itemid"); id != "" {
+		item["@id"] = resolve(base, id)
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			_, isScope := attrOk(c, "itemscope")
+			props := strings.Fields(attr(c, "itemprop"))
+			if len(props) > 0 {
+				var v interface{}
+				if isScope {
+					v = microdataItem(c, base)
+				} else {
+					v = propValue(c, base)
+				}
+				for _, p := range props {
+					add(item, p, v)
+				}
+			}
+			//嵌套条目的属性属于嵌套条目, 不再向下找
+			if !isScope {
+				walk(c)
+			}
+		}
+	}
+	walk(scope)
+	return item
+}
+
+//按照microdata规范取属性值
+func propValue(n *html.Node, base *url.URL) interface{} {
+	switch n.DataAtom {
+	case atom.Meta:
+		return strings.TrimSpace(attr(n, "content"))
+	case atom.Audio, atom.Embed, atom.Iframe, atom.Img, atom.Source, atom.Track, atom.Video:
+		return resolve(base, attr(n, "src"))
+	case atom.A, atom.Area, atom.Link:
+		return resolve(base, attr(n, "href"))
+	case atom.Object:
+		return resolve(base, attr(n, "data"))
+	case atom.Data, atom.Meter:
+		return strings.TrimSpace(attr(n, "value"))
+	case atom.Time:
+		if dt, ok := attrOk(n, "datetime"); ok {
+			return strings.TrimSpace(dt)
+		}
+	}
+	if c, ok := attrOk(n, "content"); ok {
+		return strings.TrimSpace(c)
+	}
+	return collapse(goquery.NewDocumentFromNode(n).Text())
+}
+
+//重复的属性转为数组
+func add(m map[string]interface{}, key string, v interface{}) {
+	old, ok := m[key]
+	if !ok {
+		m[key] = v
+		return
+	}
+	if arr, ok := old.([]interface{}); ok {
+		m[key] = append(arr, v)
+		return
+	}
+	m[key] = []interface{}{old, v}
+}
+
+func splitKeywords(s string) []string {
+	keywords := []string{}
+	for _, k := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '，' || r == ';' || r == '、' }) {
+		if k = strings.TrimSpace(k); k != "" {
+			keywords = append(keywords, k)
+		}
+	}
+	return keywords
+}
+
+func resolve(base *url.URL, href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || base == nil {
+		return href
+	}
+	u, err := base.Parse(href)
+	if err != nil {
+		return href
+	}
+	return u.String()
+}
+
+func attr(n *html.Node, key string) string {
+	v, _ := attrOk(n, key)
+	return v
+}
+
+func attrOk(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,87 @@
+package metadata
+
+import (
+	"encoding/json"
+	"github.com/PuerkitoBio/goquery"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testPage = `<html lang="zh-CN"><head>
+<title> 商品详情 - 360商城 </title>
+<meta name="description" content="一款好用的路由器">
+<meta name="keywords" content="路由器，360, wifi">
+<link rel="canonical" href="/p/1">
+<meta property="og:title" content="360路由器">
+<meta property="og:image" content="http://a.com/1.jpg">
+<meta property="og:image" content="http://a.com/2.jpg">
+<meta property="article:author" content="老周">
+<meta name="twitter:card" content="summary">
+<script type="application/ld+json">
+<!--
+{"@context": "https://schema.org", "@type": "Product", "name": "路由器", "sku": 12345678901234567890,
+ "offers": {"@type": "Offer", "price": "99.00"}}
+-->
+</script>
+<script type="application/ld+json">[{"@type": "BreadcrumbList"}, {"@type": "Organization"}]</script>
+<script type="application/ld+json">{bad json</script>
+</head><body>
+<div itemscope itemtype="https://schema.org/Product">
+  <span itemprop="name">360路由器P2</span>
+  <img itemprop="image" src="/img/p2.jpg">
+  <div itemprop="offers" itemscope itemtype="https://schema.org/Offer">
+    <meta itemprop="priceCurrency" content="CNY">
+    <span itemprop="price" content="99.00">￥99</span>
+  </div>
+  <a itemprop="sameAs url" href="http://example.com/p2">官网</a>
+  <span itemprop="color">白</span><span itemprop="color">黑</span>
+</div>
+</body></html>`
+
+func TestExtract(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(testPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse("http://shop.360.cn/item/1.html")
+	m, errs := Extract(doc, base)
+	if len(errs) != 1 {
+		t.Fatalf("Expect 1 JSON-LD error, got %v", errs)
+	}
+	if m.Title != "商品详情 - 360商城" || m.Description != "一款好用的路由器" || m.Lang != "zh-CN" ||
+		m.Canonical != "http://shop.360.cn/p/1" || strings.Join(m.Keywords, "|") != "路由器|360|wifi" {
+		t.Fatalf("Wrong basic metadata: %+v", m)
+	}
+	if m.OpenGraph["title"] != "360路由器" || len(m.OpenGraph["image"].([]interface{})) != 2 ||
+		m.OpenGraph["article:author"] != "老周" || m.Twitter["card"] != "summary" {
+		t.Fatalf("Wrong opengraph/twitter: %v %v", m.OpenGraph, m.Twitter)
+	}
+
+	//JSON-LD: 数组展开, 大整数保持精度
+	if len(m.JsonLd) != 3 {
+		t.Fatalf("Expect 3 JSON-LD blocks, got %d", len(m.JsonLd))
+	}
+	product := m.JsonLd[0].(map[string]interface{})
+	if product["sku"].(json.Number).String() != "12345678901234567890" ||
+		product["offers"].(map[string]interface{})["price"] != "99.00" {
+		t.Fatalf("Wrong JSON-LD: %v", product)
+	}
+
+	if len(m.Microdata) != 1 {
+		t.Fatalf("Expect 1 microdata item, got %d", len(m.Microdata))
+	}
+	item := m.Microdata[0].(map[string]interface{})
+	offer, _ := item["offers"].(map[string]interface{})
+	if item["@type"] != "https://schema.org/Product" || item["name"] != "360路由器P2" ||
+		item["image"] != "http://shop.360.cn/img/p2.jpg" || item["url"] != "http://example.com/p2" ||
+		item["sameAs"] != "http://example.com/p2" || len(item["color"].([]interface{})) != 2 ||
+		offer["price"] != "99.00" || offer["priceCurrency"] != "CNY" || item["priceCurrency"] != nil {
+		t.Fatalf("Wrong microdata: %v", item)
+	}
+
+	mm := m.ToMap()
+	if _, ok := mm["opengraph"]; !ok || len(mm) != 9 {
+		t.Fatalf("Wrong map: %v", mm)
+	}
+}
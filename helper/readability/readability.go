@@ -0,0 +1,406 @@
+package readability
+
+/*
+ * 正文抽取(类readability)
+ * 页面的body中除了正文, 还有导航、页脚、侧栏、脚本等, 直接取body的文本会混入大量无关内容
+ * 1. 先去掉脚本、样式、表单, 以及class/id明显是导航、页脚、广告等的节点
+ * 2. 按段落打分: 文本越长、逗号越多分越高, 分数累加到父节点和祖父节点
+ * 3. 候选节点的分数再乘以(1-链接密度), 链接文字占比高的是导航列表而不是正文
+ * 4. 取得分最高的节点, 连同得分接近的兄弟节点, 作为正文
+ * 标题、作者、发布时间优先取meta信息, 其次从页面结构中找
+ */
+import (
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+//抽取结果
+type Article struct {
+	Title  string //标题
+	Byline string //作者
+	Date   string //发布时间, 保持页面上的原样, 不做格式转换
+	Text   string //正文文本, 段落之间用空行分隔
+	Html   string //正文节点的HTML
+	Score  float64 //正文节点的得分, 得分太低说明页面可能不是文章页
+}
+
+//段落长度低于此值的不参与打分
+const minParagraphLen = 25
+
+//明显不是正文的节点
+var unlikelyRe = regexp.MustCompile(`(?i)banner|breadcrumb|combx|comment|community|cover-wrap|disqus|extra|footer|gdpr|header|legends|menu|related|remark|replies|rss|shoutbox|sidebar|skyscraper|social|sponsor|supplemental|ad-break|agegate|pagination|pager|popup|yom-remote|share|nav|copyright|login|recommend`)
+
+//虽然命中了上面的规则, 但可能是正文的节点
+var maybeRe = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow|text|entry|post`)
+
+//加分和减分的class/id
+var positiveRe = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|pagination|post|text|blog|story|detail`)
+var negativeRe = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|combx|comment|com-|contact|foot|footer|footnote|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget|recommend|hot`)
+
+var bylineRe = regexp.MustCompile(`(?i)byline|author|writtenby|p-author|editor`)
+
+//正文中的日期, 比如2019-01-02 10:00, 2019/1/2, 2019年1月2日
+var dateRe = regexp.MustCompile(`\d{4}(?:-|/|\.|年)\d{1,2}(?:-|/|\.|月)\d{1,2}日?(?:\s+\d{1,2}:\d{2}(?::\d{2})?)?`)
+
+//标题中的站点名分隔符
+var titleSepRe = regexp.MustCompile(`\s+[-|_–—]\s+|\s*[|_]\s*`)
+
+//抽取正文、标题、作者和发布时间
+//doc不会被修改, 可以是多个分析函数共享的文档
+func Extract(doc *goquery.Document) *Article {
+	article := &Article{
+		Title:  extractTitle(doc),
+		Byline: extractByline(doc),
+		Date:   extractDate(doc),
+	}
+
+	clone := goquery.CloneDocument(doc)
+	body := clone.Find("body")
+	if body.Length() == 0 {
+		return article
+	}
+	clean(body)
+	top, score := topCandidate(body.Get(0))
+	if top == nil {
+		return article
+	}
+	nodes := withSiblings(top, score)
+
+	texts := []string{}
+	htmls := []string{}
+	for _, n := range nodes {
+		if t := nodeText(n); t != "" {
+			texts = append(texts, t)
+		}
+		if h, err := goquery.OuterHtml(goquery.NewDocumentFromNode(n).Selection); err == nil {
+			htmls = append(htmls, h)
+		}
+	}
+	article.Text = strings.Join(texts, "\n\n")
+	article.Html = strings.Join(htmls, "\n")
+	article.Score = score
+	if article.Date == "" {
+		article.Date = dateRe.FindString(article.Text)
+	}
+	return article
+}
+
+//标题: og:title > 和<title>吻合的<h1> > 去掉站点名的<title>
+func extractTitle(doc *goquery.Document) string {
+	if t := metaContent(doc, `meta[property="og:title"]`, `meta[name="twitter:title"]`); t != "" {
+		return t
+	}
+	title := collapse(doc.Find("title").First().Text())
+	var h1 string
+	doc.Find("h1").EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		t := collapse(sel.Text())
+		if t != "" && (title == "" || strings.Contains(title, t)) {
+			h1 = t
+			return false
+		}
+		return true
+	})
+	if h1 != "" {
+		return h1
+	}
+	//"标题 - 站点名"取最长的一段
+	parts := titleSepRe.Split(title, -1)
+	if len(parts) > 1 {
+		longest := ""
+		for _, p := range parts {
+			if utf8.RuneCountInString(p) > utf8.RuneCountInString(longest) {
+				longest = p
+			}
+		}
+		return strings.TrimSpace(longest)
+	}
+	return title
+}
+
+func extractByline(doc *goquery.Document) string {
+	if b := metaContent(doc, `meta[name="author"]`, `meta[property="article:author"]`); b != "" {
+		return b
+	}
+	byline := ""
+	doc.Find(`[rel="author"], [itemprop~="author"], [class], [id]`).EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		rel, _ := sel.Attr("rel")
+		itemprop, _ := sel.Attr("itemprop")
+		if rel != "author" && !strings.Contains(itemprop, "author") && !bylineRe.MatchString(classAndId(sel.Get(0))) {
+			return true
+		}
+		t := collapse(sel.Text())
+		if n := utf8.RuneCountInString(t); n > 0 && n < 100 {
+			byline = t
+			return false
+		}
+		return true
+	})
+	return byline
+}
+
+func extractDate(doc *goquery.Document) string {
+	if d := metaContent(doc, `meta[property="article:published_time"]`, `meta[itemprop="datePublished"]`,
+		`meta[name="pubdate"]`, `meta[name="publishdate"]`, `meta[name="date"]`); d != "" {
+		return d
+	}
+	if dt, ok := doc.Find("time[datetime]").First().Attr("datetime"); ok && strings.TrimSpace(dt) != "" {
+		return strings.TrimSpace(dt)
+	}
+	if sel := doc.Find(`[itemprop="datePublished"]`).First(); sel.Length() > 0 {
+		return collapse(sel.Text())
+	}
+	return ""
+}
+
+//第一个非空的meta content
+func metaContent(doc *goquery.Document, selectors ...string) string {
+	for _, s := range selectors {
+		if c, ok := doc.Find(s).First().Attr("content"); ok && strings.TrimSpace(c) != "" {
+			return strings.TrimSpace(c)
+		}
+	}
+	return ""
+}
+
+//去掉肯定不是正文的节点
+func clean(body *goquery.Selection) {
+	body.Find("script, style, noscript, iframe, form, button, input, select, textarea, svg, nav, footer, aside").Remove()
+	body.Find("*").Each(func(i int, sel *goquery.Selection) {
+		n := sel.Get(0)
+		if n.DataAtom == atom.Body || n.DataAtom == atom.Article || n.DataAtom == atom.Main {
+			return
+		}
+		ci := classAndId(n)
+		if ci != "" && unlikelyRe.MatchString(ci) && !maybeRe.MatchString(ci) {
+			sel.Remove()
+		}
+	})
+}
+
+//给段落打分, 分数累加到父节点和祖父节点, 返回得分最高的候选节点
+func topCandidate(body *html.Node) (*html.Node, float64) {
+	scores := map[*html.Node]float64{}
+	order := []*html.Node{}
+	addScore := func(n *html.Node, s float64) {
+		if n == nil || n.Type != html.ElementNode {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initScore(n)
+			order = append(order, n)
+		}
+		scores[n] += s
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && isParagraph(n) {
+			text := nodeText(n)
+			if l := utf8.RuneCountInString(text); l >= minParagraphLen {
+				s := 1 + float64(commaCount(text)) + math.Min(float64(l)/100, 3)
+				addScore(n.Parent, s)
+				if n.Parent != nil {
+					addScore(n.Parent.Parent, s/2)
+				}
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(body)
+
+	var top *html.Node
+	topScore := 0.0
+	for _, n := range order {
+		s := scores[n] * (1 - linkDensity(n))
+		scores[n] = s
+		if top == nil || s > topScore {
+			top, topScore = n, s
+		}
+	}
+	return top, topScore
+}
+
+//得分接近的兄弟节点也是正文的一部分, 比如被广告隔开的两段正文
+func withSiblings(top *html.Node, score float64) []*html.Node {
+	if top.Parent == nil || top.DataAtom == atom.Body {
+		return []*html.Node{top}
+	}
+	threshold := math.Max(10, score*0.2)
+	nodes := []*html.Node{}
+	for c := top.Parent.FirstChild; c != nil; c = c.NextSibling {
+		if c == top {
+			nodes = append(nodes, c)
+			continue
+		}
+		if c.Type != html.ElementNode {
+			continue
+		}
+		text := nodeText(c)
+		l := utf8.RuneCountInString(text)
+		if l == 0 {
+			continue
+		}
+		ld := linkDensity(c)
+		switch {
+		case c.DataAtom == atom.P && l >= 80 && ld < 0.25:
+			nodes = append(nodes, c)
+		case c.DataAtom == atom.P && l > 0 && ld == 0 && (strings.HasSuffix(text, ".") || strings.HasSuffix(text, "。")):
+			nodes = append(nodes, c)
+		case !isParagraph(c) && paragraphScore(c)*(1-ld) >= threshold:
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+//兄弟节点的得分: 只算直接包含的段落
+func paragraphScore(n *html.Node) float64 {
+	s := initScore(n)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && isParagraph(c) {
+			text := nodeText(c)
+			if l := utf8.RuneCountInString(text); l >= minParagraphLen {
+				s += 1 + float64(commaCount(text)) + math.Min(float64(l)/100, 3)
+			}
+		}
+	}
+	return s
+}
+
+//按标签和class/id的初始分
+func initScore(n *html.Node) float64 {
+	s := 0.0
+	switch n.DataAtom {
+	case atom.Article:
+		s += 10
+	case atom.Div, atom.Main, atom.Section:
+		s += 5
+	case atom.Pre, atom.Td, atom.Blockquote:
+		s += 3
+	case atom.Address, atom.Ol, atom.Ul, atom.Dl, atom.Dd, atom.Dt, atom.Li, atom.Form:
+		s -= 3
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Th:
+		s -= 5
+	}
+	ci := classAndId(n)
+	if ci != "" {
+		if negativeRe.MatchString(ci) {
+			s -= 25
+		}
+		if positiveRe.MatchString(ci) {
+			s += 25
+		}
+	}
+	return s
+}
+
+//段落: <p>, <pre>, <td>, 以及不包含块级元素的<div>(很多中文站点不用<p>)
+func isParagraph(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.P, atom.Pre, atom.Td:
+		return true
+	case atom.Div, atom.Section:
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && isBlock(c) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func isBlock(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Address, atom.Article, atom.Aside, atom.Blockquote, atom.Dl, atom.Div, atom.Fieldset, atom.Figure,
+		atom.Footer, atom.Form, atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6, atom.Header, atom.Hr,
+		atom.Li, atom.Main, atom.Nav, atom.Ol, atom.P, atom.Pre, atom.Section, atom.Table, atom.Ul:
+		return true
+	}
+	return false
+}
+
+//链接文字占全部文字的比例
+func linkDensity(n *html.Node) float64 {
+	total := utf8.RuneCountInString(nodeText(n))
+	if total == 0 {
+		return 0
+	}
+	linkLen := 0
+	var walk func(c *html.Node)
+	walk = func(c *html.Node) {
+		if c.Type == html.ElementNode && c.DataAtom == atom.A {
+			linkLen += utf8.RuneCountInString(nodeText(c))
+			return
+		}
+		for cc := c.FirstChild; cc != nil; cc = cc.NextSibling {
+			walk(cc)
+		}
+	}
+	walk(n)
+	return float64(linkLen) / float64(total)
+}
+
+//中英文逗号都算
+func commaCount(s string) int {
+	return strings.Count(s, ",") + strings.Count(s, "，") + strings.Count(s, "、")
+}
+
+//节点文本, 块级元素之间换行, 行内的空白合并
+func nodeText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(c *html.Node)
+	walk = func(c *html.Node) {
+		switch c.Type {
+		case html.TextNode:
+			b.WriteString(c.Data)
+		case html.ElementNode:
+			if c.DataAtom == atom.Br {
+				b.WriteString("\n")
+				return
+			}
+			block := isBlock(c)
+			if block {
+				b.WriteString("\n")
+			}
+			for cc := c.FirstChild; cc != nil; cc = cc.NextSibling {
+				walk(cc)
+			}
+			if block {
+				b.WriteString("\n")
+			}
+		}
+	}
+	walk(n)
+
+	lines := []string{}
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line = collapse(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+//合并空白
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func classAndId(n *html.Node) string {
+	ci := ""
+	for _, a := range n.Attr {
+		if a.Key == "class" || a.Key == "id" {
+			ci += " " + a.Val
+		}
+	}
+	return strings.TrimSpace(ci)
+}
@@ -0,0 +1,63 @@
+package readability
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"strings"
+	"testing"
+)
+
+const testArticle = `<html><head>
+<title>周鸿祎谈网络安全 - 360新闻</title>
+<meta name="author" content="老周">
+<script>var a = "不是正文";</script>
+</head><body>
+<div id="nav"><ul><li><a href="/">首页</a></li><li><a href="/news">新闻</a></li><li><a href="/about">关于我们</a></li></ul></div>
+<div class="main">
+  <div class="article-content">
+    <h1>周鸿祎谈网络安全</h1>
+    <div class="article-info"><span>2019-03-15 10:20</span></div>
+    <p>网络安全已经从单纯的技术问题，演变成了关系国家安全、社会稳定和个人隐私的综合问题，需要全社会共同面对。</p>
+    <div class="ad-break"><a href="/ad">广告：点击领取大奖</a></div>
+    <p>他表示，未来的安全防护要从被动防御转向主动感知，依靠大数据、人工智能等新技术，建立起全天候、全方位的安全体系。</p>
+    <p>在谈到人才培养时，他认为，高校和企业应该加强合作，让更多年轻人在实战中成长，这样才能补上人才缺口。</p>
+  </div>
+  <div class="sidebar"><h3>热门推荐</h3><a href="/n/1.html">另一条很长很长很长很长很长的新闻标题，用来干扰正文的识别</a></div>
+</div>
+<div class="footer"><p>Copyright 2019 360.cn 版权所有，未经许可不得转载，违者必究，保留所有权利。</p></div>
+</body></html>`
+
+func TestExtract(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(testArticle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := Extract(doc)
+	if a.Title != "周鸿祎谈网络安全" || a.Byline != "老周" || a.Date != "2019-03-15 10:20" {
+		t.Fatalf("Wrong meta: %q %q %q", a.Title, a.Byline, a.Date)
+	}
+	if !strings.Contains(a.Text, "网络安全已经从单纯的技术问题") || !strings.Contains(a.Text, "补上人才缺口") {
+		t.Fatalf("Missing content: %s", a.Text)
+	}
+	for _, noise := range []string{"首页", "广告", "不是正文", "热门推荐", "Copyright"} {
+		if strings.Contains(a.Text, noise) {
+			t.Fatalf("Boilerplate %q in content: %s", noise, a.Text)
+		}
+	}
+	//共享的文档不能被修改
+	if doc.Find("script").Length() != 1 || doc.Find(".footer").Length() != 1 {
+		t.Fatal("Shared document modified")
+	}
+}
+
+func TestExtractTitle(t *testing.T) {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(
+		`<html><head><title>A long enough title | Site</title></head><body><p>x</p></body></html>`))
+	if a := Extract(doc); a.Title != "A long enough title" {
+		t.Fatalf("Wrong title: %q", a.Title)
+	}
+	doc, _ = goquery.NewDocumentFromReader(strings.NewReader(
+		`<html><head><meta property="og:title" content="OG"><title>T</title></head><body></body></html>`))
+	if a := Extract(doc); a.Title != "OG" || a.Text != "" {
+		t.Fatalf("Wrong article: %+v", a)
+	}
+}
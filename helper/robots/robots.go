@@ -0,0 +1,182 @@
+package robots
+
+/*
+ * 页面级的robots指令
+ * 1. <a rel=nofollow>等链接: 该链接不跟进
+ * 2. <meta name=robots content="noindex,nofollow">: noindex不产出条目, nofollow不跟进页面上的任何链接
+ * 3. X-Robots-Tag响应头: 含义同meta, 对非HTML响应(比如JSON接口)也有效
+ * 指定了其他爬虫名字的指令(比如"googlebot: noindex")不是给我们的, 忽略
+ */
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hq-cml/spider-man/basic"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+//指令的处理模式
+const (
+	MODE_OFF    = "off"    //不解析指令
+	MODE_STRICT = "strict" //遵守指令, 压制条目和链接
+	MODE_RECORD = "record" //不遵守, 只在urlMap中记录本应压制的内容
+)
+
+//一个页面上的robots指令
+type Directives struct {
+	NoIndex       bool            //不产出条目
+	NoFollow      bool            //不跟进页面上的任何链接
+	Sources       []string        //指令及来源, 比如"meta:noindex", "header:nofollow"
+	NofollowLinks map[string]bool //rel=nofollow的链接, 补全后的绝对Url(去掉#和末尾的/)
+}
+
+//解析响应头和HTML文档中的指令
+//文档取自resp.Document(), 和分析函数共享同一次解析
+func Parse(resp *basic.Response) *Directives {
+	d := &Directives{NofollowLinks: map[string]bool{}}
+	for _, value := range resp.Header["X-Robots-Tag"] {
+		d.apply("header", value, true)
+	}
+	if len(resp.Body) > 0 && strings.Contains(strings.ToLower(resp.ContentType), "html") {
+		if doc, err := resp.Document(); err == nil {
+			d.parseDoc(doc, resp.ReqUrl)
+		}
+	}
+	return d
+}
+
+//是否有任何需要处理的指令
+func (d *Directives) Empty() bool {
+	return !d.NoIndex && !d.NoFollow && len(d.NofollowLinks) == 0
+}
+
+//用于记录到urlMap的指令描述, 比如"meta:noindex,header:nofollow,nofollow-links:3"
+func (d *Directives) String() string {
+	parts := append([]string{}, d.Sources...)
+	if len(d.NofollowLinks) > 0 {
+		parts = append(parts, "nofollow-links:"+strconv.Itoa(len(d.NofollowLinks)))
+	}
+	return strings.Join(parts, ",")
+}
+
+//按照指令过滤分析结果, 返回保留的条目、请求, 以及被压制(或本应被压制)内容的描述
+//strict为false时只统计, 不过滤
+func (d *Directives) Filter(items []*basic.Item, reqs []*basic.Request, strict bool) ([]*basic.Item, []*basic.Request, string) {
+	parts := []string{}
+	if d.NoIndex && len(items) > 0 {
+		parts = append(parts, "items:"+strconv.Itoa(len(items)))
+		if strict {
+			items = nil
+		}
+	}
+
+	nofollow := 0
+	keep := make([]*basic.Request, 0, len(reqs))
+	for _, req := range reqs {
+		if d.NoFollow || d.NofollowLinks[normalize(req.HttpReq().URL)] {
+			nofollow++
+			if strict {
+				continue
+			}
+		}
+		keep = append(keep, req)
+	}
+	if nofollow > 0 {
+		parts = append(parts, "links:"+strconv.Itoa(nofollow))
+	}
+	if reqs != nil {
+		reqs = keep
+	}
+
+	msg := strings.Join(parts, ",")
+	if msg != "" && !strict {
+		msg = "ignored " + msg
+	}
+	return items, reqs, msg
+}
+
+//解析一条meta content或者X-Robots-Tag的值, 比如"noindex, nofollow", "none", "googlebot: noindex"
+func (d *Directives) apply(source, value string, header bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if header {
+		if idx := strings.Index(value, ":"); idx > 0 {
+			agent := strings.TrimSpace(value[:idx])
+			if !knownDirective(agent) {
+				//只有不带爬虫名字的指令才是给所有爬虫的
+				return
+			}
+		}
+	}
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		switch v {
+		case "noindex":
+			d.add(source, v, true, false)
+		case "nofollow":
+			d.add(source, v, false, true)
+		case "none":
+			d.add(source, v, true, true)
+		}
+	}
+}
+
+func (d *Directives) add(source, v string, noIndex, noFollow bool) {
+	d.NoIndex = d.NoIndex || noIndex
+	d.NoFollow = d.NoFollow || noFollow
+	d.Sources = append(d.Sources, source+":"+v)
+}
+
+//从文档中找出meta robots和rel=nofollow的链接, 相对链接按<base href>补全
+func (d *Directives) parseDoc(doc *goquery.Document, reqUrl string) {
+	base, err := url.Parse(reqUrl)
+	if err != nil {
+		return
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = u
+		}
+	}
+
+	//属性值不区分大小写, 所以不用meta[name=robots]和[rel~=nofollow]直接匹配
+	doc.Find("meta[name]").Each(func(i int, sel *goquery.Selection) {
+		if name, _ := sel.Attr("name"); strings.EqualFold(strings.TrimSpace(name), "robots") {
+			content, _ := sel.Attr("content")
+			d.apply("meta", content, false)
+		}
+	})
+	doc.Find("a[rel][href], area[rel][href], link[rel][href]").Each(func(i int, sel *goquery.Selection) {
+		rel, _ := sel.Attr("rel")
+		href, _ := sel.Attr("href")
+		if href = strings.TrimSpace(href); href == "" || !hasNofollow(rel) {
+			return
+		}
+		if u, err := base.Parse(href); err == nil {
+			d.NofollowLinks[normalize(u)] = true
+		}
+	})
+}
+
+//和调度器中请求去重的规则一致: 去掉#和末尾的/
+func normalize(u *url.URL) string {
+	s := strings.Split(u.String(), "#")[0]
+	return strings.TrimRight(s, "/")
+}
+
+func hasNofollow(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == "nofollow" {
+			return true
+		}
+	}
+	return false
+}
+
+func knownDirective(s string) bool {
+	switch s {
+	case "all", "noindex", "nofollow", "none", "noarchive", "nosnippet", "notranslate", "noimageindex",
+		"unavailable_after", "max-snippet", "max-image-preview", "max-video-preview", "indexifembedded":
+		return true
+	}
+	return false
+}
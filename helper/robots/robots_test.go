@@ -0,0 +1,81 @@
+package robots
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"net/http"
+	"testing"
+)
+
+const testPage = `<html><head>
+<meta name="Robots" content="NOINDEX">
+<base href="http://example.com/base/">
+</head><body>
+<a href="a.html">A</a>
+<a href="b.html#top" rel="external nofollow">B</a>
+<a href="/c/" rel="nofollow">C</a>
+</body></html>`
+
+func newReq(t *testing.T, u string) *basic.Request {
+	httpReq, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return basic.NewRequest(httpReq, 1)
+}
+
+func TestParse(t *testing.T) {
+	resp := basic.NewResponse([]byte(testPage), 0, "text/html; charset=utf-8", "http://example.com/index.html")
+	resp.Header = http.Header{}
+	resp.Header.Add("X-Robots-Tag", "googlebot: nofollow")
+	d := Parse(resp)
+	if !d.NoIndex || d.NoFollow {
+		t.Fatalf("Wrong directives: %v", d)
+	}
+	if len(d.NofollowLinks) != 2 || !d.NofollowLinks["http://example.com/base/b.html"] || !d.NofollowLinks["http://example.com/c"] {
+		t.Fatalf("Wrong nofollow links: %v", d.NofollowLinks)
+	}
+	if d.String() != "meta:noindex,nofollow-links:2" {
+		t.Fatalf("Wrong string: %s", d.String())
+	}
+
+	//非HTML响应只看响应头
+	resp = basic.NewResponse([]byte(`{"a": 1}`), 0, "application/json", "http://example.com/api")
+	resp.Header = http.Header{}
+	resp.Header.Add("X-Robots-Tag", "none")
+	if d := Parse(resp); !d.NoIndex || !d.NoFollow || d.String() != "header:none" {
+		t.Fatalf("Wrong directives: %v", d)
+	}
+	resp.Header = http.Header{}
+	resp.Header.Add("X-Robots-Tag", "unavailable_after: 25 Jun 2010 15:00:00 PST")
+	if d := Parse(resp); !d.Empty() {
+		t.Fatalf("Expect empty directives: %v", d)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	resp := basic.NewResponse([]byte(testPage), 0, "text/html", "http://example.com/index.html")
+	d := Parse(resp)
+	item := basic.Item{"url": resp.ReqUrl}
+	items := []*basic.Item{&item}
+	reqs := []*basic.Request{
+		newReq(t, "http://example.com/base/a.html"),
+		newReq(t, "http://example.com/base/b.html"),
+		newReq(t, "http://example.com/c/"),
+	}
+
+	keptItems, keptReqs, msg := d.Filter(items, reqs, true)
+	if len(keptItems) != 0 || len(keptReqs) != 1 || msg != "items:1,links:2" {
+		t.Fatalf("Wrong strict result: %d %d %s", len(keptItems), len(keptReqs), msg)
+	}
+	keptItems, keptReqs, msg = d.Filter(items, reqs, false)
+	if len(keptItems) != 1 || len(keptReqs) != 3 || msg != "ignored items:1,links:2" {
+		t.Fatalf("Wrong record result: %d %d %s", len(keptItems), len(keptReqs), msg)
+	}
+
+	//页面级nofollow, 全部链接都不跟进
+	d = &Directives{NoFollow: true, NofollowLinks: map[string]bool{}}
+	keptItems, keptReqs, msg = d.Filter(items, reqs, true)
+	if len(keptItems) != 1 || len(keptReqs) != 0 || msg != "links:3" {
+		t.Fatalf("Wrong nofollow result: %d %d %s", len(keptItems), len(keptReqs), msg)
+	}
+}
@@ -0,0 +1,369 @@
+package sink
+
+/*
+ * 条目批量写入Elasticsearch(以及兼容_bulk接口的服务)
+ * 1. 条目先缓存, 达到BatchSize或者每隔FlushInterval, 通过一次_bulk请求写入
+ * 2. 整个请求失败(网络错误, 429, 5xx)按指数退避重试; 请求成功但部分文档返回429/5xx的, 只重试这些文档
+ * 3. 其他文档级别的错误(比如mapping冲突)不重试, 每个文档单独报告: 文档id, 状态码, 错误类型和原因
+ * 4. Process只返回条目本身的错误; 写入失败按文档排队, 由TakeErrors取出(调度器放入错误通道), Close时返回剩余的
+ */
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hq-cml/spider-man/basic"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const FORMAT_ELASTIC = "elastic"
+
+//配置
+type ElasticOptions struct {
+	Url           string        //服务地址, 比如http://127.0.0.1:9200
+	Index         string        //索引名
+	IdField       string        //作为文档id的字段, 用.访问嵌套字段; 为空或者条目中没有该字段则由服务生成id
+	BatchSize     int           //每批的文档数
+	FlushInterval time.Duration //定时写入的间隔, 0表示只按BatchSize写入
+	MaxRetries    int           //最大重试次数
+	Backoff       time.Duration //首次重试的等待时间, 之后每次翻倍
+	Client        *http.Client  //为空则使用带超时的默认客户端
+}
+
+//一个待写入的文档
+type bulkDoc struct {
+	id     string
+	url    string //条目的url, 用于报告错误
+	action []byte //action行和文档行, 都以\n结尾
+}
+
+//_bulk的响应
+type bulkResponse struct {
+	Errors bool                            `json:"errors"`
+	Items  []map[string]bulkResponseResult `json:"items"`
+}
+
+type bulkResponseResult struct {
+	Id     string          `json:"_id"`
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+//文档级别的错误, 整个请求失败时每个文档也各报告一个, Status为请求的状态码(网络错误为0)
+type DocError struct {
+	Id     string
+	Url    string
+	Status int
+	Reason string
+}
+
+func (e *DocError) Error() string {
+	return fmt.Sprintf("Index doc(id=%s, url=%s) error. Status: %d. Reason: %s", e.Id, e.Url, e.Status, e.Reason)
+}
+
+//Elasticsearch输出实现类型
+type Elastic struct {
+	mutex   sync.Mutex
+	opts    ElasticOptions
+	bulkUrl string
+	pending []*bulkDoc
+	errs    []error //写入失败的文档, 由TakeErrors或者Close取出
+	stop    chan struct{}
+	done    chan struct{}
+	closed  bool
+	indexed uint64 //写入成功的文档数
+	failed  uint64 //写入失败的文档数
+	batches uint64 //_bulk请求数, 包括重试
+	retries uint64 //重试次数
+}
+
+//New, 配置了FlushInterval的, 启动定时写入
+func NewElastic(opts ElasticOptions) (*Elastic, error) {
+	if opts.Url == "" || opts.Index == "" {
+		return nil, errors.New("The elastic url and index can not be empty!")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 500 * time.Millisecond
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 60 * time.Second}
+	}
+	e := &Elastic{
+		opts:    opts,
+		bulkUrl: strings.TrimRight(opts.Url, "/") + "/_bulk",
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.loop()
+	return e, nil
+}
+
+//定时写入
+func (e *Elastic) loop() {
+	defer close(e.done)
+	if e.opts.FlushInterval <= 0 {
+		<-e.stop
+		return
+	}
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			e.mutex.Lock()
+			e.flush()
+			e.mutex.Unlock()
+		}
+	}
+}
+
+//缓存一个条目, 达到BatchSize则写入; 条目原样返回, 处理链继续
+//只返回条目本身的错误(序列化失败, 已关闭), 写入失败见TakeErrors
+func (e *Elastic) Process(item basic.Item) (basic.Item, error) {
+	if item == nil {
+		return nil, errors.New("Invalid item!")
+	}
+	doc, err := e.newDoc(item)
+	if err != nil {
+		return item, err
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.closed {
+		return item, ErrClosed
+	}
+	e.pending = append(e.pending, doc)
+	if len(e.pending) >= e.opts.BatchSize {
+		e.flush()
+	}
+	return item, nil
+}
+
+//生成action行和文档行
+func (e *Elastic) newDoc(item basic.Item) (*bulkDoc, error) {
+	id := ""
+	if e.opts.IdField != "" {
+		if v := lookup(item, e.opts.IdField); v != nil {
+			id = csvValue(v)
+		}
+	}
+	meta := map[string]string{"_index": e.opts.Index}
+	if id != "" {
+		meta["_id"] = id
+	}
+	action, err := json.Marshal(map[string]interface{}{"index": meta})
+	if err != nil {
+		return nil, err
+	}
+	source, err := json.Marshal(item)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Marshal item error: %s. (url=%v)", err, item["url"]))
+	}
+	var buff bytes.Buffer
+	buff.Write(action)
+	buff.WriteByte('\n')
+	buff.Write(source)
+	buff.WriteByte('\n')
+	return &bulkDoc{id: id, url: fmt.Sprint(item["url"]), action: buff.Bytes()}, nil
+}
+
+//立即写入缓存的全部文档, 返回尚未取出的写入失败
+func (e *Elastic) Flush() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.flush()
+	return joinErrors(e.takeErrors())
+}
+
+//取出写入失败的文档错误, 每个错误对应一个文档
+func (e *Elastic) TakeErrors() []error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.takeErrors()
+}
+
+func (e *Elastic) takeErrors() []error {
+	errs := e.errs
+	e.errs = nil
+	return errs
+}
+
+//写入缓存的全部文档, 需持有锁; 写入期间阻塞其他Process, 形成背压; 失败的文档进入e.errs
+func (e *Elastic) flush() {
+	docs := e.pending
+	e.pending = nil
+	if len(docs) == 0 {
+		return
+	}
+
+	var lastErr error
+	for attempt := 0; len(docs) > 0; attempt++ {
+		if attempt > 0 {
+			if attempt > e.opts.MaxRetries {
+				break
+			}
+			e.retries++
+			time.Sleep(e.opts.Backoff << uint(attempt-1))
+		}
+
+		retry, errs, err := e.send(docs)
+		if err != nil {
+			lastErr = err
+			if !isRetryable(err) {
+				break
+			}
+			continue //整批重试
+		}
+		lastErr = nil
+		for _, de := range errs {
+			e.errs = append(e.errs, de)
+		}
+		docs = retry
+	}
+
+	//重试用尽, 剩下的文档都算失败, 每个文档报告一个错误
+	if len(docs) > 0 {
+		e.failed += uint64(len(docs))
+		status, reason := 0, fmt.Sprintf("Still rejected after %d retries", e.opts.MaxRetries)
+		if se, ok := lastErr.(*statusError); ok {
+			status, reason = se.status, se.body
+		} else if lastErr != nil {
+			reason = lastErr.Error()
+		}
+		for _, d := range docs {
+			e.errs = append(e.errs, &DocError{Id: d.id, Url: d.url, Status: status, Reason: reason})
+		}
+	}
+}
+
+//请求级别的错误, 可重试的是网络错误和429/5xx
+type statusError struct {
+	status int
+	body   string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("Bulk request status: %d. Body: %s", e.status, e.body)
+}
+
+func isRetryable(err error) bool {
+	if se, ok := err.(*statusError); ok {
+		return retryableStatus(se.status)
+	}
+	return true //网络错误
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+//发送一次_bulk请求, 返回需要重试的文档和不可重试的文档错误
+func (e *Elastic) send(docs []*bulkDoc) ([]*bulkDoc, []*DocError, error) {
+	var body bytes.Buffer
+	for _, d := range docs {
+		body.Write(d.action)
+	}
+	req, err := http.NewRequest(http.MethodPost, e.bulkUrl, &body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	e.batches++
+	resp, err := e.opts.Client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 512 {
+			data = data[:512]
+		}
+		return nil, nil, &statusError{status: resp.StatusCode, body: string(data)}
+	}
+
+	result := bulkResponse{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, nil, &statusError{status: resp.StatusCode, body: "Invalid bulk response: " + err.Error()}
+	}
+	if !result.Errors {
+		e.indexed += uint64(len(docs))
+		return nil, nil, nil
+	}
+	if len(result.Items) != len(docs) {
+		return nil, nil, &statusError{status: resp.StatusCode,
+			body: fmt.Sprintf("Bulk response has %d items, expect %d", len(result.Items), len(docs))}
+	}
+
+	//逐个文档检查, 响应中items的顺序和请求一致
+	retry := []*bulkDoc{}
+	docErrs := []*DocError{}
+	for i, item := range result.Items {
+		for _, r := range item { //只有一个key: index, create等
+			switch {
+			case r.Status >= 200 && r.Status < 300:
+				e.indexed++
+			case retryableStatus(r.Status):
+				retry = append(retry, docs[i])
+			default:
+				e.failed++
+				id := r.Id
+				if id == "" {
+					id = docs[i].id
+				}
+				docErrs = append(docErrs, &DocError{Id: id, Url: docs[i].url, Status: r.Status, Reason: errorReason(r.Error)})
+			}
+		}
+	}
+	return retry, docErrs, nil
+}
+
+//错误原因: {"type": "...", "reason": "..."}, 或者字符串
+func errorReason(raw json.RawMessage) string {
+	detail := struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}{}
+	if err := json.Unmarshal(raw, &detail); err == nil && (detail.Type != "" || detail.Reason != "") {
+		return detail.Type + ": " + detail.Reason
+	}
+	return string(raw)
+}
+
+//停止定时写入并写入剩余的文档, 返回尚未取出的写入失败; 之后的写入返回ErrClosed
+func (e *Elastic) Close() error {
+	e.mutex.Lock()
+	if e.closed {
+		e.mutex.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mutex.Unlock()
+
+	//先停止定时写入, 再写入剩余的文档
+	close(e.stop)
+	<-e.done
+	return e.Flush()
+}
+
+//摘要
+func (e *Elastic) Summary(prefix string) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return fmt.Sprintf(prefix+"elastic Index: %s, Indexed: %d, Failed: %d, Pending: %d, Batches: %d, Retries: %d\n",
+		e.opts.Index, e.indexed, e.failed, len(e.pending), e.batches, e.retries)
+}
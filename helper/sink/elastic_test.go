@@ -0,0 +1,262 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/hq-cml/spider-man/basic"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//模拟_bulk接口: 记录每次请求的文档, 由reply决定响应
+type fakeBulk struct {
+	mutex    sync.Mutex
+	requests [][]bulkLine
+	reply    func(n int, lines []bulkLine) (int, string) //n: 第几次请求, 从0开始
+}
+
+type bulkLine struct {
+	action map[string]map[string]string
+	source map[string]interface{}
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	lines := []bulkLine{}
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		l := bulkLine{}
+		json.Unmarshal(scanner.Bytes(), &l.action)
+		scanner.Scan()
+		json.Unmarshal(scanner.Bytes(), &l.source)
+		lines = append(lines, l)
+	}
+	f.mutex.Lock()
+	n := len(f.requests)
+	f.requests = append(f.requests, lines)
+	f.mutex.Unlock()
+
+	status, body := http.StatusOK, bulkReply(lines, nil)
+	if f.reply != nil {
+		status, body = f.reply(n, lines)
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+func (f *fakeBulk) count() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.requests)
+}
+
+//生成_bulk响应, statuses按文档id指定状态码, 没有指定的为201
+func bulkReply(lines []bulkLine, statuses map[string]int) string {
+	errs := false
+	items := []string{}
+	for _, l := range lines {
+		id := l.action["index"]["_id"]
+		status, ok := statuses[id]
+		if !ok {
+			status = 201
+		}
+		if status >= 300 {
+			errs = true
+			items = append(items, fmt.Sprintf(`{"index":{"_id":%q,"status":%d,"error":{"type":"mapper_parsing_exception","reason":"failed to parse field [depth]"}}}`, id, status))
+		} else {
+			items = append(items, fmt.Sprintf(`{"index":{"_id":%q,"status":%d}}`, id, status))
+		}
+	}
+	return fmt.Sprintf(`{"took":1,"errors":%v,"items":[%s]}`, errs, strings.Join(items, ","))
+}
+
+func newTestElastic(t *testing.T, f *fakeBulk, opts ElasticOptions) (*Elastic, func()) {
+	server := httptest.NewServer(f)
+	opts.Url = server.URL + "/"
+	if opts.Index == "" {
+		opts.Index = "news"
+	}
+	if opts.Backoff == 0 {
+		opts.Backoff = time.Millisecond
+	}
+	e, err := NewElastic(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, server.Close
+}
+
+func TestElasticBatch(t *testing.T) {
+	f := &fakeBulk{}
+	e, stop := newTestElastic(t, f, ElasticOptions{IdField: "meta.id", BatchSize: 2})
+	defer stop()
+
+	items := []basic.Item{
+		{"url": "http://www.360.cn/1", "meta": map[string]interface{}{"id": "a"}},
+		{"url": "http://www.360.cn/2", "meta": map[string]interface{}{"id": 2}},
+		{"url": "http://www.360.cn/3"}, //没有id字段, 由服务生成
+	}
+	for i, item := range items {
+		if _, err := e.Process(item); err != nil {
+			t.Fatal(err)
+		}
+		//第二个条目凑满一批
+		if expect := (i + 1) / 2; f.count() != expect {
+			t.Fatal("Wrong request count:", i, f.count())
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Process(basic.Item{"url": "x"}); err != ErrClosed {
+		t.Fatal("Should be closed:", err)
+	}
+
+	if f.count() != 2 || len(f.requests[0]) != 2 || len(f.requests[1]) != 1 {
+		t.Fatal("Wrong batches:", f.requests)
+	}
+	first := f.requests[0]
+	if first[0].action["index"]["_index"] != "news" || first[0].action["index"]["_id"] != "a" ||
+		first[1].action["index"]["_id"] != "2" || first[1].source["url"] != "http://www.360.cn/2" {
+		t.Fatal("Wrong docs:", first)
+	}
+	if _, ok := f.requests[1][0].action["index"]["_id"]; ok {
+		t.Fatal("Should not have _id:", f.requests[1][0].action)
+	}
+	if e.indexed != 3 || e.failed != 0 || e.batches != 2 {
+		t.Fatal("Wrong stats:", e.Summary(""))
+	}
+}
+
+func TestElasticRetry(t *testing.T) {
+	//整个请求: 429, 503, 然后成功
+	f := &fakeBulk{reply: func(n int, lines []bulkLine) (int, string) {
+		switch n {
+		case 0:
+			return http.StatusTooManyRequests, `{"error":"rejected"}`
+		case 1:
+			return http.StatusServiceUnavailable, ""
+		}
+		return http.StatusOK, bulkReply(lines, nil)
+	}}
+	e, stop := newTestElastic(t, f, ElasticOptions{IdField: "url", BatchSize: 1, MaxRetries: 3})
+	defer stop()
+	if _, err := e.Process(basic.Item{"url": "http://www.360.cn/1"}); err != nil {
+		t.Fatal(err)
+	}
+	if f.count() != 3 || e.retries != 2 || e.indexed != 1 {
+		t.Fatal("Wrong retries:", f.count(), e.Summary(""))
+	}
+	e.Close()
+
+	//重试用尽
+	f = &fakeBulk{reply: func(n int, lines []bulkLine) (int, string) {
+		return http.StatusBadGateway, "bad gateway"
+	}}
+	e, stop = newTestElastic(t, f, ElasticOptions{BatchSize: 1, MaxRetries: 2})
+	defer stop()
+	if _, err := e.Process(basic.Item{"url": "http://www.360.cn/1"}); err != nil {
+		t.Fatal("Write failure should not be returned by Process:", err)
+	}
+	errs := e.TakeErrors()
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "url=http://www.360.cn/1") || errs[0].(*DocError).Status != 502 {
+		t.Fatal("Should fail:", errs)
+	}
+	if f.count() != 3 || e.failed != 1 {
+		t.Fatal("Wrong failed:", f.count(), e.Summary(""))
+	}
+	e.Close()
+
+	//4xx不重试
+	f = &fakeBulk{reply: func(n int, lines []bulkLine) (int, string) {
+		return http.StatusBadRequest, "bad request"
+	}}
+	e, stop = newTestElastic(t, f, ElasticOptions{BatchSize: 1, MaxRetries: 2})
+	defer stop()
+	e.Process(basic.Item{"url": "http://www.360.cn/1"})
+	if errs := e.TakeErrors(); len(errs) != 1 || f.count() != 1 {
+		t.Fatal("Should not retry:", errs, f.count())
+	}
+	e.Close()
+}
+
+func TestElasticDocErrors(t *testing.T) {
+	//a成功, b文档错误不重试, c第一次被拒绝(429)后重试成功
+	f := &fakeBulk{reply: func(n int, lines []bulkLine) (int, string) {
+		if n == 0 {
+			return http.StatusOK, bulkReply(lines, map[string]int{"b": 400, "c": 429})
+		}
+		return http.StatusOK, bulkReply(lines, nil)
+	}}
+	e, stop := newTestElastic(t, f, ElasticOptions{IdField: "id", BatchSize: 3, MaxRetries: 1})
+	defer stop()
+	e.Process(basic.Item{"id": "a"})
+	e.Process(basic.Item{"id": "b"})
+	//凑满一批的条目本身没有错误
+	if _, err := e.Process(basic.Item{"id": "c"}); err != nil {
+		t.Fatal(err)
+	}
+	errs := e.TakeErrors()
+	if len(errs) != 1 || errs[0].(*DocError).Id != "b" || !strings.Contains(errs[0].Error(), "mapper_parsing_exception") {
+		t.Fatal("Wrong doc errors:", errs)
+	}
+	if errs := e.TakeErrors(); len(errs) != 0 {
+		t.Fatal("Errors should be taken once:", errs)
+	}
+	if f.count() != 2 || len(f.requests[1]) != 1 || f.requests[1][0].action["index"]["_id"] != "c" {
+		t.Fatal("Should only retry c:", f.requests)
+	}
+	if e.indexed != 2 || e.failed != 1 || e.retries != 1 {
+		t.Fatal("Wrong stats:", e.Summary(""))
+	}
+	e.Close()
+}
+
+func TestElasticFlushInterval(t *testing.T) {
+	f := &fakeBulk{}
+	e, stop := newTestElastic(t, f, ElasticOptions{BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	defer stop()
+	e.Process(basic.Item{"url": "http://www.360.cn/1"})
+	for i := 0; i < 100 && f.count() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f.count() != 1 {
+		t.Fatal("Should flush by interval")
+	}
+	if err := e.Close(); err != nil || f.count() != 1 {
+		t.Fatal("Nothing to flush on close:", err, f.count())
+	}
+}
+
+func TestElasticFlushIntervalError(t *testing.T) {
+	f := &fakeBulk{reply: func(n int, lines []bulkLine) (int, string) {
+		return http.StatusOK, bulkReply(lines, map[string]int{"a": 400})
+	}}
+	e, stop := newTestElastic(t, f, ElasticOptions{IdField: "id", BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	defer stop()
+	e.Process(basic.Item{"id": "a"})
+	for i := 0; i < 100 && f.count() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	//定时写入的失败保留到Close时返回
+	if err := e.Close(); err == nil || !strings.Contains(err.Error(), "id=a") {
+		t.Fatal("Should report interval error:", err)
+	}
+}
+
+func TestNewElasticError(t *testing.T) {
+	if _, err := NewElastic(ElasticOptions{Index: "news"}); err == nil {
+		t.Fatal("Should fail without url")
+	}
+	if _, err := NewElastic(ElasticOptions{Url: "http://127.0.0.1:9200"}); err == nil {
+		t.Fatal("Should fail without index")
+	}
+}
@@ -0,0 +1,313 @@
+package sink
+
+/*
+ * 条目写入搜索引擎Spider-Engine
+ * 1. 接口: POST http://addr/db/table/key, 文档为JSON, 响应为{"code": 0, "data": "..."}, code非0表示失败
+ * 2. 条目先缓存, 达到BatchSize或者每隔FlushInterval写入一批, 共享连接池
+ *    写入在锁外进行, 同时最多Concurrency个请求; 请求数满了, Process才会等待, 形成背压
+ * 3. 网络错误和429/5xx按指数退避重试, code非0和其他状态码不重试
+ * 4. Process只返回条目本身的错误; 写入失败按文档(主键)排队, 由TakeErrors取出(进入调度器的错误通道), Close时返回剩余的
+ */
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hq-cml/spider-man/basic"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//配置
+type EngineOptions struct {
+	Addr          string        //Spider-Engine地址, 比如127.0.0.1:9528
+	Db            string        //库名
+	Table         string        //表名
+	KeyField      string        //作为主键的字段, 用.访问嵌套字段, 条目中没有该字段的返回错误
+	BatchSize     int           //每批的文档数
+	FlushInterval time.Duration //定时写入的间隔, 0表示只按BatchSize写入
+	Concurrency   int           //同时进行的最大请求数, 也是连接池中每个host的空闲连接数
+	MaxRetries    int           //最大重试次数
+	Backoff       time.Duration //首次重试的等待时间, 之后每次翻倍
+	Timeout       time.Duration //单个请求的超时
+}
+
+//Spider-Engine的响应
+type EngineResult struct {
+	Code int    `json:"code"`
+	Data string `json:"data"`
+}
+
+//一个待写入的文档
+type engineDoc struct {
+	key  string
+	url  string //条目的url, 用于报告错误
+	data []byte
+}
+
+//Spider-Engine输出实现类型
+type Engine struct {
+	mutex   sync.Mutex
+	idle    *sync.Cond //posting归零时通知
+	opts    EngineOptions
+	baseUrl string //http://addr/db/table/
+	client  *http.Client
+	pending []*engineDoc
+	posting int           //已经取出但还没有写完的文档数
+	sem     chan struct{} //同时进行的请求数
+	errs    []error       //写入失败的文档, 由TakeErrors或者Close取出
+	stop    chan struct{}
+	done    chan struct{}
+	closed  bool
+	sent    uint64 //写入成功的文档数
+	failed  uint64 //写入失败的文档数
+	batches uint64 //写入的批数
+	retries uint64 //重试次数
+}
+
+//New, 配置了FlushInterval的, 启动定时写入
+func NewEngine(opts EngineOptions) (*Engine, error) {
+	if opts.Addr == "" || opts.Db == "" || opts.Table == "" {
+		return nil, errors.New("The engine addr, db and table can not be empty!")
+	}
+	if opts.KeyField == "" {
+		opts.KeyField = "id"
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = 500 * time.Millisecond
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	addr := strings.TrimRight(opts.Addr, "/")
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	//所有请求共享连接池, 空闲连接数和并发数一致, 避免频繁建连
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = opts.Concurrency
+	e := &Engine{
+		opts:    opts,
+		baseUrl: fmt.Sprintf("%s/%s/%s/", addr, url.PathEscape(opts.Db), url.PathEscape(opts.Table)),
+		client:  &http.Client{Transport: transport, Timeout: opts.Timeout},
+		sem:     make(chan struct{}, opts.Concurrency),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	e.idle = sync.NewCond(&e.mutex)
+	go e.loop()
+	return e, nil
+}
+
+//定时写入
+func (e *Engine) loop() {
+	defer close(e.done)
+	if e.opts.FlushInterval <= 0 {
+		<-e.stop
+		return
+	}
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			e.mutex.Lock()
+			docs := e.take()
+			e.mutex.Unlock()
+			e.send(docs)
+		}
+	}
+}
+
+//缓存一个条目, 达到BatchSize则写入; 条目原样返回, 处理链继续
+//只返回条目本身的错误(没有主键, 序列化失败, 已关闭), 写入失败见TakeErrors
+func (e *Engine) Process(item basic.Item) (basic.Item, error) {
+	if item == nil {
+		return nil, errors.New("Invalid item!")
+	}
+	key := csvValue(lookup(item, e.opts.KeyField))
+	if key == "" {
+		return item, errors.New(fmt.Sprintf("Need primary key: %s. (url=%v)", e.opts.KeyField, item["url"]))
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return item, errors.New(fmt.Sprintf("Marshal item error: %s. (url=%v)", err, item["url"]))
+	}
+
+	e.mutex.Lock()
+	if e.closed {
+		e.mutex.Unlock()
+		return item, ErrClosed
+	}
+	e.pending = append(e.pending, &engineDoc{key: key, url: fmt.Sprint(item["url"]), data: data})
+	var docs []*engineDoc
+	if len(e.pending) >= e.opts.BatchSize {
+		docs = e.take()
+	}
+	e.mutex.Unlock()
+
+	//在锁外写入, 不阻塞其他Process
+	e.send(docs)
+	return item, nil
+}
+
+//取出写入失败的文档错误, 每个错误对应一个文档
+func (e *Engine) TakeErrors() []error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.takeErrors()
+}
+
+func (e *Engine) takeErrors() []error {
+	errs := e.errs
+	e.errs = nil
+	return errs
+}
+
+//立即写入缓存的全部文档, 等待所有写入完成, 返回尚未取出的写入失败
+func (e *Engine) Flush() error {
+	e.mutex.Lock()
+	docs := e.take()
+	e.mutex.Unlock()
+	e.send(docs)
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.wait()
+	return joinErrors(e.takeErrors())
+}
+
+//取出缓存的全部文档, 需持有锁; 取出的文档计入posting, 直到写完
+func (e *Engine) take() []*engineDoc {
+	docs := e.pending
+	e.pending = nil
+	if len(docs) > 0 {
+		e.batches++
+		e.posting += len(docs)
+	}
+	return docs
+}
+
+//等待取出的文档全部写完, 需持有锁
+func (e *Engine) wait() {
+	for e.posting > 0 {
+		e.idle.Wait()
+	}
+}
+
+//写入一批文档, 不能持有锁; 每个文档一个请求, 同时最多Concurrency个, 满了则等待
+//不等待写完就返回, 失败的文档进入e.errs
+func (e *Engine) send(docs []*engineDoc) {
+	for _, doc := range docs {
+		e.sem <- struct{}{}
+		go func(doc *engineDoc) {
+			retries, err := e.post(doc)
+			<-e.sem
+
+			e.mutex.Lock()
+			defer e.mutex.Unlock()
+			e.retries += uint64(retries)
+			if err != nil {
+				e.failed++
+				e.errs = append(e.errs, errors.New(fmt.Sprintf("Post doc(key=%s, url=%s) error: %s", doc.key, doc.url, err)))
+			} else {
+				e.sent++
+			}
+			e.posting--
+			if e.posting == 0 {
+				e.idle.Broadcast()
+			}
+		}(doc)
+	}
+}
+
+//写入一个文档, 可重试的失败按指数退避重试, 返回重试次数
+func (e *Engine) post(doc *engineDoc) (int, error) {
+	var err error
+	retries := 0
+	for attempt := 0; attempt <= e.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			retries++
+			time.Sleep(e.opts.Backoff << uint(attempt-1))
+		}
+		var retryable bool
+		retryable, err = e.postOnce(doc)
+		if err == nil || !retryable {
+			break
+		}
+	}
+	return retries, err
+}
+
+//发送一次请求, 返回是否可以重试
+func (e *Engine) postOnce(doc *engineDoc) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, e.baseUrl+url.PathEscape(doc.key), bytes.NewReader(doc.data))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return true, err //网络错误
+	}
+	//读完并关闭Body, 连接才能放回连接池
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return true, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(data) > 512 {
+			data = data[:512]
+		}
+		return retryableStatus(resp.StatusCode), errors.New(fmt.Sprintf("Status: %d. Body: %s", resp.StatusCode, data))
+	}
+
+	r := EngineResult{}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return false, errors.New("Invalid engine response: " + err.Error())
+	}
+	if r.Code != 0 {
+		return false, errors.New(fmt.Sprintf("Code: %d. Data: %s", r.Code, r.Data))
+	}
+	return false, nil
+}
+
+//停止定时写入并写入剩余的文档, 返回尚未取出的写入失败; 之后的写入返回ErrClosed
+func (e *Engine) Close() error {
+	e.mutex.Lock()
+	if e.closed {
+		e.mutex.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mutex.Unlock()
+
+	//先停止定时写入, 再写入剩余的文档
+	close(e.stop)
+	<-e.done
+	return e.Flush()
+}
+
+//摘要
+func (e *Engine) Summary(prefix string) string {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return fmt.Sprintf(prefix+"engine Table: %s/%s, Sent: %d, Failed: %d, Pending: %d, Posting: %d, Batches: %d, Retries: %d\n",
+		e.opts.Db, e.opts.Table, e.sent, e.failed, len(e.pending), e.posting, e.batches, e.retries)
+}
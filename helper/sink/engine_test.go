@@ -0,0 +1,230 @@
+package sink
+
+import (
+	"encoding/json"
+	"github.com/hq-cml/spider-man/basic"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//模拟Spider-Engine: 记录每次请求的路径和文档, 由reply决定响应
+type fakeEngine struct {
+	mutex    sync.Mutex
+	paths    []string
+	docs     map[string]map[string]interface{}
+	attempts map[string]int
+	reply    func(key string, attempt int) (int, string) //attempt: 该主键第几次请求, 从0开始
+}
+
+func (f *fakeEngine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	doc := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	key := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	f.mutex.Lock()
+	attempt := f.attempts[key]
+	f.attempts[key]++
+	f.paths = append(f.paths, r.URL.Path)
+	f.mutex.Unlock()
+
+	status, body := http.StatusOK, `{"code":0,"data":"ok"}`
+	if f.reply != nil {
+		status, body = f.reply(key, attempt)
+	}
+	if status == http.StatusOK && strings.Contains(body, `"code":0`) {
+		f.mutex.Lock()
+		f.docs[key] = doc
+		f.mutex.Unlock()
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(body))
+}
+
+func (f *fakeEngine) count() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.paths)
+}
+
+func newTestEngine(t *testing.T, f *fakeEngine, opts EngineOptions) (*Engine, func()) {
+	f.docs = map[string]map[string]interface{}{}
+	f.attempts = map[string]int{}
+	server := httptest.NewServer(f)
+	opts.Addr = strings.TrimPrefix(server.URL, "http://")
+	opts.Db = "sp_db"
+	opts.Table = "360news"
+	if opts.Backoff == 0 {
+		opts.Backoff = time.Millisecond
+	}
+	e, err := NewEngine(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, server.Close
+}
+
+//等待已经取出的文档全部写完
+func settle(e *Engine) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.wait()
+}
+
+func TestEngineBatch(t *testing.T) {
+	f := &fakeEngine{}
+	e, stop := newTestEngine(t, f, EngineOptions{BatchSize: 3, Concurrency: 2})
+	defer stop()
+
+	for i, id := range []string{"10758", "10759", "10760", "10761"} {
+		if _, err := e.Process(basic.Item{"id": id, "title": "t" + id}); err != nil {
+			t.Fatal(err)
+		}
+		//凑满3个才写入
+		settle(e)
+		if expect := (i + 1) / 3 * 3; f.count() != expect {
+			t.Fatal("Wrong request count:", i, f.count())
+		}
+	}
+	//没有主键
+	if _, err := e.Process(basic.Item{"url": "http://www.360.cn/n/x.html"}); err == nil {
+		t.Fatal("Should need primary key")
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Process(basic.Item{"id": "1"}); err != ErrClosed {
+		t.Fatal("Should be closed:", err)
+	}
+
+	if len(f.docs) != 4 || f.docs["10761"]["title"] != "t10761" {
+		t.Fatal("Wrong docs:", f.docs)
+	}
+	for _, p := range f.paths {
+		if !strings.HasPrefix(p, "/sp_db/360news/107") {
+			t.Fatal("Wrong path:", p)
+		}
+	}
+	if e.sent != 4 || e.failed != 0 || e.batches != 2 {
+		t.Fatal("Wrong stats:", e.Summary(""))
+	}
+}
+
+func TestEngineRetry(t *testing.T) {
+	//a: 503后成功; b: code非0不重试; c: 一直502, 重试用尽; d: 400不重试
+	f := &fakeEngine{reply: func(key string, attempt int) (int, string) {
+		switch key {
+		case "a":
+			if attempt == 0 {
+				return http.StatusServiceUnavailable, ""
+			}
+		case "b":
+			return http.StatusOK, `{"code":1,"data":"table not found"}`
+		case "c":
+			return http.StatusBadGateway, "bad gateway"
+		case "d":
+			return http.StatusBadRequest, "bad request"
+		}
+		return http.StatusOK, `{"code":0,"data":"ok"}`
+	}}
+	e, stop := newTestEngine(t, f, EngineOptions{BatchSize: 4, MaxRetries: 2})
+	defer stop()
+
+	e.Process(basic.Item{"id": "a"})
+	e.Process(basic.Item{"id": "b"})
+	e.Process(basic.Item{"id": "c"})
+	//凑满一批的条目本身没有错误, 其他文档的失败不算在它头上
+	if _, err := e.Process(basic.Item{"id": "d"}); err != nil {
+		t.Fatal(err)
+	}
+	settle(e)
+	//每个失败的文档一个错误
+	msgs := []string{}
+	for _, err := range e.TakeErrors() {
+		msgs = append(msgs, err.Error())
+	}
+	sort.Strings(msgs)
+	if len(msgs) != 3 || !strings.Contains(msgs[0], "key=b") || !strings.Contains(msgs[0], "table not found") ||
+		!strings.Contains(msgs[1], "key=c") || !strings.Contains(msgs[2], "key=d") || !strings.Contains(msgs[2], "Status: 400") {
+		t.Fatal("Wrong errors:", msgs)
+	}
+	if f.attempts["a"] != 2 || f.attempts["b"] != 1 || f.attempts["c"] != 3 || f.attempts["d"] != 1 {
+		t.Fatal("Wrong attempts:", f.attempts)
+	}
+	if e.sent != 1 || e.failed != 3 || e.retries != 3 {
+		t.Fatal("Wrong stats:", e.Summary(""))
+	}
+	//错误只报告一次
+	if errs := e.TakeErrors(); len(errs) != 0 {
+		t.Fatal("Errors should be taken once:", errs)
+	}
+	e.Close()
+}
+
+func TestEngineFlushInterval(t *testing.T) {
+	f := &fakeEngine{reply: func(key string, attempt int) (int, string) {
+		return http.StatusOK, `{"code":2,"data":"duplicate"}`
+	}}
+	e, stop := newTestEngine(t, f, EngineOptions{BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	defer stop()
+	e.Process(basic.Item{"id": "a"})
+	for i := 0; i < 100 && f.count() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f.count() != 1 {
+		t.Fatal("Should flush by interval")
+	}
+	//定时写入的失败在Close时返回
+	if err := e.Close(); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Fatal("Should report interval error:", err)
+	}
+}
+
+func TestEngineNoStall(t *testing.T) {
+	//slow一直等到release才响应, 期间其他条目的处理和摘要不能被阻塞
+	release := make(chan struct{})
+	f := &fakeEngine{reply: func(key string, attempt int) (int, string) {
+		if key == "slow" {
+			<-release
+		}
+		return http.StatusOK, `{"code":0,"data":"ok"}`
+	}}
+	e, stop := newTestEngine(t, f, EngineOptions{BatchSize: 1, Concurrency: 2})
+	defer stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Process(basic.Item{"id": "slow"})
+		e.Process(basic.Item{"id": "fast"})
+		e.Summary("")
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		close(release)
+		t.Fatal("Process should not wait for a slow post")
+	}
+	for i := 0; i < 100 && !strings.Contains(e.Summary(""), "Sent: 1,"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(e.Summary(""), "Sent: 1, Failed: 0, Pending: 0, Posting: 1,") {
+		t.Fatal("Fast doc should be sent:", e.Summary(""))
+	}
+
+	//Close等待写入中的文档
+	close(release)
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if e.sent != 2 || e.posting != 0 || len(f.docs) != 2 {
+		t.Fatal("Wrong stats:", e.Summary(""))
+	}
+}
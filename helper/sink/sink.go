@@ -0,0 +1,292 @@
+package sink
+
+/*
+ * 条目输出到本地文件: JSON Lines或者CSV
+ * 1. 按大小(写入的未压缩字节数)和时间切分文件, 切分只在写入时检查
+ * 2. 可选gzip压缩
+ * 3. 写入中的文件以.part结尾, 切分或者关闭时fsync并去掉.part, 下游只需处理不以.part结尾的文件
+ * Process的签名和basic.ProcessItemFunc一致, 可以直接放入处理链, 并发安全
+ */
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hq-cml/spider-man/basic"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//输出格式
+const (
+	FORMAT_JSONL = "jsonl"
+	FORMAT_CSV   = "csv"
+)
+
+//写入中的文件后缀
+const partSuffix = ".part"
+
+var ErrClosed = errors.New("The sink has been closed!")
+
+//条目输出的公共接口: 文件输出(Sink)和Elasticsearch输出(Elastic)
+//Process只返回条目本身的错误; 批量写入的失败由TakeErrors取出, 每个错误对应一个文档
+type ItemSink interface {
+	Process(item basic.Item) (basic.Item, error)
+	TakeErrors() []error
+	Close() error
+	Summary(prefix string) string
+}
+
+//配置
+type Options struct {
+	Format   string        //jsonl, csv
+	Dir      string        //输出目录
+	Prefix   string        //文件名前缀, 文件名为: 前缀-时间-序号.jsonl[.gz]
+	Fields   []string      //csv的字段, 用.访问嵌套字段, 比如meta.feed.title; jsonl忽略
+	MaxSize  int64         //单个文件的大小上限(未压缩), 单位: 字节, 0表示不限制
+	Interval time.Duration //单个文件的时间跨度, 0表示不按时间切分
+	Gzip     bool          //是否gzip压缩
+	Fsync    bool          //切分和关闭时是否fsync
+}
+
+//文件输出实现类型
+type Sink struct {
+	mutex  sync.Mutex
+	opts   Options
+	file   *os.File
+	gz     *gzip.Writer
+	bw     *bufio.Writer
+	cw     *countWriter
+	csvw   *csv.Writer
+	path   string    //当前文件路径(不含.part)
+	opened time.Time //当前文件的打开时间
+	seq    int       //文件序号
+	items  uint64    //已写入的条目数
+	files  uint64    //已完成的文件数
+	closed bool
+}
+
+//统计写入的字节数
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+//New, 文件在第一次写入时才创建
+func New(opts Options) (*Sink, error) {
+	if opts.Format != FORMAT_JSONL && opts.Format != FORMAT_CSV {
+		return nil, errors.New("Unsupported sink format: " + opts.Format)
+	}
+	if opts.Format == FORMAT_CSV && len(opts.Fields) == 0 {
+		return nil, errors.New("The csv sink fields can not be empty!")
+	}
+	if opts.Dir == "" {
+		return nil, errors.New("The sink dir can not be empty!")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "items"
+	}
+	if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Sink{opts: opts}, nil
+}
+
+//写入一个条目, 条目原样返回, 处理链继续
+func (s *Sink) Process(item basic.Item) (basic.Item, error) {
+	if item == nil {
+		return nil, errors.New("Invalid item!")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return item, ErrClosed
+	}
+
+	//按大小和时间切分
+	if s.file != nil && ((s.opts.MaxSize > 0 && s.cw.n >= s.opts.MaxSize) ||
+		(s.opts.Interval > 0 && time.Since(s.opened) >= s.opts.Interval)) {
+		if err := s.closeFile(); err != nil {
+			return item, err
+		}
+	}
+	if s.file == nil {
+		if err := s.openFile(); err != nil {
+			return item, err
+		}
+	}
+
+	if err := s.write(item); err != nil {
+		return item, err
+	}
+	s.items++
+	return item, nil
+}
+
+func (s *Sink) write(item basic.Item) error {
+	if s.opts.Format == FORMAT_JSONL {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return errors.New(fmt.Sprintf("Marshal item error: %s. (url=%v)", err, item["url"]))
+		}
+		_, err = s.cw.Write(append(data, '\n'))
+		return err
+	}
+
+	record := make([]string, len(s.opts.Fields))
+	for i, f := range s.opts.Fields {
+		record[i] = csvValue(lookup(item, f))
+	}
+	if err := s.csvw.Write(record); err != nil {
+		return err
+	}
+	s.csvw.Flush()
+	return s.csvw.Error()
+}
+
+//按.访问嵌套字段, 不存在返回nil
+func lookup(item basic.Item, field string) interface{} {
+	var v interface{} = map[string]interface{}(item)
+	for _, key := range strings.Split(field, ".") {
+		switch m := v.(type) {
+		case map[string]interface{}:
+			v = m[key]
+		case basic.Item:
+			v = m[key]
+		case map[string]string:
+			v = m[key]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+//合并多个错误, 没有错误返回nil
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+//csv中的值: 字符串原样, nil为空, 其他类型按JSON编码
+func csvValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case json.Number:
+		return t.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+//打开新文件, csv先写表头
+func (s *Sink) openFile() error {
+	now := time.Now()
+	s.seq++
+	name := fmt.Sprintf("%s-%s-%d.%s", s.opts.Prefix, now.Format("20060102-150405"), s.seq, s.opts.Format)
+	if s.opts.Gzip {
+		name += ".gz"
+	}
+	path := filepath.Join(s.opts.Dir, name)
+	file, err := os.OpenFile(path+partSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.file = file
+	s.path = path
+	s.opened = now
+	var w io.Writer = file
+	if s.opts.Gzip {
+		s.gz = gzip.NewWriter(file)
+		w = s.gz
+	}
+	s.bw = bufio.NewWriter(w)
+	s.cw = &countWriter{w: s.bw}
+	if s.opts.Format == FORMAT_CSV {
+		s.csvw = csv.NewWriter(s.cw)
+		if err := s.csvw.Write(s.opts.Fields); err != nil {
+			return err
+		}
+		s.csvw.Flush()
+		return s.csvw.Error()
+	}
+	return nil
+}
+
+//关闭当前文件: 刷新缓冲, 结束gzip, fsync, 去掉.part
+func (s *Sink) closeFile() error {
+	if s.file == nil {
+		return nil
+	}
+	file := s.file
+	s.file = nil
+	err := s.bw.Flush()
+	if s.gz != nil {
+		if e := s.gz.Close(); err == nil {
+			err = e
+		}
+		s.gz = nil
+	}
+	if s.opts.Fsync {
+		if e := file.Sync(); err == nil {
+			err = e
+		}
+	}
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		return err
+	}
+	s.files++
+	return os.Rename(s.path+partSuffix, s.path)
+}
+
+//文件是同步写入的, 错误都由Process返回
+func (s *Sink) TakeErrors() []error {
+	return nil
+}
+
+//关闭, 之后的写入返回ErrClosed
+func (s *Sink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.closeFile()
+}
+
+//摘要
+func (s *Sink) Summary(prefix string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return fmt.Sprintf(prefix+"%s Dir: %s, Items: %d, Files: %d, Current: %s\n",
+		s.opts.Format, s.opts.Dir, s.items, s.files, filepath.Base(s.path))
+}
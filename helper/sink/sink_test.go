@@ -0,0 +1,137 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/hq-cml/spider-man/basic"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+//目录中已完成的文件, 按文件名排序
+func doneFiles(t *testing.T, dir string) []string {
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := []string{}
+	for _, f := range files {
+		if !strings.HasSuffix(f, partSuffix) {
+			result = append(result, f)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestJsonl(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sink")
+	defer os.RemoveAll(dir)
+
+	s, err := New(Options{Format: FORMAT_JSONL, Dir: dir, MaxSize: 100, Fsync: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		item := basic.Item{"url": "http://www.360.cn/n/" + strings.Repeat("x", 40), "depth": i}
+		if _, err := s.Process(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	//写入中的文件不可见
+	if files := doneFiles(t, dir); len(files) != 2 {
+		t.Fatal("Wrong files before close:", files)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Process(basic.Item{"url": "x"}); err != ErrClosed {
+		t.Fatal("Should be closed:", err)
+	}
+
+	//每个文件超过100字节后切分, 每行一个条目
+	files := doneFiles(t, dir)
+	if len(files) != 3 {
+		t.Fatal("Wrong files:", files)
+	}
+	lines := 0
+	for _, f := range files {
+		data, _ := ioutil.ReadFile(f)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			m := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(line), &m); err != nil {
+				t.Fatal(err)
+			}
+			lines++
+		}
+	}
+	if lines != 5 {
+		t.Fatal("Wrong lines:", lines)
+	}
+}
+
+func TestCsvGzip(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sink")
+	defer os.RemoveAll(dir)
+
+	s, err := New(Options{Format: FORMAT_CSV, Dir: dir, Prefix: "news", Fields: []string{"url", "meta.feed.title", "tags", "none"}, Gzip: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := basic.Item{
+		"url":  "http://www.360.cn/n/1.html",
+		"meta": map[string]interface{}{"feed": map[string]interface{}{"title": "标题, 含逗号"}},
+		"tags": []string{"a", "b"},
+	}
+	s.Process(item)
+	s.Close()
+
+	files := doneFiles(t, dir)
+	if len(files) != 1 || !strings.HasPrefix(filepath.Base(files[0]), "news-") || !strings.HasSuffix(files[0], ".csv.gz") {
+		t.Fatal("Wrong files:", files)
+	}
+	f, _ := os.Open(files[0])
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(bufio.NewReader(gz)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || strings.Join(records[0], "|") != "url|meta.feed.title|tags|none" ||
+		strings.Join(records[1], "|") != `http://www.360.cn/n/1.html|标题, 含逗号|["a","b"]|` {
+		t.Fatal("Wrong records:", records)
+	}
+}
+
+func TestInterval(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sink")
+	defer os.RemoveAll(dir)
+
+	s, _ := New(Options{Format: FORMAT_JSONL, Dir: dir, Interval: 50 * time.Millisecond})
+	s.Process(basic.Item{"url": "1"})
+	time.Sleep(100 * time.Millisecond)
+	s.Process(basic.Item{"url": "2"})
+	s.Close()
+	if files := doneFiles(t, dir); len(files) != 2 {
+		t.Fatal("Wrong files:", files)
+	}
+}
+
+func TestNewError(t *testing.T) {
+	if _, err := New(Options{Format: "xml", Dir: "/tmp"}); err == nil {
+		t.Fatal("Should be error")
+	}
+	if _, err := New(Options{Format: FORMAT_CSV, Dir: "/tmp"}); err == nil {
+		t.Fatal("Should be error")
+	}
+}
@@ -0,0 +1,679 @@
+package xpath
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+//表达式的值: nodeSet, string, float64, bool 之一
+type value interface{}
+
+//节点集合, 求值过程中总是保持文档顺序且不重复
+type nodeSet []Node
+
+//一次求值共享的状态
+type state struct {
+	root  *html.Node
+	order map[*html.Node]int //节点的文档顺序, 需要时才建立
+}
+
+//求值上下文
+type context struct {
+	node Node
+	pos  int //上下文位置, 从1开始
+	size int //上下文大小
+	st   *state
+}
+
+type expr interface {
+	eval(c *context) value
+}
+
+//节点测试类型
+const (
+	testName = iota
+	testNode
+	testText
+	testComment
+	testPI
+)
+
+var nodeTypes = map[string]int{
+	"node":                   testNode,
+	"text":                   testText,
+	"comment":                testComment,
+	"processing-instruction": testPI,
+}
+
+var axes = map[string]bool{
+	"ancestor":           true,
+	"ancestor-or-self":   true,
+	"attribute":          true,
+	"child":              true,
+	"descendant":         true,
+	"descendant-or-self": true,
+	"following":          true,
+	"following-sibling":  true,
+	"parent":             true,
+	"preceding":          true,
+	"preceding-sibling":  true,
+	"self":               true,
+}
+
+type nodeTest struct {
+	kind int
+	name string
+}
+
+//节点是否满足测试, 属性轴上名字测试匹配属性, 其他轴上匹配元素
+//HTML解析出来的标签和属性名都是小写, 所以名字比较不区分大小写
+func (t nodeTest) match(n Node, attrAxis bool) bool {
+	switch t.kind {
+	case testNode:
+		return true
+	case testText:
+		return !n.IsAttr() && n.Type == html.TextNode
+	case testComment:
+		return !n.IsAttr() && n.Type == html.CommentNode
+	case testPI:
+		return false
+	}
+	if attrAxis {
+		return n.IsAttr() && (t.name == "*" || strings.EqualFold(n.Name(), t.name))
+	}
+	if n.IsAttr() || n.Type != html.ElementNode {
+		return false
+	}
+	return t.name == "*" || strings.EqualFold(n.Data, t.name)
+}
+
+//定位步
+type step struct {
+	axis  string
+	test  nodeTest
+	preds []expr
+}
+
+//从节点n出发, 沿着轴选出满足测试和谓词的节点, 结果按照轴的方向排列
+func (s *step) apply(c *context, n Node) nodeSet {
+	attrAxis := s.axis == "attribute"
+	set := nodeSet{}
+	walkAxis(s.axis, n, func(m Node) {
+		if s.test.match(m, attrAxis) {
+			set = append(set, m)
+		}
+	})
+	for _, pred := range s.preds {
+		set = filter(c, set, pred)
+	}
+	return set
+}
+
+//按谓词过滤, 谓词的值是数字则和位置比较, 否则转换成布尔值
+func filter(c *context, set nodeSet, pred expr) nodeSet {
+	out := nodeSet{}
+	for i, n := range set {
+		v := pred.eval(&context{node: n, pos: i + 1, size: len(set), st: c.st})
+		if f, ok := v.(float64); ok {
+			if f == float64(i+1) {
+				out = append(out, n)
+			}
+		} else if toBool(v) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+//沿轴遍历, 反向轴(ancestor, preceding等)按离n由近到远的顺序
+func walkAxis(axis string, n Node, fn func(Node)) {
+	switch axis {
+	case "self":
+		fn(n)
+	case "attribute":
+		if !n.IsAttr() && n.Type == html.ElementNode {
+			for i := range n.Attr {
+				fn(Node{n.Node, i})
+			}
+		}
+	case "child":
+		if !n.IsAttr() {
+			for m := n.FirstChild; m != nil; m = m.NextSibling {
+				fn(Node{m, -1})
+			}
+		}
+	case "descendant":
+		if !n.IsAttr() {
+			walkDescendants(n.Node, fn)
+		}
+	case "descendant-or-self":
+		fn(n)
+		if !n.IsAttr() {
+			walkDescendants(n.Node, fn)
+		}
+	case "parent":
+		if n.IsAttr() {
+			fn(Node{n.Node, -1})
+		} else if n.Parent != nil {
+			fn(Node{n.Parent, -1})
+		}
+	case "ancestor", "ancestor-or-self":
+		if axis == "ancestor-or-self" {
+			fn(n)
+		}
+		m := n.Parent
+		if n.IsAttr() {
+			m = n.Node
+		}
+		for ; m != nil; m = m.Parent {
+			fn(Node{m, -1})
+		}
+	case "following-sibling":
+		if !n.IsAttr() {
+			for m := n.NextSibling; m != nil; m = m.NextSibling {
+				fn(Node{m, -1})
+			}
+		}
+	case "preceding-sibling":
+		if !n.IsAttr() {
+			for m := n.PrevSibling; m != nil; m = m.PrevSibling {
+				fn(Node{m, -1})
+			}
+		}
+	case "following":
+		//属性节点之后的节点包括其所属元素的后代
+		if n.IsAttr() {
+			walkDescendants(n.Node, fn)
+		}
+		for m := n.Node; m != nil; m = m.Parent {
+			for s := m.NextSibling; s != nil; s = s.NextSibling {
+				fn(Node{s, -1})
+				walkDescendants(s, fn)
+			}
+		}
+	case "preceding":
+		//不包括祖先, 属性节点所属的元素也是祖先
+		for m := n.Node; m != nil; m = m.Parent {
+			for s := m.PrevSibling; s != nil; s = s.PrevSibling {
+				walkReverse(s, fn)
+			}
+		}
+	}
+}
+
+//按文档顺序遍历n的全部后代
+func walkDescendants(n *html.Node, fn func(Node)) {
+	for m := n.FirstChild; m != nil; m = m.NextSibling {
+		fn(Node{m, -1})
+		walkDescendants(m, fn)
+	}
+}
+
+//按文档逆序遍历n及其后代
+func walkReverse(n *html.Node, fn func(Node)) {
+	for m := n.LastChild; m != nil; m = m.PrevSibling {
+		walkReverse(m, fn)
+	}
+	fn(Node{n, -1})
+}
+
+//按文档顺序排序并去重
+func (st *state) sortUnique(set nodeSet) nodeSet {
+	if len(set) <= 1 {
+		return set
+	}
+	if st.order == nil {
+		st.order = map[*html.Node]int{st.root: 0}
+		walkDescendants(st.root, func(n Node) {
+			st.order[n.Node] = len(st.order)
+		})
+	}
+
+	seen := make(map[Node]bool, len(set))
+	out := make(nodeSet, 0, len(set))
+	for _, n := range set {
+		if !seen[n] {
+			seen[n] = true
+			out = append(out, n)
+		}
+	}
+	//属性在所属元素之后, 在元素的子节点之前
+	sort.SliceStable(out, func(i, j int) bool {
+		oi, oj := st.order[out[i].Node], st.order[out[j].Node]
+		if oi != oj {
+			return oi < oj
+		}
+		return out[i].attr < out[j].attr
+	})
+	return out
+}
+
+//路径表达式
+type pathExpr struct {
+	abs   bool //绝对路径, 从根节点开始
+	start expr //过滤表达式开头的路径, 比如(//a)[1]/@href
+	steps []*step
+}
+
+func (e *pathExpr) eval(c *context) value {
+	var set nodeSet
+	switch {
+	case e.start != nil:
+		set, _ = e.start.eval(c).(nodeSet)
+	case e.abs:
+		set = nodeSet{{c.st.root, -1}}
+	default:
+		set = nodeSet{c.node}
+	}
+	for _, s := range e.steps {
+		next := nodeSet{}
+		for _, n := range set {
+			next = append(next, s.apply(c, n)...)
+		}
+		set = c.st.sortUnique(next)
+	}
+	return set
+}
+
+//过滤表达式
+type filterExpr struct {
+	primary expr
+	preds   []expr
+}
+
+func (e *filterExpr) eval(c *context) value {
+	set, ok := e.primary.eval(c).(nodeSet)
+	if !ok {
+		return nodeSet{}
+	}
+	for _, pred := range e.preds {
+		set = filter(c, set, pred)
+	}
+	return set
+}
+
+//并集, 非节点集合的操作数当作空集
+type unionExpr struct {
+	left, right expr
+}
+
+func (e *unionExpr) eval(c *context) value {
+	l, _ := e.left.eval(c).(nodeSet)
+	r, _ := e.right.eval(c).(nodeSet)
+	set := make(nodeSet, 0, len(l)+len(r))
+	return c.st.sortUnique(append(append(set, l...), r...))
+}
+
+type literalExpr string
+
+func (e literalExpr) eval(c *context) value {
+	return string(e)
+}
+
+type numberExpr float64
+
+func (e numberExpr) eval(c *context) value {
+	return float64(e)
+}
+
+type negExpr struct {
+	e expr
+}
+
+func (e *negExpr) eval(c *context) value {
+	return -toNumber(e.e.eval(c))
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *binaryExpr) eval(c *context) value {
+	switch e.op {
+	case "or":
+		return toBool(e.left.eval(c)) || toBool(e.right.eval(c))
+	case "and":
+		return toBool(e.left.eval(c)) && toBool(e.right.eval(c))
+	case "=", "!=", "<", "<=", ">", ">=":
+		return compare(e.op, e.left.eval(c), e.right.eval(c))
+	}
+
+	a, b := toNumber(e.left.eval(c)), toNumber(e.right.eval(c))
+	switch e.op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "div":
+		return a / b
+	default: //mod
+		return math.Mod(a, b)
+	}
+}
+
+//比较, 节点集合和其他值比较时, 只要有一个节点满足即为真
+func compare(op string, l, r value) bool {
+	ls, lIsSet := l.(nodeSet)
+	rs, rIsSet := r.(nodeSet)
+	switch {
+	case lIsSet && rIsSet:
+		for _, a := range ls {
+			for _, b := range rs {
+				if compareAtom(op, a.Value(), b.Value()) {
+					return true
+				}
+			}
+		}
+		return false
+	case lIsSet:
+		if _, ok := r.(bool); ok {
+			return compareAtom(op, toBool(l), r)
+		}
+		for _, a := range ls {
+			if compareAtom(op, atomLike(a.Value(), r), r) {
+				return true
+			}
+		}
+		return false
+	case rIsSet:
+		if _, ok := l.(bool); ok {
+			return compareAtom(op, l, toBool(r))
+		}
+		for _, b := range rs {
+			if compareAtom(op, l, atomLike(b.Value(), l)) {
+				return true
+			}
+		}
+		return false
+	}
+	return compareAtom(op, l, r)
+}
+
+//节点的字符串值和数字比较时, 先转换成数字
+func atomLike(s string, other value) value {
+	if _, ok := other.(float64); ok {
+		return toNumber(s)
+	}
+	return s
+}
+
+func compareAtom(op string, a, b value) bool {
+	if op == "=" || op == "!=" {
+		var eq bool
+		_, aBool := a.(bool)
+		_, bBool := b.(bool)
+		_, aNum := a.(float64)
+		_, bNum := b.(float64)
+		switch {
+		case aBool || bBool:
+			eq = toBool(a) == toBool(b)
+		case aNum || bNum:
+			eq = toNumber(a) == toNumber(b)
+		default:
+			eq = toString(a) == toString(b)
+		}
+		return eq == (op == "=")
+	}
+
+	x, y := toNumber(a), toNumber(b)
+	switch op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	default:
+		return x >= y
+	}
+}
+
+func toBool(v value) bool {
+	switch v := v.(type) {
+	case nodeSet:
+		return len(v) > 0
+	case string:
+		return v != ""
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case bool:
+		return v
+	}
+	return false
+}
+
+func toNumber(v value) float64 {
+	switch v := v.(type) {
+	case nodeSet:
+		return toNumber(toString(v))
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	}
+	return 0
+}
+
+func toString(v value) string {
+	switch v := v.(type) {
+	case nodeSet:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].Value()
+	case string:
+		return v
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
+
+//函数调用
+type funcExpr struct {
+	name string
+	fn   *function
+	args []expr
+}
+
+func (e *funcExpr) eval(c *context) value {
+	return e.fn.call(c, e.args)
+}
+
+//内置函数, maxArgs为-1表示不限制参数个数
+type function struct {
+	minArgs, maxArgs int
+	call             func(c *context, args []expr) value
+}
+
+//无参数时取上下文节点的字符串值
+func argString(c *context, args []expr, i int) string {
+	if i >= len(args) {
+		return c.node.Value()
+	}
+	return toString(args[i].eval(c))
+}
+
+//无参数时取上下文节点
+func argNode(c *context, args []expr) (Node, bool) {
+	if len(args) == 0 {
+		return c.node, true
+	}
+	set, ok := args[0].eval(c).(nodeSet)
+	if !ok || len(set) == 0 {
+		return Node{}, false
+	}
+	return set[0], true
+}
+
+func stringFunc(fn func(a, b string) value) *function {
+	return &function{2, 2, func(c *context, args []expr) value {
+		return fn(argString(c, args, 0), argString(c, args, 1))
+	}}
+}
+
+func numberFunc(fn func(float64) float64) *function {
+	return &function{1, 1, func(c *context, args []expr) value {
+		return fn(toNumber(args[0].eval(c)))
+	}}
+}
+
+func nameFunc(c *context, args []expr) value {
+	if n, ok := argNode(c, args); ok {
+		return n.Name()
+	}
+	return ""
+}
+
+var functions map[string]*function
+
+func init() {
+	functions = map[string]*function{
+		"last": {0, 0, func(c *context, args []expr) value {
+			return float64(c.size)
+		}},
+		"position": {0, 0, func(c *context, args []expr) value {
+			return float64(c.pos)
+		}},
+		"count": {1, 1, func(c *context, args []expr) value {
+			set, _ := args[0].eval(c).(nodeSet)
+			return float64(len(set))
+		}},
+		"sum": {1, 1, func(c *context, args []expr) value {
+			set, _ := args[0].eval(c).(nodeSet)
+			sum := 0.0
+			for _, n := range set {
+				sum += toNumber(n.Value())
+			}
+			return sum
+		}},
+		"name":       {0, 1, nameFunc},
+		"local-name": {0, 1, nameFunc},
+		"string": {0, 1, func(c *context, args []expr) value {
+			return argString(c, args, 0)
+		}},
+		"concat": {2, -1, func(c *context, args []expr) value {
+			var b strings.Builder
+			for i := range args {
+				b.WriteString(argString(c, args, i))
+			}
+			return b.String()
+		}},
+		"contains": stringFunc(func(a, b string) value {
+			return strings.Contains(a, b)
+		}),
+		"starts-with": stringFunc(func(a, b string) value {
+			return strings.HasPrefix(a, b)
+		}),
+		"ends-with": stringFunc(func(a, b string) value {
+			return strings.HasSuffix(a, b)
+		}),
+		"substring-before": stringFunc(func(a, b string) value {
+			if i := strings.Index(a, b); i >= 0 {
+				return a[:i]
+			}
+			return ""
+		}),
+		"substring-after": stringFunc(func(a, b string) value {
+			if i := strings.Index(a, b); i >= 0 {
+				return a[i+len(b):]
+			}
+			return ""
+		}),
+		"substring": {2, 3, func(c *context, args []expr) value {
+			runes := []rune(argString(c, args, 0))
+			start := xpathRound(toNumber(args[1].eval(c)))
+			end := math.Inf(1)
+			if len(args) == 3 {
+				end = start + xpathRound(toNumber(args[2].eval(c)))
+			}
+			var b strings.Builder
+			for i, r := range runes {
+				if p := float64(i + 1); p >= start && p < end {
+					b.WriteRune(r)
+				}
+			}
+			return b.String()
+		}},
+		"string-length": {0, 1, func(c *context, args []expr) value {
+			return float64(len([]rune(argString(c, args, 0))))
+		}},
+		"normalize-space": {0, 1, func(c *context, args []expr) value {
+			return strings.Join(strings.Fields(argString(c, args, 0)), " ")
+		}},
+		"translate": {3, 3, func(c *context, args []expr) value {
+			from, to := []rune(argString(c, args, 1)), []rune(argString(c, args, 2))
+			return strings.Map(func(r rune) rune {
+				for i, f := range from {
+					if f == r {
+						if i < len(to) {
+							return to[i]
+						}
+						return -1
+					}
+				}
+				return r
+			}, argString(c, args, 0))
+		}},
+		"lower-case": {1, 1, func(c *context, args []expr) value {
+			return strings.ToLower(argString(c, args, 0))
+		}},
+		"upper-case": {1, 1, func(c *context, args []expr) value {
+			return strings.ToUpper(argString(c, args, 0))
+		}},
+		"not": {1, 1, func(c *context, args []expr) value {
+			return !toBool(args[0].eval(c))
+		}},
+		"true": {0, 0, func(c *context, args []expr) value {
+			return true
+		}},
+		"false": {0, 0, func(c *context, args []expr) value {
+			return false
+		}},
+		"boolean": {1, 1, func(c *context, args []expr) value {
+			return toBool(args[0].eval(c))
+		}},
+		"number": {0, 1, func(c *context, args []expr) value {
+			if len(args) == 0 {
+				return toNumber(c.node.Value())
+			}
+			return toNumber(args[0].eval(c))
+		}},
+		"floor":   numberFunc(math.Floor),
+		"ceiling": numberFunc(math.Ceil),
+		"round":   numberFunc(xpathRound),
+	}
+}
+
+//XPath的round: 0.5向正无穷方向舍入
+func xpathRound(f float64) float64 {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return f
+	}
+	return math.Floor(f + 0.5)
+}
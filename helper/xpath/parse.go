@@ -0,0 +1,429 @@
+package xpath
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//词法单元类型
+type tokenKind int
+
+const (
+	tokEOF    tokenKind = iota
+	tokName             //名字, 也包括名字测试*
+	tokString           //字符串字面量
+	tokNumber           //数字字面量
+	tokOp               //运算符和标点
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+	pos  int
+}
+
+//解析错误, 解析过程中以panic抛出, 在Compile中recover
+type syntaxError struct {
+	msg string
+}
+
+func (e *syntaxError) Error() string {
+	return e.msg
+}
+
+//运算符和标点, 长的在前, 保证最长匹配
+var punctuations = []string{"//", "::", "..", "!=", "<=", ">=",
+	"/", "(", ")", "[", "]", ".", "@", ",", "|", "+", "-", "=", "<", ">", "*"}
+
+//词法分析
+func lex(src string) ([]token, error) {
+	toks := []token{}
+	for i := 0; i < len(src); {
+		c := src[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '"' || c == '\'':
+			j := strings.IndexByte(src[i+1:], c)
+			if j < 0 {
+				return nil, errors.New(fmt.Sprintf("xpath: unterminated string at %d in %q", i, src))
+			}
+			toks = append(toks, token{tokString, src[i+1 : i+1+j], start})
+			i += j + 2
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			toks = append(toks, token{tokNumber, src[start:i], start})
+		case isNameStart(c):
+			for i < len(src) && isNameChar(src[i]) {
+				i++
+			}
+			toks = append(toks, token{tokName, src[start:i], start})
+		default:
+			op := ""
+			for _, p := range punctuations {
+				if strings.HasPrefix(src[i:], p) {
+					op = p
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.New(fmt.Sprintf("xpath: unexpected %q at %d in %q", c, i, src))
+			}
+			toks = append(toks, token{tokOp, op, start})
+			i += len(op)
+		}
+	}
+
+	//消除歧义: 前面是操作数时, *是乘号, and/or/div/mod是运算符; 否则*是名字测试, and等是普通名字
+	for k := range toks {
+		afterOperand := k > 0 && isOperand(toks[k-1])
+		switch {
+		case toks[k].kind == tokOp && toks[k].val == "*" && !afterOperand:
+			toks[k].kind = tokName
+		case toks[k].kind == tokName && afterOperand:
+			switch toks[k].val {
+			case "and", "or", "div", "mod":
+				toks[k].kind = tokOp
+			}
+		}
+	}
+	return append(toks, token{tokEOF, "", len(src)}), nil
+}
+
+func isOperand(t token) bool {
+	if t.kind != tokOp {
+		return true
+	}
+	switch t.val {
+	case ")", "]", ".", "..":
+		return true
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isNameChar(c byte) bool {
+	return isNameStart(c) || isDigit(c) || c == '-' || c == '.'
+}
+
+//递归下降的语法分析器
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) peekAt(k int) token {
+	if p.pos+k >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+k]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(v string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.val == v
+}
+
+func (p *parser) expect(v string) {
+	if !p.isOp(v) {
+		p.fail("expect " + v)
+	}
+	p.next()
+}
+
+func (p *parser) fail(msg string) {
+	t := p.peek()
+	if t.kind == tokEOF {
+		msg += ", got end of expression"
+	} else {
+		msg += ", got " + strconv.Quote(t.val)
+	}
+	panic(&syntaxError{fmt.Sprintf("xpath: %s at %d in %q", msg, t.pos, p.src)})
+}
+
+//Expr := OrExpr
+func (p *parser) parseExpr() expr {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() expr {
+	left := p.parseAnd()
+	for p.isOp("or") {
+		p.next()
+		left = &binaryExpr{op: "or", left: left, right: p.parseAnd()}
+	}
+	return left
+}
+
+func (p *parser) parseAnd() expr {
+	left := p.parseEquality()
+	for p.isOp("and") {
+		p.next()
+		left = &binaryExpr{op: "and", left: left, right: p.parseEquality()}
+	}
+	return left
+}
+
+func (p *parser) parseEquality() expr {
+	left := p.parseRelational()
+	for p.isOp("=") || p.isOp("!=") {
+		op := p.next().val
+		left = &binaryExpr{op: op, left: left, right: p.parseRelational()}
+	}
+	return left
+}
+
+func (p *parser) parseRelational() expr {
+	left := p.parseAdditive()
+	for p.isOp("<") || p.isOp("<=") || p.isOp(">") || p.isOp(">=") {
+		op := p.next().val
+		left = &binaryExpr{op: op, left: left, right: p.parseAdditive()}
+	}
+	return left
+}
+
+func (p *parser) parseAdditive() expr {
+	left := p.parseMultiplicative()
+	for p.isOp("+") || p.isOp("-") {
+		op := p.next().val
+		left = &binaryExpr{op: op, left: left, right: p.parseMultiplicative()}
+	}
+	return left
+}
+
+func (p *parser) parseMultiplicative() expr {
+	left := p.parseUnary()
+	for p.isOp("*") || p.isOp("div") || p.isOp("mod") {
+		op := p.next().val
+		left = &binaryExpr{op: op, left: left, right: p.parseUnary()}
+	}
+	return left
+}
+
+func (p *parser) parseUnary() expr {
+	if p.isOp("-") {
+		p.next()
+		return &negExpr{e: p.parseUnary()}
+	}
+	return p.parseUnion()
+}
+
+func (p *parser) parseUnion() expr {
+	left := p.parsePath()
+	for p.isOp("|") {
+		p.next()
+		left = &unionExpr{left: left, right: p.parsePath()}
+	}
+	return left
+}
+
+//PathExpr := LocationPath | FilterExpr (('/' | '//') RelativeLocationPath)?
+func (p *parser) parsePath() expr {
+	if p.startsFilter() {
+		f := p.parseFilter()
+		if !p.isOp("/") && !p.isOp("//") {
+			return f
+		}
+		pe := &pathExpr{start: f}
+		p.parseMoreSteps(pe)
+		return pe
+	}
+
+	pe := &pathExpr{}
+	switch {
+	case p.isOp("/"):
+		p.next()
+		pe.abs = true
+		if p.startsStep() {
+			p.parseSteps(pe)
+		}
+	case p.isOp("//"):
+		p.next()
+		pe.abs = true
+		pe.steps = append(pe.steps, descendantOrSelf())
+		p.parseSteps(pe)
+	default:
+		p.parseSteps(pe)
+	}
+	return pe
+}
+
+//是否是过滤表达式的开头: 括号, 字面量, 函数调用
+func (p *parser) startsFilter() bool {
+	t := p.peek()
+	switch t.kind {
+	case tokString, tokNumber:
+		return true
+	case tokOp:
+		return t.val == "("
+	case tokName:
+		if n := p.peekAt(1); n.kind == tokOp && n.val == "(" {
+			_, isNodeType := nodeTypes[t.val]
+			return !isNodeType
+		}
+	}
+	return false
+}
+
+func (p *parser) startsStep() bool {
+	t := p.peek()
+	return t.kind == tokName || (t.kind == tokOp && (t.val == "." || t.val == ".." || t.val == "@"))
+}
+
+func (p *parser) parseSteps(pe *pathExpr) {
+	pe.steps = append(pe.steps, p.parseStep())
+	p.parseMoreSteps(pe)
+}
+
+func (p *parser) parseMoreSteps(pe *pathExpr) {
+	for {
+		switch {
+		case p.isOp("/"):
+			p.next()
+		case p.isOp("//"):
+			p.next()
+			pe.steps = append(pe.steps, descendantOrSelf())
+		default:
+			return
+		}
+		pe.steps = append(pe.steps, p.parseStep())
+	}
+}
+
+//Step := AxisSpecifier NodeTest Predicate* | '.' | '..'
+func (p *parser) parseStep() *step {
+	if p.isOp(".") {
+		p.next()
+		return &step{axis: "self", test: nodeTest{kind: testNode}}
+	}
+	if p.isOp("..") {
+		p.next()
+		return &step{axis: "parent", test: nodeTest{kind: testNode}}
+	}
+
+	s := &step{axis: "child"}
+	if p.isOp("@") {
+		p.next()
+		s.axis = "attribute"
+	} else if t, n := p.peek(), p.peekAt(1); t.kind == tokName && n.kind == tokOp && n.val == "::" {
+		if !axes[t.val] {
+			p.fail("unknown axis")
+		}
+		s.axis = t.val
+		p.next()
+		p.next()
+	}
+	s.test = p.parseNodeTest()
+	s.preds = p.parsePredicates()
+	return s
+}
+
+func (p *parser) parseNodeTest() nodeTest {
+	t := p.peek()
+	if t.kind != tokName {
+		p.fail("expect node test")
+	}
+	p.next()
+	if kind, ok := nodeTypes[t.val]; ok && p.isOp("(") {
+		p.next()
+		//processing-instruction('name')的参数忽略
+		if kind == testPI && p.peek().kind == tokString {
+			p.next()
+		}
+		p.expect(")")
+		return nodeTest{kind: kind}
+	}
+	return nodeTest{kind: testName, name: t.val}
+}
+
+func (p *parser) parsePredicates() []expr {
+	var preds []expr
+	for p.isOp("[") {
+		p.next()
+		preds = append(preds, p.parseExpr())
+		p.expect("]")
+	}
+	return preds
+}
+
+//FilterExpr := PrimaryExpr Predicate*
+func (p *parser) parseFilter() expr {
+	primary := p.parsePrimary()
+	preds := p.parsePredicates()
+	if len(preds) == 0 {
+		return primary
+	}
+	return &filterExpr{primary: primary, preds: preds}
+}
+
+//PrimaryExpr := '(' Expr ')' | Literal | Number | FunctionCall
+func (p *parser) parsePrimary() expr {
+	t := p.peek()
+	switch t.kind {
+	case tokString:
+		p.next()
+		return literalExpr(t.val)
+	case tokNumber:
+		p.next()
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			p.fail("bad number")
+		}
+		return numberExpr(f)
+	case tokOp:
+		p.expect("(")
+		e := p.parseExpr()
+		p.expect(")")
+		return e
+	}
+
+	fn, ok := functions[t.val]
+	if !ok {
+		p.fail("unknown function")
+	}
+	p.next()
+	p.expect("(")
+	var args []expr
+	if !p.isOp(")") {
+		args = append(args, p.parseExpr())
+		for p.isOp(",") {
+			p.next()
+			args = append(args, p.parseExpr())
+		}
+	}
+	p.expect(")")
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		panic(&syntaxError{fmt.Sprintf("xpath: wrong number of arguments for %s() at %d in %q", t.val, t.pos, p.src)})
+	}
+	return &funcExpr{name: t.val, fn: fn, args: args}
+}
+
+//'//'的完整形式
+func descendantOrSelf() *step {
+	return &step{axis: "descendant-or-self", test: nodeTest{kind: testNode}}
+}
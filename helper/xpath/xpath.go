@@ -0,0 +1,195 @@
+package xpath
+
+/*
+ * 基于golang.org/x/net/html的XPath 1.0求值
+ * goquery只支持CSS选择器, 有些页面需要XPath的轴(ancestor, following-sibling等), text()谓词, 按位置选取等能力
+ * 分析函数可以和goquery配合使用: goquery.Document.Nodes[0]就是根节点
+ *
+ * 支持的语法:
+ * 1. 全部13个轴中除namespace之外的12个, 以及简写: //, ., .., @, *
+ * 2. 节点测试: 名字, *, node(), text(), comment()
+ * 3. 谓词, 运算符(or and = != < <= > >= + - * div mod |)
+ * 4. XPath 1.0核心函数库中的字符串、数字、布尔和节点集合函数, 外加ends-with, lower-case, upper-case
+ * 不支持变量和命名空间
+ */
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+//节点, 除了html.Node本身, 还可以是某个元素的属性
+type Node struct {
+	*html.Node     //属性节点则是其所属的元素
+	attr       int //属性下标, -1表示不是属性节点
+}
+
+//是否是属性节点
+func (n Node) IsAttr() bool {
+	return n.attr >= 0
+}
+
+//元素名或者属性名, 其他节点返回空串
+func (n Node) Name() string {
+	if n.IsAttr() {
+		return n.Attr[n.attr].Key
+	}
+	if n.Node != nil && n.Type == html.ElementNode {
+		return n.Data
+	}
+	return ""
+}
+
+//节点的字符串值: 属性值, 文本, 或者全部后代文本的拼接
+func (n Node) Value() string {
+	if n.IsAttr() {
+		return n.Attr[n.attr].Val
+	}
+	if n.Node == nil {
+		return ""
+	}
+	switch n.Type {
+	case html.TextNode, html.CommentNode:
+		return n.Data
+	case html.ElementNode, html.DocumentNode:
+		return InnerText(n.Node)
+	}
+	return ""
+}
+
+//编译好的XPath表达式, 可以并发使用
+type Expr struct {
+	src string
+	e   expr
+}
+
+//编译
+func Compile(src string) (exp *Expr, err error) {
+	if strings.TrimSpace(src) == "" {
+		return nil, errors.New("xpath: empty expression")
+	}
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			se, ok := p.(*syntaxError)
+			if !ok {
+				panic(p)
+			}
+			exp, err = nil, se
+		}
+	}()
+	p := &parser{src: src, toks: toks}
+	e := p.parseExpr()
+	if p.peek().kind != tokEOF {
+		p.fail("unexpected token")
+	}
+	return &Expr{src: src, e: e}, nil
+}
+
+//编译, 出错则panic, 用于表达式是常量的场景
+func MustCompile(src string) *Expr {
+	e, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+//以n为上下文节点求值, 结果是[]Node, string, float64, bool之一
+//绝对路径从n所在树的根节点开始
+func (e *Expr) Evaluate(n *html.Node) interface{} {
+	if n == nil {
+		return nil
+	}
+	root := n
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	c := &context{node: Node{n, -1}, pos: 1, size: 1, st: &state{root: root}}
+	v := e.e.eval(c)
+	if set, ok := v.(nodeSet); ok {
+		return []Node(set)
+	}
+	return v
+}
+
+//选取节点, 按文档顺序排列, 表达式的结果不是节点集合则返回nil
+func (e *Expr) Select(n *html.Node) []Node {
+	nodes, _ := e.Evaluate(n).([]Node)
+	return nodes
+}
+
+//字符串结果: 节点集合返回每个节点的字符串值, 其他类型的结果返回单个值
+func (e *Expr) Values(n *html.Node) []string {
+	switch v := e.Evaluate(n).(type) {
+	case nil:
+		return nil
+	case []Node:
+		values := make([]string, 0, len(v))
+		for _, node := range v {
+			values = append(values, node.Value())
+		}
+		return values
+	default:
+		return []string{toString(v)}
+	}
+}
+
+//选取全部非属性节点
+func Find(top *html.Node, src string) ([]*html.Node, error) {
+	e, err := Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	nodes := []*html.Node{}
+	for _, n := range e.Select(top) {
+		if !n.IsAttr() {
+			nodes = append(nodes, n.Node)
+		}
+	}
+	return nodes, nil
+}
+
+//选取第一个非属性节点, 没有则返回nil
+func FindOne(top *html.Node, src string) (*html.Node, error) {
+	nodes, err := Find(top, src)
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+//求值并返回字符串结果, 比如"//a/@href", "normalize-space(//h1)"
+func Values(top *html.Node, src string) ([]string, error) {
+	e, err := Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return e.Values(top), nil
+}
+
+//n的全部后代文本拼接
+func InnerText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
@@ -0,0 +1,110 @@
+package xpath
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+const testPage = `<html><head><title>Test</title></head><body>
+<div id="main" class="article">
+  <h1> Hello   World </h1>
+  <table>
+    <tr><th>Author</th><td>Alice</td></tr>
+    <tr><th>Date</th><td>2020-01-02</td></tr>
+  </table>
+  <ul><li>a</li><li>b</li><li class="last">c</li></ul>
+  <p>Price: <span>10</span></p><p>Tax: <span>2.5</span></p>
+  <a href="/n/1.html">one</a><a href="/n/2.html" rel="nofollow">two</a>
+  <!-- note -->
+</div>
+<div id="footer">foot</div>
+</body></html>`
+
+func parse(t *testing.T) *html.Node {
+	doc, err := html.Parse(strings.NewReader(testPage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestValues(t *testing.T) {
+	doc := parse(t)
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"//title", "Test"},
+		{"normalize-space(//h1)", "Hello World"},
+		{"//th[text()='Date']/following-sibling::td", "2020-01-02"},
+		{"//td[.='Alice']/preceding-sibling::th", "Author"},
+		{"//li[2]", "b"},
+		{"//td[2]", ""},
+		{"//li[last()]", "c"},
+		{"//li[position()>1]", "b,c"},
+		{"//ul/li[@class]", "c"},
+		{"(//li)[1]", "a"},
+		{"//a/@href", "/n/1.html,/n/2.html"},
+		{"//a[not(@rel='nofollow')]/@href", "/n/1.html"},
+		{"//a[contains(@href, '2')]", "two"},
+		{"//span[.>5]", "10"},
+		{"sum(//span)", "12.5"},
+		{"count(//li) * 2 - 1", "5"},
+		{"//li[1] | //li[3]", "a,c"},
+		{"//span[ancestor::div[@id='main']][1]/..", "Price: 10,Tax: 2.5"},
+		{"//span/ancestor::*[@id][1]/@id", "main"},
+		{"//h1/following::td[1]", "Alice"},
+		{"//div[@id='footer']/preceding::li[1]", "c"},
+		{"//comment()", "note"},
+		{"name(//*[@id='footer'])", "div"},
+		{"substring-after((//td)[2], '-')", "01-02"},
+		{"translate('abc', 'ab', 'A')", "Ac"},
+		{"//li[. = 'a' or . = 'c']", "a,c"},
+		{"//DIV[@ID='footer']", "foot"},
+		{"3 mod 2 = 1 and 6 div 3 = 2", "true"},
+	}
+	for _, c := range cases {
+		values, err := Values(doc, c.expr)
+		if err != nil {
+			t.Errorf("%s: %s", c.expr, err)
+			continue
+		}
+		for i := range values {
+			values[i] = strings.TrimSpace(values[i])
+		}
+		if got := strings.Join(values, ","); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestFindRelative(t *testing.T) {
+	doc := parse(t)
+	table, err := FindOne(doc, "//table")
+	if err != nil || table == nil {
+		t.Fatal("table not found", err)
+	}
+	//相对路径从上下文节点出发, 绝对路径仍从根节点出发
+	tds, _ := Find(table, ".//td")
+	if len(tds) != 2 {
+		t.Fatalf("Expect 2 td, got %d", len(tds))
+	}
+	titles, _ := Find(table, "/html/head/title")
+	if len(titles) != 1 {
+		t.Fatalf("Expect 1 title, got %d", len(titles))
+	}
+	//属性节点不在Find结果中
+	if nodes, _ := Find(doc, "//a/@href"); len(nodes) != 0 {
+		t.Fatalf("Expect no node, got %d", len(nodes))
+	}
+}
+
+func TestCompileError(t *testing.T) {
+	for _, src := range []string{"", "//a[", "//a[@href=']", "foo()", "//a/bad::b", "count()", "//a)"} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("%q: expect error", src)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package analyzer
 
 import (
 	"errors"
+	"fmt"
 	"github.com/hq-cml/spider-man/basic"
 	"github.com/hq-cml/spider-man/helper/idgen"
 	"github.com/hq-cml/spider-man/helper/log"
+	"runtime/debug"
+	"time"
 )
 
 /***********************************分析器**********************************/
@@ -33,14 +36,19 @@ func NewAnalyzer() *Analyzer {
 }
 
 //AnalyzeResponseFunc是一个分析器的链，每个response都会被链上的每一个分析器分析
+//具体由哪些分析函数分析, 由router决定: 请求指定的分析函数名 > 匹配的分析规则 > 默认分析链
+//响应按指针传递, 转码和解析的结果缓存在调用方的响应上, 分析之前或之后的其他使用者(比如robots)共享
 //返回值请求、条目、error的slice
 func (analyzer *Analyzer) Analyze(
-	respAnalyzeFuncs []basic.AnalyzeResponseFunc,
-	resp basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+	router *Router,
+	resp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
 	//参数校验
-	if respAnalyzeFuncs == nil {
-		return nil, nil,[]error{errors.New("The response parser list is invalid!")}
+	if router == nil {
+		return nil, nil,[]error{errors.New("The response router is invalid!")}
 	}
+	rt := router.route(resp)
+	respAnalyzeFuncs := rt.funcs
+	errorList := rt.errs
 
 	//获取到实际的响应内容，并做校验
 	//httpResp := resp.HttpResp()
@@ -49,30 +57,98 @@ func (analyzer *Analyzer) Analyze(
 	//}
 
 	//日志
-	log.Infof("Analyze the response (reqUrl=%s)... Depth: (%d).  Body: (%d) \n",
-		resp.ReqUrl, resp.Depth, len(resp.Body))
+	ruleName := ""
+	if rt.rule != nil {
+		ruleName = rt.rule.name
+	}
+	log.Infof("Analyze the response (reqUrl=%s)... Depth: (%d).  Body: (%d). Callback: (%s). Rule: (%s) \n",
+		resp.ReqUrl, resp.Depth, len(resp.Body), resp.Callback, ruleName)
 
 	//respDepth := resp.Depth()
 
 	//解析http响应，respAnalyzers，利用每一个分析函数进行分析
+	//每个分析函数单独兜底和计时, 一个分析函数panic或超时不影响其他分析函数的产出
 	itemList := []*basic.Item{}
 	requestList := []*basic.Request{}
-	errorList := []error{}
-	for _, analyzeFunc := range respAnalyzeFuncs {
+	current := resp
+	for _, af := range respAnalyzeFuncs {
 		//分析
-		iList, rList, errList := analyzeFunc(&resp)
+		res := runAnalyzeFunc(af, current, router.timeout)
+		router.stats.record(af.name, res)
+
+		//超时的分析函数仍在运行, 后面的分析函数改用不带缓存的副本
+		if res.timedOut {
+			current = current.Detach()
+		}
 
 		//分别装载分析产出的Item，Request，Error
-		if iList != nil && len(iList) > 0 {
-			itemList = append(itemList, iList...)
+		if len(res.items) > 0 {
+			itemList = append(itemList, res.items...)
 		}
-		if rList != nil && len(rList) > 0 {
-			requestList = append(requestList, rList...)
+		if len(res.reqs) > 0 {
+			requestList = append(requestList, res.reqs...)
 		}
-		if errList != nil && len(errList) > 0 {
-			errorList = append(errorList, errList...)
+		if len(res.errs) > 0 {
+			errorList = append(errorList, res.errs...)
 		}
 	}
 
+	//命中规则的, 按规则决定是否跟进
+	if rt.rule != nil {
+		requestList = rt.rule.filterRequests(requestList)
+	}
+
 	return itemList, requestList, errorList
 }
+
+//单次分析的结果
+type funcResult struct {
+	items    []*basic.Item
+	reqs     []*basic.Request
+	errs     []error
+	panicked bool
+	timedOut bool
+	elapsed  time.Duration
+}
+
+//运行一个分析函数, panic转换为带分析函数名的错误
+//有时间预算的在独立的goroutine中运行, 超时则丢弃其结果(goroutine无法被终止, 会自行结束)
+func runAnalyzeFunc(af *analyzeFunc, resp *basic.Response, timeout time.Duration) *funcResult {
+	start := time.Now()
+	call := func() (res *funcResult) {
+		res = &funcResult{}
+		defer func() {
+			if p := recover(); p != nil {
+				msg := fmt.Sprintf("Analyzer '%s' panic: %v. (ReqUrl=%s)", af.name, p, resp.ReqUrl)
+				log.Err(msg + "\n" + string(debug.Stack()))
+				res.errs = append(res.errs, errors.New(msg))
+				res.panicked = true
+			}
+		}()
+		res.items, res.reqs, res.errs = af.f(resp)
+		return
+	}
+
+	var res *funcResult
+	if timeout <= 0 {
+		res = call()
+	} else {
+		done := make(chan *funcResult, 1) //带缓冲, 超时后goroutine仍能写入并退出
+		go func() {
+			done <- call()
+		}()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case res = <-done:
+		case <-timer.C:
+			res = &funcResult{
+				errs: []error{errors.New(fmt.Sprintf("Analyzer '%s' timeout after %s. (ReqUrl=%s)",
+					af.name, timeout, resp.ReqUrl))},
+				timedOut: true,
+			}
+		}
+	}
+	res.elapsed = time.Since(start)
+	return res
+}
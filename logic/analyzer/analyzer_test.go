@@ -0,0 +1,227 @@
+package analyzer
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+//生成一个只产出一个item的分析函数, item中记录分析函数名
+func genAnalyzeFunc(name string) basic.AnalyzeResponseFunc {
+	return func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+		item := basic.Item{"by": name}
+		return []*basic.Item{&item}, nil, nil
+	}
+}
+
+func TestAnalyzeCallback(t *testing.T) {
+	log.InitLog("", "debug")
+	ana := NewAnalyzer()
+	defaults := []basic.AnalyzeResponseFunc{genAnalyzeFunc("default1"), genAnalyzeFunc("default2")}
+	named := map[string]basic.AnalyzeResponseFunc{
+		"listing": genAnalyzeFunc("listing"),
+		"article": genAnalyzeFunc("article"),
+	}
+
+	router, err := NewRouter(defaults, named, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//没有指定名字, 走默认分析链
+	resp := basic.NewResponse([]byte("<html></html>"), 0, "text/html", "http://www.360.cn/")
+	items, _, errs := ana.Analyze(router, resp)
+	if len(items) != 2 || len(errs) != 0 {
+		t.Fatal("Wrong default:", len(items), errs)
+	}
+
+	//按名字路由
+	resp.Callback = "article"
+	items, _, errs = ana.Analyze(router, resp)
+	if len(items) != 1 || (*items[0])["by"] != "article" || len(errs) != 0 {
+		t.Fatal("Wrong callback:", items, errs)
+	}
+
+	//未注册的名字, 退回默认分析链, 并报告错误
+	resp.Callback = "unknown"
+	items, _, errs = ana.Analyze(router, resp)
+	if len(items) != 2 || len(errs) != 1 {
+		t.Fatal("Wrong fallback:", len(items), errs)
+	}
+}
+
+//生成一个产出给定请求的分析函数
+func genLinkFunc(urls ...string) basic.AnalyzeResponseFunc {
+	return func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+		reqs := []*basic.Request{}
+		for _, u := range urls {
+			req, _ := basic.NewRequestWithBody(http.MethodGet, u, nil, nil, httpResp.Depth+1)
+			reqs = append(reqs, req)
+		}
+		item := basic.Item{"url": httpResp.ReqUrl}
+		return []*basic.Item{&item}, reqs, nil
+	}
+}
+
+func TestAnalyzeRules(t *testing.T) {
+	log.InitLog("", "debug")
+	ana := NewAnalyzer()
+	links := genLinkFunc("http://www.360.cn/n/1.html", "http://www.360.cn/about.html")
+	named := map[string]basic.AnalyzeResponseFunc{"links": links}
+	rules := []basic.AnalyzeRule{
+		{Name: "list", UrlPattern: `^http://www\.360\.cn/news\.html$`, Analyzers: []string{"links"},
+			Follow: true, FollowPattern: `^http://www\.360\.cn/n/`},
+		{Name: "article", UrlPattern: `/n/\d+\.html$`, AnalyzeFuncs: []basic.AnalyzeResponseFunc{links}},
+		{Name: "xml", ContentType: `xml`, Analyzers: []string{"links"}, Follow: true},
+	}
+	router, err := NewRouter([]basic.AnalyzeResponseFunc{genAnalyzeFunc("default")}, named, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		url, ct string
+		reqs    int
+	}{
+		{"http://www.360.cn/news.html", "text/html", 1}, //只跟进新闻页
+		{"http://www.360.cn/n/10758.html", "text/html", 0}, //不跟进
+		{"http://www.360.cn/sitemap", "application/xml", 2}, //按Content-Type匹配
+	}
+	for _, c := range cases {
+		resp := basic.NewResponse(nil, 0, c.ct, c.url)
+		_, reqs, _ := ana.Analyze(router, resp)
+		if len(reqs) != c.reqs {
+			t.Fatal("Wrong requests:", c.url, len(reqs))
+		}
+	}
+
+	//没有规则匹配, 走默认分析链
+	resp := basic.NewResponse(nil, 0, "text/html", "http://www.360.cn/")
+	items, _, _ := ana.Analyze(router, resp)
+	if len(items) != 1 || (*items[0])["by"] != "default" {
+		t.Fatal("Should use default:", items)
+	}
+
+	//引用未注册的分析函数是错误
+	if _, err := NewRouter(nil, named, rules); err == nil {
+		t.Fatal("Should be error")
+	}
+	if _, err := NewRouter([]basic.AnalyzeResponseFunc{links}, nil, rules); err == nil {
+		t.Fatal("Should be error")
+	}
+}
+
+func TestAnalyzeIsolation(t *testing.T) {
+	log.InitLog("", "debug")
+	ana := NewAnalyzer()
+	panicFunc := func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+		var m map[string]int
+		m["x"] = 1
+		return nil, nil, nil
+	}
+	slowFunc := func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+		time.Sleep(200 * time.Millisecond)
+		item := basic.Item{"by": "slow"}
+		return []*basic.Item{&item}, nil, nil
+	}
+	defaults := []basic.AnalyzeResponseFunc{panicFunc, genAnalyzeFunc("ok1"), slowFunc, genLinkFunc("http://www.360.cn/n/1.html")}
+	router, err := NewRouter(defaults, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.SetTimeout(50 * time.Millisecond)
+
+	//panic和超时的分析函数只产出错误, 其他分析函数照常产出
+	resp := basic.NewResponse([]byte("<html></html>"), 0, "text/html", "http://www.360.cn/")
+	items, reqs, errs := ana.Analyze(router, resp)
+	if len(items) != 2 || len(reqs) != 1 || len(errs) != 2 {
+		t.Fatal("Wrong result:", len(items), len(reqs), errs)
+	}
+	//错误和统计按函数名归属
+	panicName, slowName := "analyzer.TestAnalyzeIsolation.func1", "analyzer.TestAnalyzeIsolation.func2"
+	if !strings.Contains(errs[0].Error(), panicName) || !strings.Contains(errs[1].Error(), slowName) ||
+		!strings.Contains(errs[1].Error(), "timeout") {
+		t.Fatal("Wrong errors:", errs)
+	}
+
+	stats := map[string]FuncStats{}
+	for _, s := range router.Stats().Snapshot() {
+		stats[s.Name] = s
+	}
+	if len(stats) != 4 {
+		t.Fatal("Wrong stats:", stats)
+	}
+	if s := stats[panicName]; s.Calls != 1 || s.Panics != 1 || s.Errors != 1 {
+		t.Fatal("Wrong panic stats:", s)
+	}
+	if s := stats[slowName]; s.Timeouts != 1 || s.Items != 0 || s.MaxTime < 50*time.Millisecond {
+		t.Fatal("Wrong timeout stats:", s)
+	}
+	if s := stats["analyzer.genLinkFunc.func1"]; s.Items != 1 || s.Requests != 1 {
+		t.Fatal("Wrong stats:", s)
+	}
+	if summary := router.Stats().Summary("  "); !strings.Contains(summary, panicName+" Calls: 1") {
+		t.Fatal("Wrong summary:", summary)
+	}
+
+	//同一个函数出现多次, 加序号区分
+	router, _ = NewRouter([]basic.AnalyzeResponseFunc{panicFunc, panicFunc}, nil, nil)
+	if router.defaults[0].name != panicName || router.defaults[1].name != panicName+"#1" {
+		t.Fatal("Wrong names:", router.defaults[0].name, router.defaults[1].name)
+	}
+}
+
+func TestAnalyzeDispatch(t *testing.T) {
+	log.InitLog("", "debug")
+	ana := NewAnalyzer()
+	named := map[string]basic.AnalyzeResponseFunc{
+		"text": genAnalyzeFunc("text"),
+		"xml":  genAnalyzeFunc("xml"),
+	}
+	rules := []basic.AnalyzeRule{
+		{Name: "sitemap", UrlPattern: `/sitemap\.xml$`, AnalyzeFuncs: []basic.AnalyzeResponseFunc{genAnalyzeFunc("sitemap")}},
+	}
+	router, err := NewRouter([]basic.AnalyzeResponseFunc{genAnalyzeFunc("default")}, named, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := router.SetDispatch(map[string][]string{
+		"text/html": {DISPATCH_DEFAULT},
+		"text/*":    {"text"},
+		"+xml":      {"xml"},
+		"text/xml":  {"xml", DISPATCH_DEFAULT},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		url, ct string
+		by      []string
+	}{
+		{"http://www.360.cn/", "text/html; charset=utf-8", []string{"default"}},
+		{"http://www.360.cn/a.txt", "text/plain", []string{"text"}},
+		{"http://www.360.cn/a.svg", "image/svg+xml", []string{"xml"}},
+		{"http://www.360.cn/a.xml", "text/xml", []string{"xml", "default"}},
+		{"http://www.360.cn/sitemap.xml", "text/xml", []string{"sitemap"}}, //规则优先于分派表
+		{"http://www.360.cn/a.json", "application/json", []string{"default"}},
+	}
+	for _, c := range cases {
+		resp := basic.NewResponse(nil, 0, c.ct, c.url)
+		items, _, _ := ana.Analyze(router, resp)
+		by := []string{}
+		for _, item := range items {
+			by = append(by, (*item)["by"].(string))
+		}
+		if strings.Join(by, ",") != strings.Join(c.by, ",") {
+			t.Fatal("Wrong dispatch:", c.url, c.ct, by)
+		}
+	}
+
+	//引用未注册的分析函数是错误
+	if err := router.SetDispatch(map[string][]string{"text/csv": {"csv"}}); err == nil {
+		t.Fatal("Should be error")
+	}
+}
@@ -0,0 +1,251 @@
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hq-cml/spider-man/basic"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+/*
+ * 分析函数路由
+ * 决定每个Response由哪些分析函数分析, 优先级从高到低:
+ * 1. 请求通过SetCallback指定的分析函数名
+ * 2. 第一个匹配的分析规则(按Url正则和Content-Type正则)
+ * 3. MIME类型分派表
+ * 4. 默认分析链
+ */
+type Router struct {
+	defaults []*analyzeFunc
+	named    map[string]*analyzeFunc
+	rules    []*compiledRule
+	dispatch map[string][]*analyzeFunc //MIME类型 => 分析函数
+	patterns []string                  //分派表中的MIME类型, 用于匹配
+	timeout  time.Duration //单个分析函数的时间预算, 0表示不限制
+	stats    *Stats        //按分析函数名的统计
+}
+
+//带名字的分析函数, 名字用于统计和错误归属
+//默认分析链中的为函数名(包名.函数名, 同一个函数出现多次的加#序号), 按名字注册的为注册名, 规则中直接给出的为规则名:函数名
+type analyzeFunc struct {
+	name string
+	f    basic.AnalyzeResponseFunc
+}
+
+//编译之后的分析规则
+type compiledRule struct {
+	name          string
+	urlRe         *regexp.Regexp
+	contentTypeRe *regexp.Regexp
+	funcs         []*analyzeFunc
+	follow        bool
+	followRe      *regexp.Regexp
+}
+
+//路由结果
+type route struct {
+	funcs  []*analyzeFunc
+	rule   *compiledRule //命中的规则, nil表示没有命中规则
+	errs   []error
+}
+
+//New, 编译分析规则, 规则中引用的分析函数名必须已经注册
+func NewRouter(defaults []basic.AnalyzeResponseFunc, named map[string]basic.AnalyzeResponseFunc,
+	rules []basic.AnalyzeRule) (*Router, error) {
+	if defaults == nil {
+		return nil, errors.New("The response parser list is invalid!")
+	}
+
+	router := &Router{
+		named: map[string]*analyzeFunc{},
+		stats: newStats(),
+	}
+	seen := map[string]bool{}
+	for i, f := range defaults {
+		name := funcName(f)
+		if seen[name] {
+			name = fmt.Sprintf("%s#%d", name, i)
+		}
+		seen[name] = true
+		router.defaults = append(router.defaults, &analyzeFunc{name: name, f: f})
+	}
+	for n, f := range named {
+		if f != nil {
+			router.named[n] = &analyzeFunc{name: n, f: f}
+		}
+	}
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule-%d", i)
+		}
+		cr := &compiledRule{
+			name:   name,
+			follow: rule.Follow,
+		}
+		var err error
+		if cr.urlRe, err = compile(rule.UrlPattern); err != nil {
+			return nil, errors.New(fmt.Sprintf("Rule %s: invalid urlPattern: %s", name, err))
+		}
+		if cr.contentTypeRe, err = compile(rule.ContentType); err != nil {
+			return nil, errors.New(fmt.Sprintf("Rule %s: invalid contentType: %s", name, err))
+		}
+		if cr.followRe, err = compile(rule.FollowPattern); err != nil {
+			return nil, errors.New(fmt.Sprintf("Rule %s: invalid followPattern: %s", name, err))
+		}
+		for _, n := range rule.Analyzers {
+			af, ok := router.named[n]
+			if !ok {
+				return nil, errors.New(fmt.Sprintf("Rule %s: analyzer '%s' is not registered", name, n))
+			}
+			cr.funcs = append(cr.funcs, af)
+		}
+		for _, f := range rule.AnalyzeFuncs {
+			if f == nil {
+				return nil, errors.New(fmt.Sprintf("Rule %s: analyzer func is nil", name))
+			}
+			cr.funcs = append(cr.funcs, &analyzeFunc{name: name + ":" + funcName(f), f: f})
+		}
+		if len(cr.funcs) == 0 {
+			return nil, errors.New(fmt.Sprintf("Rule %s: no analyzer", name))
+		}
+		router.rules = append(router.rules, cr)
+	}
+	return router, nil
+}
+
+//分析函数的名字: 包名.函数名, 比如plugin.parse360NewsPage, 闭包为plugin.(*BaseSpider).GenResponseAnalysers.func1
+func funcName(f basic.AnalyzeResponseFunc) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+//分派表中表示默认分析链的名字
+const DISPATCH_DEFAULT = "default"
+
+//设置MIME类型分派表: MIME类型(支持text/*和+xml) => 分析函数名, 名字必须已经注册, default表示默认分析链
+func (r *Router) SetDispatch(table map[string][]string) error {
+	dispatch := map[string][]*analyzeFunc{}
+	patterns := []string{}
+	for mimeType, names := range table {
+		mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+		if mimeType == "" || len(names) == 0 {
+			return errors.New(fmt.Sprintf("Dispatch '%s': no analyzer", mimeType))
+		}
+		funcs := []*analyzeFunc{}
+		for _, n := range names {
+			if n == DISPATCH_DEFAULT {
+				funcs = append(funcs, r.defaults...)
+				continue
+			}
+			af, ok := r.named[n]
+			if !ok {
+				return errors.New(fmt.Sprintf("Dispatch '%s': analyzer '%s' is not registered", mimeType, n))
+			}
+			funcs = append(funcs, af)
+		}
+		dispatch[mimeType] = funcs
+		patterns = append(patterns, mimeType)
+	}
+	r.dispatch = dispatch
+	r.patterns = patterns
+	return nil
+}
+
+//设置单个分析函数的时间预算, 0表示不限制
+func (r *Router) SetTimeout(timeout time.Duration) *Router {
+	r.timeout = timeout
+	return r
+}
+
+//分析函数的统计
+func (r *Router) Stats() *Stats {
+	if r == nil {
+		return nil
+	}
+	return r.stats
+}
+
+//空的正则表示不限制
+func compile(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+//规则是否匹配
+func (cr *compiledRule) match(resp *basic.Response) bool {
+	if cr.urlRe != nil && !cr.urlRe.MatchString(resp.ReqUrl) {
+		return false
+	}
+	if cr.contentTypeRe != nil && !cr.contentTypeRe.MatchString(resp.ContentType) {
+		return false
+	}
+	return true
+}
+
+//按规则过滤分析出的请求
+func (cr *compiledRule) filterRequests(reqs []*basic.Request) []*basic.Request {
+	if !cr.follow {
+		return nil
+	}
+	if cr.followRe == nil {
+		return reqs
+	}
+	result := make([]*basic.Request, 0, len(reqs))
+	for _, req := range reqs {
+		if req != nil && req.HttpReq() != nil && cr.followRe.MatchString(req.HttpReq().URL.String()) {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+//为Response选择分析函数
+func (r *Router) route(resp *basic.Response) *route {
+	rt := &route{}
+
+	//按名字路由, 未注册的名字继续往下
+	if resp.Callback != "" {
+		if af, ok := r.named[resp.Callback]; ok {
+			rt.funcs = []*analyzeFunc{af}
+			return rt
+		}
+		rt.errs = append(rt.errs, errors.New(fmt.Sprintf(
+			"Unknown callback '%s', fall back to the rules and default analyzers. (ReqUrl=%s)", resp.Callback, resp.ReqUrl)))
+	}
+
+	//按规则路由
+	for _, cr := range r.rules {
+		if cr.match(resp) {
+			rt.funcs = cr.funcs
+			rt.rule = cr
+			return rt
+		}
+	}
+
+	//按MIME类型分派
+	mimeType := resp.MimeType
+	if mimeType == "" {
+		mimeType, _ = basic.ParseContentType(resp.ContentType)
+	}
+	if p := basic.MimePattern(mimeType, r.patterns); p != "" {
+		rt.funcs = r.dispatch[p]
+		return rt
+	}
+
+	rt.funcs = r.defaults
+	return rt
+}
@@ -0,0 +1,95 @@
+package analyzer
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * 分析函数的统计: 按分析函数名分别统计调用次数、产出的条目数、请求数、错误数、panic数、超时数和耗时
+ * 超时的调用, 耗时按时间预算计算(分析函数实际仍在运行, 结果被丢弃)
+ */
+type Stats struct {
+	mutex sync.Mutex
+	funcs map[string]*FuncStats
+}
+
+//单个分析函数的统计
+type FuncStats struct {
+	Name      string
+	Calls     uint64
+	Items     uint64
+	Requests  uint64
+	Errors    uint64
+	Panics    uint64
+	Timeouts  uint64
+	TotalTime time.Duration
+	MaxTime   time.Duration
+}
+
+func newStats() *Stats {
+	return &Stats{funcs: map[string]*FuncStats{}}
+}
+
+//记录一次调用
+func (s *Stats) record(name string, res *funcResult) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	fs, ok := s.funcs[name]
+	if !ok {
+		fs = &FuncStats{Name: name}
+		s.funcs[name] = fs
+	}
+	fs.Calls++
+	fs.Items += uint64(len(res.items))
+	fs.Requests += uint64(len(res.reqs))
+	fs.Errors += uint64(len(res.errs))
+	if res.panicked {
+		fs.Panics++
+	}
+	if res.timedOut {
+		fs.Timeouts++
+	}
+	fs.TotalTime += res.elapsed
+	if res.elapsed > fs.MaxTime {
+		fs.MaxTime = res.elapsed
+	}
+}
+
+//全部分析函数的统计快照, 按名字排序
+func (s *Stats) Snapshot() []FuncStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]FuncStats, 0, len(s.funcs))
+	for _, fs := range s.funcs {
+		result = append(result, *fs)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+//摘要
+func (s *Stats) Summary(prefix string) string {
+	if s == nil {
+		return prefix + "None\n"
+	}
+	snapshot := s.Snapshot()
+	if len(snapshot) == 0 {
+		return prefix + "None\n"
+	}
+	var buff bytes.Buffer
+	for _, fs := range snapshot {
+		avg := time.Duration(0)
+		if fs.Calls > 0 {
+			avg = fs.TotalTime / time.Duration(fs.Calls)
+		}
+		buff.WriteString(fmt.Sprintf(prefix+"%s Calls: %d, Items: %d, Requests: %d, Errors: %d, Panics: %d, Timeouts: %d, Avg: %s, Max: %s\n",
+			fs.Name, fs.Calls, fs.Items, fs.Requests, fs.Errors, fs.Panics, fs.Timeouts, avg, fs.MaxTime))
+	}
+	return buff.String()
+}
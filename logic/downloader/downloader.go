@@ -1,16 +1,22 @@
 package downloader
 
 import (
+	"bufio"
 	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/filestore"
 	"github.com/hq-cml/spider-man/helper/idgen"
 	"net/http"
+	"io"
 	"io/ioutil"
+	"mime"
+	"path/filepath"
 	"github.com/hq-cml/spider-man/helper/log"
 	"errors"
 	"fmt"
 	"strings"
 	"time"
 	"strconv"
+	"sync"
 )
 
 /***********************************下载器**********************************/
@@ -18,11 +24,24 @@ import (
 type Downloader struct {
 	id         uint64 //ID
 	httpClient *http.Client
+	mediaStore *filestore.Store //媒体文件存储, nil表示不存储媒体文件
 }
 func (dl *Downloader) Id() uint64 {
 	return dl.id
 }
 
+//预检方式
+const (
+	PREFLIGHT_SNIFF = "sniff" //直接GET, 根据响应头和Body前若干字节判断
+	PREFLIGHT_HEAD  = "head"  //先发送HEAD请求判断
+)
+
+//嗅探的字节数, http.DetectContentType最多只看前512字节
+const SNIFF_LEN = 512
+
+//不支持HEAD方法的host, 所有下载器共享
+var headUnsupported sync.Map
+
 //下载器专用的id生成器
 var downloaderIdGenerator *idgen.IdGenerator = idgen.NewIdGenerator()
 
@@ -40,6 +59,21 @@ func NewDownloader(client *http.Client) *Downloader {
 	}
 }
 
+//非200响应的错误, 保留状态码, 供限速等模块判断
+type StatusError struct {
+	StatusCode int
+	msg        string
+}
+
+func (e *StatusError) Error() string {
+	return e.msg
+}
+
+//设置媒体文件存储, 所有下载器共用同一个存储
+func (dl *Downloader) SetMediaStore(store *filestore.Store) {
+	dl.mediaStore = store
+}
+
 func (dl *Downloader) Identifier() string {
 	return "Downloader[" + strconv.FormatInt(int64(dl.Id()), 10)+ "]"
 }
@@ -51,8 +85,11 @@ func (dl *Downloader) Download(req *basic.Request) (*basic.Response, bool, strin
 	log.Infof(dl.Identifier() + " Check request Head ext. (reqUrl=%s)... Depth: (%d) \n",
 		httpReq.URL.String(), req.Depth())
 
+	//扩展名匹配的媒体文件, 不能被当成二进制文件跳过
+	mediaByExt := dl.mediaStore != nil && isMediaExt(httpReq.URL.Path)
+
 	//跳过二进制文件下载
-	if basic.Conf.SkipBinFile {
+	if basic.Conf.SkipBinFile && !mediaByExt {
 		skip, msg, err := dl.skipBinFile(req)
 		if err != nil {
 			if strings.Contains(strings.ToLower(err.Error()), "timeout") {
@@ -67,6 +104,7 @@ func (dl *Downloader) Download(req *basic.Request) (*basic.Response, bool, strin
 
 	log.Infof(dl.Identifier() + " Start to Download the request (reqUrl=%s)... Depth: (%d) \n",
 		httpReq.URL.String(), req.Depth())
+	req.ResetBody() //重试的时候Body需要重建
 	httpResp, err := dl.httpClient.Do(httpReq)
 	if err != nil {
 		if strings.Contains(strings.ToLower(err.Error()), "timeout") {
@@ -74,12 +112,16 @@ func (dl *Downloader) Download(req *basic.Request) (*basic.Response, bool, strin
 		}
 		return nil, false, "", errors.New(dl.Identifier() + " Download("+ httpReq.URL.String() +") Error:" + err.Error())
 	}
+	//Close未读完的Body会直接断开连接, 这正是提前终止下载所需要的
 	defer httpResp.Body.Close()
 
 	//仅支持返回码200的响应
 	if httpResp.StatusCode != 200 {
-		err := errors.New(fmt.Sprintf(dl.Identifier() +"Unsupported status code %d. (ReqUrl=%s)",
-			httpResp.StatusCode, httpReq.URL.String()))
+		err := &StatusError{
+			StatusCode: httpResp.StatusCode,
+			msg: fmt.Sprintf(dl.Identifier() +"Unsupported status code %d. (ReqUrl=%s)",
+				httpResp.StatusCode, httpReq.URL.String()),
+		}
 		return nil, false, "", err
 	}
 
@@ -91,6 +133,21 @@ func (dl *Downloader) Download(req *basic.Request) (*basic.Response, bool, strin
 	//httpResp.Body.Close()
 	//httpResp.Body = ioutil.NopCloser(bytes.NewBuffer(p))
 
+	//媒体文件直接流式存储到本地
+	if dl.mediaStore != nil {
+		if mimeType := peekContentType(httpResp); mediaByExt || isMediaMime(mimeType) {
+			return dl.saveMedia(req, httpResp, mimeType)
+		}
+	}
+
+	//跳过二进制文件和超大文件: 根据响应头和Body的前若干字节判断, 不满足条件则直接终止下载
+	if basic.Conf.SkipBinFile {
+		if skip, msg := dl.sniffContent(httpResp); skip {
+			log.Infof(dl.Identifier() + "Skip Request(%s)... Depth:(%d). Reason: %s \n", httpReq.URL.String(), req.Depth(), msg)
+			return nil, true, msg, nil
+		}
+	}
+
 	log.Infof(dl.Identifier() + "Read the Body (reqUrl=%s)... Depth: (%d) \n",
 		httpReq.URL.String(), req.Depth())
 	body, ok := dl.getBodyTimeout(httpResp, 30 * time.Second)
@@ -100,19 +157,30 @@ func (dl *Downloader) Download(req *basic.Request) (*basic.Response, bool, strin
 		err := errors.New(dl.Identifier() + "Time out：("+ httpReq.URL.String() +"). Content-Length: " + httpResp.Header.Get("Content-Length"))
 		return nil, false, "read timeout", err
 	}
+	if basic.Conf.SkipBinFile && basic.Conf.MaxBodySize > 0 && int64(len(body)) > basic.Conf.MaxBodySize {
+		//没有Content-Length的响应, 只能读到上限才知道
+		msg := "Body too large. Exceed:" + strconv.FormatInt(basic.Conf.MaxBodySize, 10)
+		log.Infof(dl.Identifier() + "Skip Request(%s)... Depth:(%d). Reason: %s \n", httpReq.URL.String(), req.Depth(), msg)
+		return nil, true, msg, nil
+	}
 
-	return basic.NewResponse(body,
+	resp := basic.NewResponse(body,
 		req.Depth(),
 		httpResp.Header.Get("content-type"),
-		req.HttpReq().URL.String()), false, "", nil
+		req.HttpReq().URL.String())
+	resp.Header = httpResp.Header
+	resp.Fingerprint = req.Fingerprint()
+	resp.Meta = req.Meta()
+	resp.Callback = req.Callback()
+	return resp, false, "", nil
 }
 
 //运行中发现, 深度加大或者downloader数加大, 会发生内存暴涨
 //分析发现有很多二进制的文件下载,将内存撑爆了,爬虫暂时不支持二进制文件
-//过滤方式简单粗暴:
+//过滤方式:
 // 先判断url扩展名, 静态文件直接略过
-// 如果扩展名不明显, 那只能发送一次HEAD方法的请求了, 但是这会导致多一次请求
-// 暂时没有更好的方法
+// 扩展名不明显的, 默认直接发GET, 由sniffContent根据响应头和Body前若干字节判断, 不满足则提前终止
+// 如果配置了preflight=head, 则先发送一次HEAD请求判断; 不支持HEAD的host会被记住, 之后退回到sniff方式
 func (dl *Downloader)skipBinFile(req *basic.Request) (bool, string, error) {
 	url := req.HttpReq().URL.String()
 
@@ -124,21 +192,50 @@ func (dl *Downloader)skipBinFile(req *basic.Request) (bool, string, error) {
 		return true, "Ext Invalid", nil
 	}
 
-	//TODO 这个地方可以继续优化，减少消耗，比如分析ext校验逃过的url的特点
 	//通过HEAD请求来判断
+	host := req.HttpReq().URL.Host
+	if basic.Conf.Preflight != PREFLIGHT_HEAD || req.Method() != http.MethodGet {
+		return false, "", nil //非GET的请求, HEAD的结果没有参考意义
+	}
+	if _, ok := headUnsupported.Load(host); ok {
+		return false, "", nil
+	}
 	httpReq, err := http.NewRequest(http.MethodHead, url, nil)
 	if err != nil {
 		return true, "", err
 	}
+	httpReq.Header = req.HttpReq().Header.Clone() //HEAD请求沿用GET的请求头
 	resp, err := dl.httpClient.Do(httpReq)
 	if err != nil {
-		return true, "", err
+		if strings.Contains(strings.ToLower(err.Error()), "timeout") {
+			return true, "", err
+		}
+		//HEAD的响应格式不对, 说明站点不支持HEAD, 记住这个host, 之后退回到sniff方式
+		//DNS失败、连接被拒、TLS错误等可能是临时的, 只对本次请求退回到sniff方式, 不记住host
+		if strings.Contains(err.Error(), "malformed HTTP") {
+			headUnsupported.Store(host, true)
+			log.Infof(dl.Identifier() + "Host(%s) does not support HEAD: %s \n", host, err.Error())
+		} else {
+			log.Infof(dl.Identifier() + "HEAD Request(%s) failed, sniff instead: %s \n", url, err.Error())
+		}
+		return false, "", nil
 	}
 	defer resp.Body.Close()
 
+	//只有405/501才说明HEAD方法本身不被支持, 403等状态可能只是这个页面需要授权
+	if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+		headUnsupported.Store(host, true)
+		log.Infof(dl.Identifier() + "Host(%s) does not support HEAD: status %d \n", host, resp.StatusCode)
+		return false, "", nil
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Infof(dl.Identifier() + "HEAD Request(%s) failed, sniff instead: status %d \n", url, resp.StatusCode)
+		return false, "", nil //错误页面的响应头没有参考意义, 交给GET处理
+	}
+
 	contentType := resp.Header.Get("Content-Type")
 	contentLength := resp.Header.Get("Content-Length")
-	if !strings.Contains(contentType, "text/html") {
+	if !acceptContentType(contentType) {
 		log.Infof(dl.Identifier() + "Skip Request(%s)... Depth:(%d). Reason: Content-Type Invalid \n", url, req.Depth())
 		return true,
 			   "Content-Type Invalid:" + contentType + ". Content-Length:" + contentLength,
@@ -147,6 +244,160 @@ func (dl *Downloader)skipBinFile(req *basic.Request) (bool, string, error) {
 	return false, "", nil
 }
 
+//根据GET响应的头和Body前512字节判断是否需要跳过, 不用等整个Body下载完
+//判断所用的字节不会丢失, httpResp.Body会被替换成包含这些字节的Reader
+func (dl *Downloader) sniffContent(httpResp *http.Response) (bool, string) {
+	contentType := httpResp.Header.Get("Content-Type")
+	contentLength := httpResp.Header.Get("Content-Length")
+
+	//响应头声明的类型不对, 直接跳过
+	if contentType != "" && !acceptContentType(contentType) {
+		return true, "Content-Type Invalid:" + contentType + ". Content-Length:" + contentLength
+	}
+
+	//响应头声明的长度超过上限, 直接跳过
+	max := basic.Conf.MaxBodySize
+	if max > 0 && httpResp.ContentLength > max {
+		return true, "Content-Length too large:" + contentLength
+	}
+
+	//嗅探Body的前512字节, 防止伪静态的二进制文件(声明text/html, 实际是二进制)
+	head := peekBody(httpResp)
+	if max > 0 {
+		httpResp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(httpResp.Body, max+1), httpResp.Body} //多读一个字节, 用来判断是否超过上限
+	}
+
+	if len(head) > 0 {
+		sniffed := http.DetectContentType(head)
+		if !acceptSniffed(contentType, sniffed) {
+			return true, "Sniffed Content-Type Invalid:" + sniffed + ". Content-Type:" + contentType
+		}
+	}
+	return false, ""
+}
+
+//嗅探出的类型是否接受
+//1. 嗅探出文本, 或者嗅探出的类型本身被接受(比如配置了application/pdf, image/*), 接受
+//2. 响应头声明了接受的非文本类型(比如application/pdf, 二进制的+json), 以声明为准, 接受
+//3. 响应头没有声明、声明为octet-stream或者声明为文本类型, 但嗅探出二进制, 是伪静态的二进制文件, 不接受
+func acceptSniffed(contentType, sniffed string) bool {
+	if strings.HasPrefix(sniffed, "text/") || acceptContentType(sniffed) {
+		return true
+	}
+	declared, _ := basic.ParseContentType(contentType)
+	return declared != "" && declared != "application/octet-stream" && !strings.HasPrefix(declared, "text/")
+}
+
+//窥视Body的前512字节, 窥视的字节不会丢失, httpResp.Body会被替换成包含这些字节的Reader
+func peekBody(httpResp *http.Response) []byte {
+	br := bufio.NewReaderSize(httpResp.Body, SNIFF_LEN)
+	head, _ := br.Peek(SNIFF_LEN)
+	httpResp.Body = struct {
+		io.Reader
+		io.Closer
+	}{br, httpResp.Body}
+	return head
+}
+
+//获取响应的MIME类型, 响应头没有声明或者只声明了octet-stream, 则嗅探Body
+func peekContentType(httpResp *http.Response) string {
+	contentType := httpResp.Header.Get("Content-Type")
+	if mimeType, _, err := mimeParse(contentType); err == nil && mimeType != "" && mimeType != "application/octet-stream" {
+		return mimeType
+	}
+	if head := peekBody(httpResp); len(head) > 0 {
+		mimeType, _, _ := mimeParse(http.DetectContentType(head))
+		return mimeType
+	}
+	return ""
+}
+
+//解析Content-Type, 得到小写的MIME类型
+func mimeParse(contentType string) (string, map[string]string, error) {
+	if contentType == "" {
+		return "", nil, nil
+	}
+	return mime.ParseMediaType(contentType)
+}
+
+//是否是需要存储的媒体文件扩展名
+func isMediaExt(path string) bool {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	if ext == "" {
+		return false
+	}
+	for _, e := range basic.Conf.MediaExt {
+		if strings.ToLower(strings.TrimSpace(e)) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+//是否是需要存储的媒体文件MIME类型, 按前缀匹配
+func isMediaMime(mimeType string) bool {
+	if mimeType == "" {
+		return false
+	}
+	for _, m := range basic.Conf.MediaMime {
+		if m = strings.ToLower(strings.TrimSpace(m)); m != "" && strings.HasPrefix(mimeType, m) {
+			return true
+		}
+	}
+	return false
+}
+
+//流式存储媒体文件, 返回的Response不带Body, 只带存储信息
+func (dl *Downloader) saveMedia(req *basic.Request, httpResp *http.Response, mimeType string) (*basic.Response, bool, string, error) {
+	url := req.HttpReq().URL.String()
+	if max := basic.Conf.MediaMaxSize; max > 0 && httpResp.ContentLength > max {
+		msg := "Media too large. Content-Length:" + httpResp.Header.Get("Content-Length")
+		log.Infof(dl.Identifier() + "Skip Request(%s)... Depth:(%d). Reason: %s \n", url, req.Depth(), msg)
+		return nil, true, msg, nil
+	}
+
+	log.Infof(dl.Identifier() + "Save the media (reqUrl=%s)... Depth: (%d). Mime: (%s) \n", url, req.Depth(), mimeType)
+	result, err := dl.mediaStore.Save(httpResp.Body, basic.Conf.MediaMaxSize, filepath.Ext(req.HttpReq().URL.Path))
+	if err == filestore.ErrTooLarge {
+		msg := "Media too large. Exceed:" + strconv.FormatInt(basic.Conf.MediaMaxSize, 10)
+		log.Infof(dl.Identifier() + "Skip Request(%s)... Depth:(%d). Reason: %s \n", url, req.Depth(), msg)
+		return nil, true, msg, nil
+	}
+	if err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "timeout") {
+			return nil, false, "read timeout", errors.New(dl.Identifier() + " SaveMedia("+ url +") Error:" + err.Error())
+		}
+		return nil, false, "", errors.New(dl.Identifier() + " SaveMedia("+ url +") Error:" + err.Error())
+	}
+
+	resp := basic.NewResponse(nil, req.Depth(), httpResp.Header.Get("content-type"), url)
+	resp.Header = httpResp.Header
+	resp.Fingerprint = req.Fingerprint()
+	resp.Meta = req.Meta()
+	resp.Callback = req.Callback()
+	resp.Media = &basic.MediaFile{
+		Path:   result.Path,
+		Size:   result.Size,
+		Mime:   mimeType,
+		Sha256: result.Sha256,
+		Dedup:  result.Dedup,
+	}
+	return resp, false, "", nil
+}
+
+//是否是可以接受的Content-Type, 由配置的acceptMime决定, 没有配置则接受HTML页面, JSON接口和RSS/Atom
+func acceptContentType(contentType string) bool {
+	mimeType, _ := basic.ParseContentType(contentType)
+	patterns := basic.Conf.AcceptMime
+	if len(patterns) == 0 {
+		patterns = basic.DefaultAcceptMime
+	}
+	return basic.MatchMime(mimeType, patterns)
+}
+
 //从http.Reosponse中取出Body，并且支持超时
 //由于服务器端实现的差异，在高并发情况下，ioutil.ReadAll常会出现迷之超时（官方解释ReadAll必须遇到EOF或者error才能结束）
 //所以必须设置超时时间，防止downloader全部给卡死了
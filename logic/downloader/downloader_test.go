@@ -1,7 +1,12 @@
 package downloader
 
 import (
+	"github.com/hq-cml/spider-man/helper/filestore"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"github.com/hq-cml/spider-man/basic"
 	"github.com/hq-cml/spider-man/helper/log"
@@ -9,6 +14,7 @@ import (
 
 func TestSkipUrl(t *testing.T) {
 	log.InitLog("", "debug")
+	basic.Conf = &basic.SpiderConf{SkipBinFile: true, Preflight: PREFLIGHT_HEAD}
 
 	dl := NewDownloader(nil)
 
@@ -37,6 +43,7 @@ func TestSkipUrl(t *testing.T) {
 
 func TestSkipUrl2(t *testing.T) {
 	log.InitLog("", "debug")
+	basic.Conf = &basic.SpiderConf{SkipBinFile: true, Preflight: PREFLIGHT_HEAD}
 	dl := NewDownloader(nil)
 	u, err := http.NewRequest(http.MethodGet, "http://bang.360.cn", nil)
 	if err != nil {
@@ -46,3 +53,191 @@ func TestSkipUrl2(t *testing.T) {
 	t.Log(dl.skipBinFile(req))
 }
 
+
+func TestHeadUnsupported(t *testing.T) {
+	log.InitLog("", "debug")
+	basic.Conf = &basic.SpiderConf{SkipBinFile: true, Preflight: PREFLIGHT_HEAD}
+	dl := NewDownloader(nil)
+
+	newServer := func(status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(status)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		}))
+	}
+	skipBinFile := func(rawUrl string) {
+		u, _ := http.NewRequest(http.MethodGet, rawUrl, nil)
+		skip, msg, err := dl.skipBinFile(basic.NewRequest(u, 0))
+		if skip || err != nil {
+			t.Fatal("Should fall back to sniff:", rawUrl, skip, msg, err)
+		}
+	}
+
+	//405和501说明不支持HEAD, 记住host
+	for _, status := range []int{http.StatusMethodNotAllowed, http.StatusNotImplemented} {
+		ts := newServer(status)
+		skipBinFile(ts.URL + "/page")
+		if _, ok := headUnsupported.Load(strings.TrimPrefix(ts.URL, "http://")); !ok {
+			t.Fatal("Host should be marked:", status)
+		}
+		ts.Close()
+	}
+
+	//403只是这个页面需要授权, 不记住host
+	ts := newServer(http.StatusForbidden)
+	defer ts.Close()
+	skipBinFile(ts.URL + "/private")
+	if _, ok := headUnsupported.Load(strings.TrimPrefix(ts.URL, "http://")); ok {
+		t.Fatal("Host should not be marked by 403")
+	}
+
+	//连接被拒可能是临时的, 不记住host
+	closed := newServer(http.StatusOK)
+	closed.Close()
+	skipBinFile(closed.URL + "/page")
+	if _, ok := headUnsupported.Load(strings.TrimPrefix(closed.URL, "http://")); ok {
+		t.Fatal("Host should not be marked by connection error")
+	}
+}
+
+func TestSniffContent(t *testing.T) {
+	log.InitLog("", "debug")
+	basic.Conf = &basic.SpiderConf{SkipBinFile: true, Preflight: PREFLIGHT_SNIFF, MaxBodySize: 1024}
+
+	heads := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads++
+		}
+		switch r.URL.Path {
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><body>hello</body></html>"))
+		case "/fake.html": //伪静态的二进制文件
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("PK\x03\x04\x00\x00\x00\x00binary"))
+		case "/big.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>" + strings.Repeat("a", 2048) + "</html>"))
+		case "/api":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Write([]byte(`{"items": []}`))
+		case "/feed":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			w.Write([]byte(`<?xml version="1.0"?><rss><channel></channel></rss>`))
+		case "/style.css":
+			w.Header().Set("Content-Type", "text/css")
+			w.Write([]byte("body {}"))
+		case "/data.csv":
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("a,b\n1,2\n"))
+		case "/doc.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF-1.4 test"))
+		case "/logo": //没有声明类型, 嗅探出image/gif
+			w.Write([]byte("GIF89a......"))
+		case "/cbor": //二进制的+json
+			w.Header().Set("Content-Type", "application/vnd.test+json")
+			w.Write([]byte("\x00\x01\x02\x03binary"))
+		}
+	}))
+	defer ts.Close()
+
+	dl := NewDownloader(nil)
+	cases := map[string]bool{
+		"/data.csv":  true, //默认不接受CSV
+		"/page.html": false,
+		"/fake.html": true,
+		"/big.html":  true,
+		"/api":       false,
+		"/feed":      false,
+		"/style.css": true,
+	}
+	for path, expectSkip := range cases {
+		u, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		resp, skip, msg, err := dl.Download(basic.NewRequest(u, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skip != expectSkip {
+			t.Fatal("Wrong skip:", path, skip, msg)
+		}
+		if !skip && path == "/page.html" && string(resp.Body) != "<html><body>hello</body></html>" {
+			t.Fatal("Wrong body:", string(resp.Body))
+		}
+	}
+	if heads != 0 {
+		t.Fatal("Sniff mode should not send HEAD")
+	}
+
+	//配置了acceptMime, 按配置接受
+	basic.Conf.AcceptMime = []string{"text/html", "text/csv"}
+	for path, expectSkip := range map[string]bool{"/data.csv": false, "/api": true, "/page.html": false} {
+		u, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		resp, skip, msg, err := dl.Download(basic.NewRequest(u, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skip != expectSkip {
+			t.Fatal("Wrong skip with acceptMime:", path, skip, msg)
+		}
+		if path == "/data.csv" && resp.MimeType != "text/csv" {
+			t.Fatal("Wrong mime:", resp.MimeType)
+		}
+	}
+
+	//接受的非文本类型不会被嗅探拦下, 伪静态的二进制文件仍然跳过
+	basic.Conf.AcceptMime = []string{"text/html", "application/pdf", "image/*", "+json"}
+	for path, expectSkip := range map[string]bool{"/doc.pdf": false, "/logo": false, "/cbor": false, "/fake.html": true} {
+		u, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		_, skip, msg, err := dl.Download(basic.NewRequest(u, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skip != expectSkip {
+			t.Fatal("Wrong skip with binary acceptMime:", path, skip, msg)
+		}
+	}
+}
+
+func TestSaveMedia(t *testing.T) {
+	log.InitLog("", "debug")
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	basic.Conf = &basic.SpiderConf{SkipBinFile: true, MaxBodySize: 1024,
+		SaveMedia: true, MediaExt: []string{"pdf"}, MediaMime: []string{"image/"}, MediaMaxSize: 1024}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a.pdf":
+			w.Write([]byte("%PDF-1.4 test"))
+		case "/logo": //没有扩展名, 靠嗅探出image/gif
+			w.Write([]byte("GIF89a......"))
+		}
+	}))
+	defer ts.Close()
+
+	store, _ := filestore.NewStore(dir)
+	dl := NewDownloader(nil)
+	dl.SetMediaStore(store)
+	for _, path := range []string{"/a.pdf", "/logo"} {
+		u, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		resp, skip, msg, err := dl.Download(basic.NewRequest(u, 0))
+		if err != nil || skip {
+			t.Fatal("Should save media:", path, skip, msg, err)
+		}
+		if resp.Media == nil || resp.Media.Size == 0 {
+			t.Fatal("Wrong media:", path, resp.Media)
+		}
+		if _, err := os.Stat(resp.Media.Path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package downloader
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+ * 请求头Profile
+ * 很多站点对Go默认的User-Agent(Go-http-client/1.1)返回降级页面甚至直接封禁
+ * 所以每个请求在发送之前, 按照配置的模式选取一个profile, 设置User-Agent, Accept, Accept-Language, Referer
+ */
+type HeaderProfiler struct {
+	mode          string
+	refererPolicy string
+	profiles      []basic.HeaderProfile
+	counter       uint64            //轮换计数
+	hostMap       map[string]int    //host => profile下标
+	mutex         sync.Mutex        //hostMap保护锁
+}
+
+//New
+func NewHeaderProfiler(mode, refererPolicy string, profiles []basic.HeaderProfile) *HeaderProfiler {
+	return &HeaderProfiler{
+		mode:          mode,
+		refererPolicy: refererPolicy,
+		profiles:      profiles,
+		hostMap:       make(map[string]int),
+	}
+}
+
+//为请求设置请求头, ref是父Url
+//请求自身已经带有的请求头(分析函数显式指定的, 或者上次重试设置的)不会被覆盖
+func (hp *HeaderProfiler) Apply(httpReq *http.Request, ref string) {
+	if hp == nil || httpReq == nil || httpReq.URL == nil {
+		return
+	}
+
+	if profile := hp.choose(httpReq.URL.Host); profile != nil {
+		setIfAbsent(httpReq.Header, "User-Agent", profile.UserAgent)
+		setIfAbsent(httpReq.Header, "Accept", profile.Accept)
+		setIfAbsent(httpReq.Header, "Accept-Language", profile.AcceptLanguage)
+	}
+	setIfAbsent(httpReq.Header, "Referer", hp.referer(httpReq.URL, ref))
+}
+
+func setIfAbsent(header http.Header, key, value string) {
+	if value != "" && header.Get(key) == "" {
+		header.Set(key, value)
+	}
+}
+
+//按照模式选取profile
+func (hp *HeaderProfiler) choose(host string) *basic.HeaderProfile {
+	if len(hp.profiles) == 0 {
+		return nil
+	}
+
+	switch hp.mode {
+	case basic.HEADER_MODE_ROTATE:
+		idx := (atomic.AddUint64(&hp.counter, 1) - 1) % uint64(len(hp.profiles))
+		return &hp.profiles[idx]
+	case basic.HEADER_MODE_HOST:
+		hp.mutex.Lock()
+		defer hp.mutex.Unlock()
+		idx, ok := hp.hostMap[host]
+		if !ok {
+			//新host按顺序分配, 之后固定不变
+			idx = int(hp.counter % uint64(len(hp.profiles)))
+			hp.counter++
+			hp.hostMap[host] = idx
+		}
+		return &hp.profiles[idx]
+	}
+	return nil
+}
+
+//按照Referer策略, 根据父Url生成Referer
+//首个请求的父Url是"ROOT", 不是合法的Url, 直接忽略
+func (hp *HeaderProfiler) referer(reqUrl *url.URL, ref string) string {
+	if ref == "" || hp.refererPolicy == basic.REFERER_POLICY_NONE {
+		return ""
+	}
+	refUrl, err := url.Parse(ref)
+	if err != nil || !refUrl.IsAbs() {
+		return ""
+	}
+	//https => http 不泄露Referer
+	if refUrl.Scheme == "https" && reqUrl.Scheme == "http" {
+		return ""
+	}
+
+	switch hp.refererPolicy {
+	case basic.REFERER_POLICY_FULL:
+		return refUrl.String()
+	case basic.REFERER_POLICY_ORIGIN:
+		return refUrl.Scheme + "://" + refUrl.Host + "/"
+	case basic.REFERER_POLICY_SAME_ORIGIN:
+		if refUrl.Scheme == reqUrl.Scheme && refUrl.Host == reqUrl.Host {
+			return refUrl.String()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,55 @@
+package downloader
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"net/http"
+	"testing"
+)
+
+var testProfiles = []basic.HeaderProfile{
+	{Name: "a", UserAgent: "UA-A", AcceptLanguage: "zh-CN"},
+	{Name: "b", UserAgent: "UA-B", AcceptLanguage: "en-US"},
+}
+
+func TestHeaderRotate(t *testing.T) {
+	hp := NewHeaderProfiler(basic.HEADER_MODE_ROTATE, basic.REFERER_POLICY_FULL, testProfiles)
+
+	uas := []string{}
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://www.360.cn/n/1.html", nil)
+		hp.Apply(req, "http://www.360.cn/news.html")
+		uas = append(uas, req.Header.Get("User-Agent"))
+		if req.Header.Get("Referer") != "http://www.360.cn/news.html" {
+			t.Fatal("Wrong referer:", req.Header.Get("Referer"))
+		}
+	}
+	if uas[0] != "UA-A" || uas[1] != "UA-B" || uas[2] != "UA-A" {
+		t.Fatal("Wrong rotate:", uas)
+	}
+}
+
+func TestHeaderPinHost(t *testing.T) {
+	hp := NewHeaderProfiler(basic.HEADER_MODE_HOST, basic.REFERER_POLICY_ORIGIN, testProfiles)
+
+	get := func(u, ref string) *http.Request {
+		req, _ := http.NewRequest(http.MethodGet, u, nil)
+		hp.Apply(req, ref)
+		return req
+	}
+	r1 := get("http://www.360.cn/a", "ROOT")
+	r2 := get("http://www.sohu.com/a", "http://www.360.cn/a")
+	r3 := get("http://www.360.cn/b", "http://www.360.cn/a?x=1")
+
+	if r1.Header.Get("User-Agent") != r3.Header.Get("User-Agent") {
+		t.Fatal("Same host should use same profile")
+	}
+	if r1.Header.Get("User-Agent") == r2.Header.Get("User-Agent") {
+		t.Fatal("Different host should use next profile")
+	}
+	if r1.Header.Get("Referer") != "" {
+		t.Fatal("ROOT should not be referer")
+	}
+	if r3.Header.Get("Referer") != "http://www.360.cn/" {
+		t.Fatal("Wrong origin referer:", r3.Header.Get("Referer"))
+	}
+}
@@ -5,11 +5,11 @@ import (
     "errors"
     "github.com/hq-cml/spider-man/basic"
     "github.com/hq-cml/spider-man/helper/log"
+    "github.com/hq-cml/spider-man/helper/robots"
     "github.com/hq-cml/spider-man/logic/analyzer"
     "github.com/hq-cml/spider-man/helper/util"
     "sync/atomic"
     "strings"
-    "net/http"
 )
 
 /*
@@ -47,7 +47,7 @@ func (schdl *Scheduler) analyze(response basic.Response) {
     atomic.AddUint64(&schdl.analyzerCnt, 1)          //原子加1
     defer atomic.AddUint64(&schdl.analyzerCnt, ^uint64(0)) //原子减1
 
-    //异常兜底
+    //异常兜底(分析函数各自有兜底, 这里兜的是调度器自身的异常)
     defer func() {
         if p := recover(); p != nil {
             msg := fmt.Sprintf("Fatal Analysis Error:%s. (ReqUrl=%s)\n", p, response.ReqUrl)
@@ -79,11 +79,16 @@ func (schdl *Scheduler) analyze(response basic.Response) {
 
     //分析
     moudleCode := generateModuleCode(ANALYZER_CODE, ana.Id())
-    itemList, requestList, errs := ana.Analyze(schdl.analyzeFuncs, response)
+    itemList, requestList, errs := schdl.analyzeResponse(ana, &response)
 
-    //将分析出的item放到item通道里
+    //将分析出的item放到item通道里, 请求的上下文信息随之传递
     if itemList != nil {
         for _, item := range itemList {
+            if len(response.Meta) > 0 {
+                if _, ok := (*item)["meta"]; !ok {
+                    (*item)["meta"] = response.Meta
+                }
+            }
             schdl.sendToItemChan(*item, moudleCode)
         }
     }
@@ -103,6 +108,46 @@ func (schdl *Scheduler) analyze(response basic.Response) {
     }
 }
 
+//分析一个响应, 并按照robots指令压制条目和链接
+//robots指令在分析之前解析: 解析出的文档缓存在response上, 分析函数直接复用, 同一个响应只转码和解析一次
+//(分析函数超时后可能仍在后台使用response, 所以不能放在分析之后再解析)
+func (schdl *Scheduler) analyzeResponse(ana *analyzer.Analyzer,
+    response *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+    var directives *robots.Directives
+    mode := basic.Conf.RobotsMode
+    if mode == robots.MODE_STRICT || mode == robots.MODE_RECORD {
+        directives = robots.Parse(response)
+    }
+    itemList, requestList, errs := ana.Analyze(schdl.router, response)
+    itemList, requestList = schdl.applyRobots(response, directives, itemList, requestList)
+    return itemList, requestList, errs
+}
+
+//处理页面上的robots指令(rel=nofollow, meta robots, X-Robots-Tag), directives为nil表示不处理
+//strict模式压制条目和链接, record模式只记录; 指令和压制情况都记录在对应Url的UrlInfo中
+func (schdl *Scheduler) applyRobots(response *basic.Response, directives *robots.Directives,
+    itemList []*basic.Item, requestList []*basic.Request) ([]*basic.Item, []*basic.Request) {
+    if directives == nil || directives.Empty() {
+        return itemList, requestList
+    }
+
+    mode := basic.Conf.RobotsMode
+    itemList, requestList, suppressed := directives.Filter(itemList, requestList, mode == robots.MODE_STRICT)
+    fingerprint := response.Fingerprint
+    if fingerprint == "" {
+        fingerprint = response.ReqUrl
+    }
+    if v, ok := schdl.urlMap.Load(fingerprint); ok {
+        info := v.(*basic.UrlInfo)
+        info.Robots = directives.String()
+        info.Suppressed = suppressed
+    }
+    if suppressed != "" {
+        log.Infof("Robots directives(%s) of %s. Suppressed: %s\n", directives.String(), response.ReqUrl, suppressed)
+    }
+    return itemList, requestList
+}
+
 //发送条目到通道管理器中的条目通道
 func (schdl *Scheduler) sendToItemChan(item basic.Item, moduleCode string) bool {
     if schdl.stopSign.Signed() {
@@ -125,17 +170,17 @@ func (schdl *Scheduler) getAnalyzerPool() basic.SpiderPool {
 //把请求存放到请求缓存。
 func (schdl *Scheduler) sendRequestToCache(request *basic.Request, mouduleCode, refUrl string) bool {
 
-    //消除#和/的干扰, 如有必要，则重建request
+    //消除#和/的干扰, 如有必要，则重建request(保留方法、请求头、Body和上下文信息)
     var req *basic.Request
     uurl := request.HttpReq().URL.String()
     uurl = strings.Split(uurl, "#")[0]
     uurl = strings.TrimRight(uurl, "/")
     if (uurl != request.HttpReq().URL.String()) { //
-        httpReq, err := http.NewRequest(http.MethodGet, uurl, nil)
+        newReq, err := request.CloneWithUrl(uurl)
         if err != nil {
             return false
         }
-        req = basic.NewRequest(httpReq, request.Depth())
+        req = newReq
     } else {
         req = request
     }
@@ -152,7 +197,9 @@ func (schdl *Scheduler) sendRequestToCache(request *basic.Request, mouduleCode,
     }
 
     //标记请求; 如果是首次请求, 则自增请求数量, 否则啥也不干
-    if _, loaded := schdl.urlMap.LoadOrStore(uurl, &basic.UrlInfo{
+    if _, loaded := schdl.urlMap.LoadOrStore(req.Fingerprint(), &basic.UrlInfo{
+        Url: uurl,
+        Method: req.Method(),
         Status: basic.URL_STATUS_DOWNLOADING,
         Ref:refUrl,
         Depth:req.Depth(),
@@ -185,7 +232,7 @@ func (schdl *Scheduler) filterInvalidRequest(request *basic.Request) bool {
     }
 
     //已经处理过的URL; 需要进一步判断不再处理
-    v, ok := schdl.urlMap.Load(request.HttpReq().URL.String())
+    v, ok := schdl.urlMap.Load(request.Fingerprint())
     if ok {
         //如果深度不匹配，则是非法的
         if v.(*basic.UrlInfo).Depth != request.Depth() {
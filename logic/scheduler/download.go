@@ -7,6 +7,7 @@ import (
     "github.com/hq-cml/spider-man/helper/log"
     "github.com/hq-cml/spider-man/logic/downloader"
     "sync/atomic"
+    "time"
 )
 
 /*
@@ -46,12 +47,34 @@ func (schdl *Scheduler) activateDownloaders() {
                 return
             }
 
+            //按host限速，拿到令牌之后再申请，避免等待令牌期间占用host的并发名额
+            //未获得许可的请求归还令牌，进入限速器中该host的等待队列，不阻塞其他host的请求
+            queued := req
+            if !schdl.throttle.Acquire(req.HttpReq().URL.Host, func() { schdl.downloadQueued(queued) }) {
+                if err := schdl.getDownloaderPool().Put(entity); err != nil {
+                    schdl.sendError(err, DOWNLOADER_CODE)
+                }
+                continue
+            }
+
             //每个请求都交给一个独立的goroutine来处理
             go schdl.download(req, entity)
         }
     }()
 }
 
+//排队的请求轮到时, 限速许可已经占用, 取得下载令牌后开始下载
+//拿不到令牌(比如调度器已停止)则归还许可
+func (schdl *Scheduler) downloadQueued(req basic.Request) {
+    entity, err := schdl.getDownloaderPool().Get()
+    if err != nil {
+        schdl.throttle.Release(req.HttpReq().URL.Host, 0, 0, nil)
+        schdl.sendError(err, DOWNLOADER_CODE)
+        return
+    }
+    go schdl.download(req, entity)
+}
+
 /*
  * 实际下载工作，下载goroutine的逻辑
  * 但是全部下载goroutine是受到下载器池子的约束的
@@ -68,6 +91,14 @@ func (schdl *Scheduler) download(request basic.Request, entity basic.SpiderEntit
         }
     }()
 
+    //注册延时归还限速许可，并将下载的结果反馈给限速器
+    var statusCode int
+    var downloadErr error
+    startTime := time.Now()
+    defer func() {
+        schdl.throttle.Release(request.HttpReq().URL.Host, time.Since(startTime), statusCode, downloadErr)
+    }()
+
     //注册延时归还令牌
     defer func() {
         err := schdl.getDownloaderPool().Put(entity)
@@ -86,16 +117,26 @@ func (schdl *Scheduler) download(request basic.Request, entity basic.SpiderEntit
     }
 
     //实施下载
-    v, ok := schdl.urlMap.Load(request.HttpReq().URL.String());
+    v, ok := schdl.urlMap.Load(request.Fingerprint());
     if !ok {
-        msg := fmt.Sprint("Can't find the url in urlMap:" + request.HttpReq().URL.String())
+        msg := fmt.Sprint("Can't find the url in urlMap:" + request.Fingerprint())
         schdl.sendError(errors.New(msg), DOWNLOADER_CODE)
         return
     }
     pInfo := v.(*basic.UrlInfo)
 
+    //按照profile设置请求头, Referer取自父Url
+    schdl.headerProfiler.Apply(request.HttpReq(), pInfo.Ref)
+
     moudleCode := generateModuleCode(DOWNLOADER_CODE, dl.Id())
+    startTime = time.Now()
     response, skip, msg, err := dl.Download(&request)
+    downloadErr = err
+    if se, ok := err.(*downloader.StatusError); ok {
+        statusCode = se.StatusCode
+    } else if err == nil {
+        statusCode = 200
+    }
     if err != nil {
         //如果是HEAD或者GET请求超时, 且未达到最大重试次数, 那么进行重试
         if msg == "head timeout" {
@@ -133,12 +174,36 @@ func (schdl *Scheduler) download(request basic.Request, entity basic.SpiderEntit
         pInfo.Status = basic.URL_STATUS_DONE
     }
 
+    //媒体文件无需分析, 直接产出记录文件信息的item
+    if response != nil && response.Media != nil {
+        schdl.sendToItemChan(genMediaItem(response, pInfo.Ref), moudleCode)
+        return
+    }
+
     //将resp放入
     if response != nil {
         schdl.sendToRespChan(*response, moudleCode)
     }
 }
 
+//生成媒体文件的item, 记录存储路径、大小、MIME以及来源页面
+func genMediaItem(response *basic.Response, ref string) basic.Item {
+    item := basic.Item{
+        "url":    response.ReqUrl,
+        "depth":  response.Depth,
+        "source": ref,
+        "path":   response.Media.Path,
+        "size":   response.Media.Size,
+        "mime":   response.Media.Mime,
+        "sha256": response.Media.Sha256,
+        "dedup":  response.Media.Dedup,
+    }
+    if len(response.Meta) > 0 {
+        item["meta"] = response.Meta
+    }
+    return item
+}
+
 //获取Pool管理器持有的下载器Pool。
 func (schdl *Scheduler) getDownloaderPool() basic.SpiderPool {
     p, err := schdl.poolManager.GetPool(DOWNLOADER_CODE)
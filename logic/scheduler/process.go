@@ -47,4 +47,26 @@ func (schdl *Scheduler) processOneItem(e basic.Item) {
             schdl.sendError(err, moudleCode)
         }
     }
-}
\ No newline at end of file
+
+    //插件批量写入的失败属于之前的某些条目, 每个单独报告, 不算作当前条目的错误
+    if schdl.closablePlugin != nil {
+        for _, err := range schdl.closablePlugin.TakeErrors() {
+            schdl.sendError(err, moudleCode)
+        }
+    }
+}
+
+//处理链的第一个节点: 条目依次交给每个输出, 输出的错误直接进入错误通道
+//处理链是快速失败的, 所以这里不返回错误, 一个输出出错不影响其他输出和插件的处理链
+//批量写入的失败属于之前的某些文档而不是当前条目, 每个文档单独报告
+func (schdl *Scheduler) processSinks(item basic.Item) (basic.Item, error) {
+    for _, s := range schdl.sinks {
+        if _, err := s.Process(item); err != nil {
+            schdl.sendError(err, PROCESS_CHAIN_CODE)
+        }
+        for _, err := range s.TakeErrors() {
+            schdl.sendError(err, PROCESS_CHAIN_CODE)
+        }
+    }
+    return item, nil
+}
@@ -1,7 +1,50 @@
 package scheduler
 
-import "testing"
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/log"
+	"github.com/hq-cml/spider-man/helper/robots"
+	"github.com/hq-cml/spider-man/logic/analyzer"
+	"testing"
+)
 
 func TestSched(t *testing.T) {
 	//TODO
-}
\ No newline at end of file
+}
+
+//robots和分析链上的分析函数共享同一次解析
+func TestAnalyzeParseOnce(t *testing.T) {
+	log.InitLog("", "debug")
+	basic.Conf = &basic.SpiderConf{RobotsMode: robots.MODE_RECORD}
+	defer func() { basic.Conf = nil }()
+
+	docs := map[*goquery.Document]bool{}
+	calls := 0
+	f := func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+		doc, err := httpResp.Document()
+		if err != nil {
+			return nil, nil, []error{err}
+		}
+		docs[doc] = true
+		calls++
+		return nil, nil, nil
+	}
+	router, err := analyzer.NewRouter([]basic.AnalyzeResponseFunc{f, f}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schdl := &Scheduler{router: router}
+
+	body := `<html><head><meta name="robots" content="noindex"></head><body><a href="/a">A</a></body></html>`
+	response := *basic.NewResponse([]byte(body), 0, "text/html; charset=utf-8", "http://www.360.cn/")
+	if _, _, errs := schdl.analyzeResponse(analyzer.NewAnalyzer(), &response); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	//分析之后的使用者拿到的也是同一个文档
+	doc, _ := response.Document()
+	docs[doc] = true
+	if calls != 2 || len(docs) != 1 {
+		t.Fatalf("Expect 1 parse, got %d documents in %d calls", len(docs), calls)
+	}
+}
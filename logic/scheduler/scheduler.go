@@ -8,7 +8,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/dnscache"
+	"github.com/hq-cml/spider-man/helper/filestore"
+	"github.com/hq-cml/spider-man/helper/httpcache"
 	"github.com/hq-cml/spider-man/helper/log"
+	"github.com/hq-cml/spider-man/helper/sink"
 	"github.com/hq-cml/spider-man/helper/util"
 	"github.com/hq-cml/spider-man/logic/analyzer"
 	"github.com/hq-cml/spider-man/logic/downloader"
@@ -17,6 +21,7 @@ import (
 	"github.com/hq-cml/spider-man/middleware/requestcache"
 	"github.com/hq-cml/spider-man/middleware/stopsign"
 	"github.com/hq-cml/spider-man/middleware/pool"
+	"github.com/hq-cml/spider-man/middleware/throttle"
 	"net/http"
 	"sync/atomic"
 	"time"
@@ -125,13 +130,45 @@ func (schdl *Scheduler) initScheduler(
 	//middleware生成: 池管理器
 	schdl.poolManager = pool.NewPoolManager()
 
+	//DNS缓存和静态host映射，替换httpClient的拨号函数
+	if basic.Conf.DnsCache || len(basic.Conf.StaticHosts) > 0 {
+		ttl := time.Duration(basic.Conf.DnsTtl) * time.Second
+		negativeTtl := time.Duration(basic.Conf.DnsNegativeTtl) * time.Second
+		if !basic.Conf.DnsCache {
+			ttl, negativeTtl = 0, 0 //只使用静态映射
+		}
+		schdl.resolver = dnscache.NewResolver(ttl, negativeTtl, basic.Conf.DnsMaxConcurrent, basic.Conf.StaticHosts)
+		var ok bool
+		if httpClient, ok = schdl.resolver.WrapClient(httpClient); !ok {
+			log.Warnln("The transport of httpClient is not *http.Transport, DNS cache is disabled!")
+			schdl.resolver = nil
+		}
+	}
+
+	//HTTP缓存，对任意插件的httpClient生效
+	if httpClient, err = httpcache.WrapClient(httpClient, basic.Conf.HttpCacheMode, basic.Conf.HttpCacheDir, basic.Conf.MaxBodySize); err != nil {
+		return err
+	}
+
+	//媒体文件存储
+	var mediaStore *filestore.Store
+	if basic.Conf.SaveMedia {
+		if mediaStore, err = filestore.NewStore(basic.Conf.MediaDir); err != nil {
+			return err
+		}
+	}
+
 	//生成并注册downloader池子
 	if dp, err := pool.NewCommonPool(
 		basic.Conf.DownloaderPoolSize,
 		func() basic.SpiderEntity {
 			//这里是一个闭包, NewDownloader有一个参数client
 			//所有的donwloader都公用同一个httpClient, 这符合golang的推荐用法
-			return downloader.NewDownloader(httpClient)
+			dl := downloader.NewDownloader(httpClient)
+			if mediaStore != nil {
+				dl.SetMediaStore(mediaStore)
+			}
+			return dl
 		},
 	); err != nil {
 		err = errors.New(fmt.Sprintf("Occur error when gen downloader pool: %s\n", err))
@@ -165,10 +202,38 @@ func (schdl *Scheduler) initScheduler(
 	//middleware生成；requestCache
 	schdl.requestCache = requestcache.NewRequestCache()
 
+	//请求头设置器
+	schdl.headerProfiler = downloader.NewHeaderProfiler(
+		basic.Conf.HeaderMode, basic.Conf.RefererPolicy, basic.Conf.HeaderProfiles)
+
+	//middleware生成；throttle
+	if basic.Conf.AutoThrottle {
+		schdl.throttle = throttle.NewThrottle(
+			basic.Conf.TargetConcurrency,
+			time.Duration(basic.Conf.ThrottleStartDelay) * time.Millisecond,
+			time.Duration(basic.Conf.ThrottleMinDelay) * time.Millisecond,
+			time.Duration(basic.Conf.ThrottleMaxDelay) * time.Millisecond,
+			basic.Conf.HostMaxConcurrency)
+	}
+
 	//请求分析器
 	schdl.analyzeFuncs = respAnalyzers
 
-	//processChain生成
+	//分析函数路由，配置中的规则优先于插件定义的规则
+	rules := append([]basic.AnalyzeRule{}, basic.Conf.AnalyzeRules...)
+	rules = append(rules, schdl.analyzeRules...)
+	if schdl.router, err = analyzer.NewRouter(respAnalyzers, schdl.namedFuncs, rules); err != nil {
+		return err
+	}
+	schdl.router.SetTimeout(time.Duration(basic.Conf.AnalyzeTimeout) * time.Millisecond)
+	if err = schdl.router.SetDispatch(basic.Conf.MimeDispatch); err != nil {
+		return err
+	}
+
+	//processChain生成, 条目输出放在最前面
+	if len(schdl.sinks) > 0 {
+		itemProcessors = append([]basic.ProcessItemFunc{schdl.processSinks}, itemProcessors...)
+	}
 	schdl.processChain = processchain.NewProcessChain(itemProcessors)
 
 	//初始化已请求的URL的字典
@@ -286,6 +351,56 @@ func (schdl *Scheduler)Start(
 	return nil
 }
 
+//注册按名字路由的分析函数, 需要在Start之前调用
+//请求通过SetCallback指定名字, 对应的响应只由该分析函数分析
+func (schdl *Scheduler) RegisterNamedAnalyzers(namedFuncs map[string]basic.AnalyzeResponseFunc) error {
+	if atomic.LoadUint32(&schdl.running) == RUNNING_STATUS_RUNNING {
+		return errors.New("The scheduler has been started!")
+	}
+	for name, f := range namedFuncs {
+		if name == "" || f == nil {
+			return errors.New(fmt.Sprintf("The named analyzer '%s' is invalid!", name))
+		}
+	}
+	schdl.namedFuncs = namedFuncs
+	return nil
+}
+
+//注册插件定义的分析规则, 需要在Start之前调用
+//规则中按名字引用的分析函数, 需要先通过RegisterNamedAnalyzers注册
+func (schdl *Scheduler) RegisterAnalyzeRules(rules []basic.AnalyzeRule) error {
+	if atomic.LoadUint32(&schdl.running) == RUNNING_STATUS_RUNNING {
+		return errors.New("The scheduler has been started!")
+	}
+	schdl.analyzeRules = rules
+	return nil
+}
+
+//注册条目输出, 需要在Start之前调用; 关闭由调用方负责
+//每个输出各自写入, 出错只进入错误通道, 不影响其他输出和插件的处理链
+func (schdl *Scheduler) RegisterSinks(sinks []sink.ItemSink) error {
+	if atomic.LoadUint32(&schdl.running) == RUNNING_STATUS_RUNNING {
+		return errors.New("The scheduler has been started!")
+	}
+	for i, s := range sinks {
+		if s == nil {
+			return errors.New(fmt.Sprint("Invalid sink:", i))
+		}
+	}
+	schdl.sinks = sinks
+	return nil
+}
+
+//注册持有资源的插件, 需要在Start之前调用; 关闭由调用方负责
+//调度器负责把插件异步产生的错误放入错误通道, 把插件的摘要放入summary
+func (schdl *Scheduler) RegisterClosablePlugin(cp basic.ClosablePlugin) error {
+	if atomic.LoadUint32(&schdl.running) == RUNNING_STATUS_RUNNING {
+		return errors.New("The scheduler has been started!")
+	}
+	schdl.closablePlugin = cp
+	return nil
+}
+
 //Stop方法，停止调度器的运行。所有处理模块执行的流程都会被中止
 func (schdl *Scheduler)Stop() bool {
 	if atomic.LoadUint32(&schdl.running) != RUNNING_STATUS_RUNNING {
@@ -309,7 +424,8 @@ func (schdl *Scheduler) IsIdle() bool {
 	idleDownloaderPool := schdl.getDownloaderPool().Used() == 0
 	idleAnalyzerPool := schdl.getAnalyzerPool().Used() == 0
 	idleItemPipeline := schdl.processChain.ProcessingNumber() == 0
-	if idleDownloaderPool && idleAnalyzerPool && idleItemPipeline {
+	idleThrottle := schdl.throttle.Waiting() == 0 //在限速器中排队的请求, 还没有开始下载
+	if idleDownloaderPool && idleAnalyzerPool && idleItemPipeline && idleThrottle {
 		return true
 	}
 	return false
@@ -1,11 +1,16 @@
 package scheduler
 
 import (
+	"github.com/hq-cml/spider-man/logic/analyzer"
+	"github.com/hq-cml/spider-man/helper/dnscache"
+	"github.com/hq-cml/spider-man/helper/sink"
+	"github.com/hq-cml/spider-man/logic/downloader"
 	"github.com/hq-cml/spider-man/logic/processchain"
 	chanman "github.com/hq-cml/spider-man/middleware/channel"
 	"github.com/hq-cml/spider-man/middleware/requestcache"
 	"github.com/hq-cml/spider-man/middleware/stopsign"
 	"github.com/hq-cml/spider-man/middleware/pool"
+	"github.com/hq-cml/spider-man/middleware/throttle"
 	"sync"
 	"github.com/hq-cml/spider-man/basic"
 	"time"
@@ -25,6 +30,14 @@ type Scheduler struct {
 	processChain   *processchain.ProcessChain     // Item处理链条。
 	requestCache   *requestcache.RequestCache     // Request缓存
 	analyzeFuncs   []basic.AnalyzeResponseFunc    // Item处理器
+	namedFuncs     map[string]basic.AnalyzeResponseFunc // 按名字注册的分析函数
+	analyzeRules   []basic.AnalyzeRule            // 插件定义的分析规则
+	router         *analyzer.Router               // 分析函数路由
+	headerProfiler *downloader.HeaderProfiler     // 请求头设置器
+	throttle       *throttle.Throttle             // 按host自适应限速器, 未开启则为nil
+	resolver       *dnscache.Resolver             // DNS缓存解析器, 未开启则为nil
+	sinks          []sink.ItemSink                // 条目输出, 在插件的处理链之前各自独立写入
+	closablePlugin basic.ClosablePlugin           // 持有资源的插件, 没有则为nil
 	urlMap         sync.Map              		  // 已请求的URL的字典。
 	urlCnt         uint64                         // sync.Map长度
 	running        uint32                         // 运行标记。0表示未运行，1表示已运行，2表示已停止。
@@ -44,6 +57,11 @@ type SchedSummary struct {
 	urlCount            uint64 // 已请求的URL的计数。
 	urlDetail           string // 已请求的URL的详细信息。
 	stopSignSummary     string // 停止信号的摘要信息。
+	throttleSummary     string // 限速器的摘要信息。
+	dnsSummary          string // DNS缓存的摘要信息。
+	analyzerSummary     string // 各分析函数的统计信息。
+	sinkSummary         string // 条目输出的摘要信息。
+	pluginSummary       string // 插件的摘要信息。
 
 	downloaderCnt       uint64 // 已启动的downloader协程数量
 	analyzerCnt         uint64 // 已启动的analyzer协程数量
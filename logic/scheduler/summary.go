@@ -38,6 +38,17 @@ func NewSchedSummary(schdl *Scheduler, prefix string, detail bool) *SchedSummary
 		urlDetail = "\n"
 	}
 	prefix = "    * " + prefix
+	sinkSummary := ""
+	for _, s := range schdl.sinks {
+		sinkSummary += s.Summary(prefix)
+	}
+	if sinkSummary == "" {
+		sinkSummary = prefix + "None\n"
+	}
+	pluginSummary := prefix + "None\n"
+	if schdl.closablePlugin != nil {
+		pluginSummary = schdl.closablePlugin.Summary(prefix)
+	}
 	return &SchedSummary {
 		prefix:              prefix,
 		running:             schdl.running,
@@ -49,6 +60,11 @@ func NewSchedSummary(schdl *Scheduler, prefix string, detail bool) *SchedSummary
 		urlCount:            atomic.LoadUint64(&schdl.urlCnt),
 		urlDetail:           urlDetail,
 		stopSignSummary:     schdl.stopSign.Summary(prefix),
+		throttleSummary:     schdl.throttle.Summary(prefix),
+		dnsSummary:          schdl.resolver.Summary(prefix),
+		analyzerSummary:     schdl.router.Stats().Summary(prefix),
+		sinkSummary:         sinkSummary,
+		pluginSummary:       pluginSummary,
 		analyzerCnt:   		 atomic.LoadUint64(&schdl.analyzerCnt),
 		downloaderCnt:   	 atomic.LoadUint64(&schdl.downloaderCnt),
 	}
@@ -87,6 +103,11 @@ func (ss *SchedSummary) GetSummary(detail bool) string {
 		"    * RequestCache:\n%s" +
 		"    * ProcessChain:\n%s" +
 		"    * StopSigin:\n%s" +
+		"    * Throttle:\n%s" +
+		"    * DnsCache:\n%s" +
+		"    * Analyzers:\n%s" +
+		"    * Sinks:\n%s" +
+		"    * Plugin:\n%s" +
 		"    * Urls(%d): %s\n" +
 		"    *  \n" +
 		"    *********************************************************************\n "
@@ -107,6 +128,11 @@ func (ss *SchedSummary) GetSummary(detail bool) string {
 		ss.reqCacheSummary,
 		ss.processChainSummary,
 		ss.stopSignSummary,
+		ss.throttleSummary,
+		ss.dnsSummary,
+		ss.analyzerSummary,
+		ss.sinkSummary,
+		ss.pluginSummary,
 		ss.urlCount, d)
 }
 
@@ -170,7 +196,15 @@ func (schdl *Scheduler)GetRuntimeInfo(detail string) string {
 	var headCount int64
 	var getCount int64
 	var readCount int64
+	var bufRobots bytes.Buffer
+	var robotsCount int64
 	schdl.urlMap.Range(func(k, v interface{}) bool { //闭包
+		//robots指令与下载状态无关, 单独统计
+		if info := v.(*basic.UrlInfo); info.Robots != "" {
+			robotsCount ++
+			bufRobots.WriteString("    " + k.(string) + ". Robots: " + info.Robots + ". Suppressed: " + info.Suppressed)
+			bufRobots.WriteByte('\n')
+		}
 		switch v.(*basic.UrlInfo).Status {
 		case basic.URL_STATUS_DOWNLOADING:
 			downloadCount ++
@@ -221,6 +255,7 @@ func (schdl *Scheduler)GetRuntimeInfo(detail string) string {
 	result.WriteString("    跳过         = " + strconv.FormatInt(skipCount, 10) + "\n")
 	result.WriteString("    下载中       = " + strconv.FormatInt(downloadCount, 10) + "\n" )
 	result.WriteString("    完成         = " + strconv.FormatInt(doneCount, 10) + "\n" )
+	result.WriteString("    Robots指令   = " + strconv.FormatInt(robotsCount, 10) + "\n" )
 
 
 
@@ -238,6 +273,7 @@ func (schdl *Scheduler)GetRuntimeInfo(detail string) string {
 		result.WriteString("跳过(" + strconv.FormatInt(skipCount, 10) + ")：\n" + bufSkip.String() + "\n--------------------\n\n")
 		result.WriteString("下载中(" + strconv.FormatInt(downloadCount, 10) + ")：\n" + bufDownloading.String() + "\n--------------------\n\n")
 		result.WriteString("完成(" + strconv.FormatInt(doneCount, 10) + ")：\n" + bufDone.String() + "\n--------------------\n\n")
+		result.WriteString("Robots指令(" + strconv.FormatInt(robotsCount, 10) + ")：\n" + bufRobots.String() + "\n--------------------\n\n")
 	}
 
 	return result.String()
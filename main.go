@@ -6,6 +6,7 @@ import (
 	"github.com/hq-cml/spider-man/basic"
 	"github.com/hq-cml/spider-man/helper/config"
 	"github.com/hq-cml/spider-man/helper/log"
+	"github.com/hq-cml/spider-man/helper/sink"
 	"github.com/hq-cml/spider-man/logic/scheduler"
 	"github.com/hq-cml/spider-man/plugin"
 	"net/http"
@@ -41,10 +42,17 @@ func main() {
 	}
 	basic.Conf = conf
 
+	//base插件的列表页翻页
+	var pager *plugin.Pager
+	if conf.Pagination {
+		pager = &plugin.Pager{MaxPages: conf.MaxPages}
+	}
+
 	//插件列表, 加载所有的支持插件
 	var plugins = map[string]basic.SpiderPlugin{
-		"base": plugin.NewBaseSpider(*userData),
+		"base": plugin.NewBaseSpider(*userData).(*plugin.BaseSpider).SetMainContent(conf.MainContent).SetMetadata(conf.Metadata).SetPager(pager).SetLinkSources(conf.LinkSources...),
 		"engine": plugin.NewEngineSpider(*userData),
+		"rules": plugin.NewRulesSpider(*userData),
 		//....
 	}
 
@@ -71,6 +79,63 @@ func main() {
 
 	//创建并启动调度器
 	schdl := scheduler.NewScheduler()
+	//内置的非HTML文档分析函数(供[dispatch]分派表引用)和插件按名字注册的分析函数, 同名的以插件为准
+	namedFuncs := plugin.DocumentAnalyzers()
+	if cp, ok := spiderPlugin.(basic.CallbackPlugin); ok {
+		for name, f := range cp.GenNamedAnalysers() {
+			namedFuncs[name] = f
+		}
+	}
+	if err := schdl.RegisterNamedAnalyzers(namedFuncs); err != nil {
+		panic("Scheduler register named analyzers error:" + err.Error())
+	}
+	if rp, ok := spiderPlugin.(basic.RulePlugin); ok {
+		if err := schdl.RegisterAnalyzeRules(rp.GenAnalyzeRules()); err != nil {
+			panic("Scheduler register analyze rules error:" + err.Error())
+		}
+	}
+	//条目输出到文件或者Elasticsearch, 由调度器在插件的处理链之前写入
+	//每个输出各自独立, 出错只进入错误通道, 不影响其他输出和插件的处理链
+	sinks := []sink.ItemSink{}
+	for _, format := range conf.Sinks {
+		var s sink.ItemSink
+		var err error
+		if format == sink.FORMAT_ELASTIC {
+			s, err = sink.NewElastic(sink.ElasticOptions{
+				Url:           conf.ElasticUrl,
+				Index:         conf.ElasticIndex,
+				IdField:       conf.ElasticIdField,
+				BatchSize:     conf.ElasticBatchSize,
+				FlushInterval: time.Duration(conf.ElasticInterval) * time.Millisecond,
+				MaxRetries:    conf.ElasticMaxRetries,
+				Backoff:       time.Duration(conf.ElasticBackoff) * time.Millisecond,
+				Client:        &http.Client{Timeout: time.Duration(conf.ElasticTimeout) * time.Second},
+			})
+		} else {
+			s, err = sink.New(sink.Options{
+				Format:   format,
+				Dir:      conf.SinkDir,
+				Prefix:   conf.SinkPrefix,
+				Fields:   conf.SinkFields,
+				MaxSize:  conf.SinkMaxSize,
+				Interval: time.Duration(conf.SinkInterval) * time.Second,
+				Gzip:     conf.SinkGzip,
+				Fsync:    conf.SinkFsync,
+			})
+		}
+		if err != nil {
+			panic("Create sink error:" + err.Error())
+		}
+		sinks = append(sinks, s)
+	}
+	if err := schdl.RegisterSinks(sinks); err != nil {
+		panic("Scheduler register sinks error:" + err.Error())
+	}
+	closablePlugin, _ := spiderPlugin.(basic.ClosablePlugin)
+	if err := schdl.RegisterClosablePlugin(closablePlugin); err != nil {
+		panic("Scheduler register plugin error:" + err.Error())
+	}
+
 	if err := schdl.Start (
 		spiderPlugin.GenHttpClient(),
 		spiderPlugin.GenResponseAnalysers(),
@@ -106,6 +171,19 @@ func main() {
 	}
 	cnt := loopWait(schdl, intervalNs, conf.MaxIdleCount)
 
+	//关闭输出: 文件刷新缓冲并fsync, Elasticsearch写入剩余的文档
+	//调度器已经停止, 剩余的失败只能写入日志; 写入统计见最终报告
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			log.Errln("Close sink error:", err.Error())
+		}
+	}
+	if closablePlugin != nil {
+		if err := closablePlugin.Close(); err != nil {
+			log.Errln("Close plugin error:", err.Error())
+		}
+	}
+
 	//程序结束, 生成最终报告
 	summary := scheduler.NewSchedSummary(schdl, "    ", true)
 	log.Infoln("The Spider Finish. check times:", cnt)
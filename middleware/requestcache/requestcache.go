@@ -4,10 +4,12 @@ package requestcache
  * 请求缓冲
  * 用一个带锁保护的slice实现缓冲的效果
  * golang源码中, 很多的buf都是用slice来实现的缓冲效果
+ * slice按照请求优先级从高到低排列, 同优先级的请求保持先进先出
  */
 import (
 	"fmt"
 	"github.com/hq-cml/spider-man/basic"
+	"sort"
 	"sync"
 )
 
@@ -47,7 +49,18 @@ func (rc *RequestCache) Put(req *basic.Request) bool {
 	}
 	rc.mutex.Lock()
 	defer rc.mutex.Unlock()
-	rc.cache = append(rc.cache, req)
+	//找到第一个优先级低于req的位置插入, 绝大多数请求优先级相同, 直接追加在末尾
+	n := len(rc.cache)
+	if n == 0 || rc.cache[n-1].Priority() >= req.Priority() {
+		rc.cache = append(rc.cache, req)
+		return true
+	}
+	i := sort.Search(n, func(i int) bool {
+		return rc.cache[i].Priority() < req.Priority()
+	})
+	rc.cache = append(rc.cache, nil)
+	copy(rc.cache[i+1:], rc.cache[i:])
+	rc.cache[i] = req
 	return true
 }
 
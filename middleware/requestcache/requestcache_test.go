@@ -36,3 +36,20 @@ func TestReqcache(t *testing.T) {
 
 	t.Log("End")
 }
+
+func TestReqcachePriority(t *testing.T) {
+	rc := NewRequestCache()
+	rc.Put(basic.NewRequest(nil, 0))
+	rc.Put(basic.NewRequest(nil, 1).SetPriority(10))
+	rc.Put(basic.NewRequest(nil, 2))
+	rc.Put(basic.NewRequest(nil, 3).SetPriority(10))
+	rc.Put(basic.NewRequest(nil, 4).SetPriority(5))
+
+	//优先级高的先出, 同优先级先进先出
+	expect := []int{1, 3, 4, 0, 2}
+	for _, d := range expect {
+		if r := rc.Get(); r.Depth() != d {
+			t.Fatal("Wrong order, expect:", d, "got:", r.Depth())
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package throttle
+
+/*
+ * 按host自适应限速
+ * 固定的延时对快站点太慢，对慢站点又太激进，所以根据观测到的下载延时动态调整每个host的请求间隔和并发度:
+ * 1. 正常响应: 目标间隔 = 延时 / 目标并发度, 当前间隔向目标间隔靠拢; 连续健康则逐步提高并发度
+ * 2. 429/503或者连接错误: 间隔加倍, 并发度减半, 快速退避
+ *
+ * 限速器位于下载器池子之前, 未获得许可的请求进入host的等待队列, 而不是阻塞调度:
+ * 按先来后到, 在Release或者该host的下一个请求时间到达时占用许可并发出, 不会回到请求缓存的队尾
+ */
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//单个host的限速状态
+type hostSlot struct {
+	delay       time.Duration //当前请求间隔
+	concurrency int           //当前允许的并发度
+	active      int           //正在下载的数量
+	nextTime    time.Time     //下一个请求最早的发出时间
+	latency     time.Duration //平均下载延时
+	healthy     int           //连续健康响应的次数
+	backoffCnt  uint64        //退避次数
+	queue       []func()      //等待许可的请求, 先来后到
+	timer       *time.Timer   //等待nextTime到达的定时器, 每个host最多一个
+}
+
+//限速器实现类型
+type Throttle struct {
+	targetConcurrency float64       //目标并发度
+	startDelay        time.Duration //初始间隔
+	minDelay          time.Duration //最小间隔
+	maxDelay          time.Duration //最大间隔
+	maxConcurrency    int           //单host最大并发度
+	slots             map[string]*hostSlot
+	mutex             sync.Mutex
+	waiting           int64 //排队中以及获得许可但还没有开始下载的请求数量
+}
+
+//New
+func NewThrottle(targetConcurrency float64, startDelay, minDelay, maxDelay time.Duration, maxConcurrency int) *Throttle {
+	if targetConcurrency <= 0 {
+		targetConcurrency = 1
+	}
+	if maxConcurrency <= 0 {
+		maxConcurrency = 1
+	}
+	if maxDelay < minDelay {
+		maxDelay = minDelay
+	}
+	return &Throttle{
+		targetConcurrency: targetConcurrency,
+		startDelay:        startDelay,
+		minDelay:          minDelay,
+		maxDelay:          maxDelay,
+		maxConcurrency:    maxConcurrency,
+		slots:             make(map[string]*hostSlot),
+	}
+}
+
+//获取host的状态, 不存在则新建。调用方需持有锁
+func (t *Throttle) getSlot(host string) *hostSlot {
+	s, ok := t.slots[host]
+	if !ok {
+		s = &hostSlot{
+			delay:       t.clamp(t.startDelay),
+			concurrency: 1,
+		}
+		t.slots[host] = s
+	}
+	return s
+}
+
+func (t *Throttle) clamp(d time.Duration) time.Duration {
+	if d < t.minDelay {
+		return t.minDelay
+	}
+	if d > t.maxDelay {
+		return t.maxDelay
+	}
+	return d
+}
+
+//申请向host发出一个请求: 有许可则直接占用, 返回true
+//否则fn进入host的等待队列, 返回false; 轮到它时许可已经占用, fn在独立的goroutine中调用
+//fn返回之前都计入Waiting, fn中应该启动下载(或者在无法下载时Release)
+func (t *Throttle) Acquire(host string, fn func()) bool {
+	if t == nil {
+		return true
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s := t.getSlot(host)
+	//已经有排队的请求, 后来的不能插队
+	if len(s.queue) == 0 && t.ready(s, time.Now()) {
+		t.take(s)
+		return true
+	}
+	atomic.AddInt64(&t.waiting, 1)
+	s.queue = append(s.queue, fn)
+	t.dispatch(host, s)
+	return false
+}
+
+//host当前是否可以发出请求。调用方需持有锁
+func (t *Throttle) ready(s *hostSlot, now time.Time) bool {
+	return s.active < s.concurrency && !now.Before(s.nextTime)
+}
+
+//占用一个许可。调用方需持有锁
+func (t *Throttle) take(s *hostSlot) {
+	s.active++
+	s.nextTime = time.Now().Add(s.delay)
+}
+
+//按顺序放行host等待队列中的请求。调用方需持有锁
+//并发度已满的等待Release, 间隔未到的设置定时器在nextTime再放行
+func (t *Throttle) dispatch(host string, s *hostSlot) {
+	for len(s.queue) > 0 {
+		now := time.Now()
+		if s.active >= s.concurrency {
+			return
+		}
+		if now.Before(s.nextTime) {
+			if s.timer == nil {
+				s.timer = time.AfterFunc(s.nextTime.Sub(now), func() {
+					t.mutex.Lock()
+					defer t.mutex.Unlock()
+					s.timer = nil
+					t.dispatch(host, s)
+				})
+			}
+			return
+		}
+		fn := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		t.take(s)
+		go func() {
+			defer atomic.AddInt64(&t.waiting, -1)
+			fn()
+		}()
+	}
+}
+
+//请求结束后归还许可, 并根据结果调整限速
+//statusCode为0表示没有拿到响应(连接错误, 超时等)
+func (t *Throttle) Release(host string, latency time.Duration, statusCode int, err error) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s := t.getSlot(host)
+	if s.active > 0 {
+		s.active--
+	}
+
+	switch {
+	case statusCode == 429 || statusCode == 503 || (err != nil && statusCode == 0):
+		//快速退避
+		backoff := s.delay * 2
+		if backoff < t.startDelay {
+			backoff = t.startDelay
+		}
+		s.delay = t.clamp(backoff)
+		s.concurrency = s.concurrency / 2
+		if s.concurrency < 1 {
+			s.concurrency = 1
+		}
+		s.healthy = 0
+		s.backoffCnt++
+		s.nextTime = time.Now().Add(s.delay)
+
+	case err == nil && statusCode != 0:
+		//健康的响应, 间隔向目标靠拢
+		if s.latency == 0 {
+			s.latency = latency
+		} else {
+			s.latency = (s.latency + latency) / 2
+		}
+		target := time.Duration(float64(latency) / t.targetConcurrency)
+		s.delay = t.clamp((s.delay + target) / 2)
+		s.healthy++
+		//一整轮并发都是健康的, 则提高并发度
+		if s.healthy >= s.concurrency*2 && s.concurrency < t.maxConcurrency {
+			s.concurrency++
+			s.healthy = 0
+		}
+
+	default:
+		//其他的错误(比如404)和站点健康度无关, 只是不再加速
+		//statusCode为0且没有错误, 说明请求根本没有发出, 同样不做调整
+		s.healthy = 0
+	}
+
+	//空出了许可, 放行排队的请求
+	t.dispatch(host, s)
+}
+
+//排队中以及获得许可但还没有开始下载的请求数量
+func (t *Throttle) Waiting() int64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&t.waiting)
+}
+
+//获取host当前的间隔和并发度
+func (t *Throttle) HostState(host string) (time.Duration, int, bool) {
+	if t == nil {
+		return 0, 0, false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	s, ok := t.slots[host]
+	if !ok {
+		return 0, 0, false
+	}
+	return s.delay, s.concurrency, true
+}
+
+//摘要信息, 按host列出当前的间隔和并发度
+func (t *Throttle) Summary(prefix string) string {
+	if t == nil {
+		return prefix + "Disabled\n"
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	hosts := make([]string, 0, len(t.slots))
+	for h := range t.slots {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+
+	var buff bytes.Buffer
+	buff.WriteString(fmt.Sprintf(prefix+"Hosts: %d, Waiting: %d\n", len(hosts), atomic.LoadInt64(&t.waiting)))
+	for _, h := range hosts {
+		s := t.slots[h]
+		buff.WriteString(fmt.Sprintf(prefix+"%s Delay: %s, Concurrency: %d, Active: %d, Queued: %d, Latency: %s, Backoff: %d\n",
+			h, s.delay, s.concurrency, s.active, len(s.queue), s.latency, s.backoffCnt))
+	}
+	return buff.String()
+}
@@ -0,0 +1,89 @@
+package throttle
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	th := NewThrottle(2, time.Second, 0, 10*time.Second, 4)
+
+	//首个请求直接放行, 之后同host的请求排队
+	if !th.Acquire("a.com", nil) {
+		t.Fatal("First request should be allowed")
+	}
+	queued := make(chan bool, 1)
+	if th.Acquire("a.com", func() { queued <- true }) || th.Waiting() != 1 {
+		t.Fatal("Second request should wait")
+	}
+	//不同host互不影响
+	if !th.Acquire("b.com", nil) {
+		t.Fatal("Other host should be allowed")
+	}
+
+	//健康的响应会缩短间隔
+	th.Release("a.com", 200*time.Millisecond, 200, nil)
+	delay, _, _ := th.HostState("a.com")
+	if delay >= time.Second {
+		t.Fatal("Delay should decrease:", delay)
+	}
+	//间隔到了, 排队的请求被放行
+	select {
+	case <-queued:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Queued request should be released")
+	}
+	th.Release("a.com", 200*time.Millisecond, 200, nil)
+
+	//503快速退避
+	th.Release("b.com", time.Second, 503, errors.New("Unsupported status code 503"))
+	delay, concurrency, _ := th.HostState("b.com")
+	if delay != 2*time.Second || concurrency != 1 {
+		t.Fatal("Should backoff:", delay, concurrency)
+	}
+
+	//持续健康则并发度提高
+	for i := 0; i < 10; i++ {
+		th.Release("a.com", 10*time.Millisecond, 200, nil)
+	}
+	if _, concurrency, _ := th.HostState("a.com"); concurrency <= 1 {
+		t.Fatal("Concurrency should increase:", concurrency)
+	}
+	t.Log(th.Summary("    "))
+}
+
+func TestThrottleQueue(t *testing.T) {
+	th := NewThrottle(1, 0, 0, time.Second, 1)
+	if !th.Acquire("a.com", nil) {
+		t.Fatal("First request should be allowed")
+	}
+
+	//并发度已满, 按先来后到排队, Release时逐个放行
+	order := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		n := i
+		if th.Acquire("a.com", func() { order <- n }) {
+			t.Fatal("Should be queued:", i)
+		}
+	}
+	if th.Waiting() != 3 {
+		t.Fatal("Wrong waiting:", th.Waiting())
+	}
+	for i := 0; i < 3; i++ {
+		th.Release("a.com", time.Millisecond, 0, nil)
+		if n := <-order; n != i {
+			t.Fatal("Wrong order:", n, i)
+		}
+	}
+	th.Release("a.com", time.Millisecond, 0, nil)
+	for i := 0; i < 100 && th.Waiting() != 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if th.Waiting() != 0 {
+		t.Fatal("Should not be waiting:", th.Waiting())
+	}
+	if !th.Acquire("a.com", nil) {
+		t.Fatal("Should be allowed after the queue is drained")
+	}
+}
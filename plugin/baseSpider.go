@@ -3,19 +3,22 @@ package plugin
 import (
 	"errors"
 	"fmt"
-	"github.com/PuerkitoBio/goquery"
 	"github.com/hq-cml/spider-man/basic"
-	"io"
+	"github.com/hq-cml/spider-man/helper/keyword"
+	"github.com/hq-cml/spider-man/helper/readability"
 	"net/http"
 	"net/url"
-	"strings"
 	"time"
 )
 
 //*BaseSpider实现SpiderPlugin接口
 //一个最基础的插件，爬虫爬取季过后，直接进行关键字搜索出结果打印
 type BaseSpider struct {
-	userData interface{}
+	userData    interface{}
+	mainContent bool     //只保留正文, 去掉导航、页脚、脚本等, 关键字也只在正文中匹配
+	metadata    bool     //item中加上页面元数据和结构化数据
+	pager       *Pager   //列表页翻页, nil表示下一页和普通链接一样处理
+	sources     []string //跟进的链接来源, 为空表示全部跟进
 }
 
 //New
@@ -25,6 +28,31 @@ func NewBaseSpider(v interface{}) basic.SpiderPlugin {
 	}
 }
 
+//开启正文抽取, item的body只包含正文, 并增加title, byline, date
+func (b *BaseSpider) SetMainContent(on bool) *BaseSpider {
+	b.mainContent = on
+	return b
+}
+
+//开启元数据抽取, item中增加metadata
+func (b *BaseSpider) SetMetadata(on bool) *BaseSpider {
+	b.metadata = on
+	return b
+}
+
+//开启列表页翻页, 下一页不计入深度, 由pager.MaxPages限制页数
+func (b *BaseSpider) SetPager(pager *Pager) *BaseSpider {
+	b.pager = pager
+	return b
+}
+
+//指定跟进的链接来源(LINK_SRC_*), 不指定则全部跟进; 未知的来源panic
+func (b *BaseSpider) SetLinkSources(sources ...string) *BaseSpider {
+	checkLinkSources(sources)
+	b.sources = sources
+	return b
+}
+
 //生成HTTP客户端
 func (b *BaseSpider) GenHttpClient() *http.Client {
 	//客户端必须设置一个整体超时时间，否则随着时间推移，会把downloader全部卡死
@@ -39,13 +67,44 @@ func (b *BaseSpider) GenHttpClient() *http.Client {
 }
 
 //获得响应解析函数的序列
+//关键字查询在这里才编译, 因为main中会创建全部插件, 但只有被选中的插件才需要
 func (b *BaseSpider) GenResponseAnalysers() []basic.AnalyzeResponseFunc {
+	var query *keyword.Query
+	if b.userData != nil {
+		q, ok := b.userData.(string)
+		if !ok {
+			panic(fmt.Sprintf("Unsupported userData=%v", b.userData))
+		}
+		if q != "" {
+			var err error
+			if query, err = keyword.Compile(q); err != nil {
+				panic("Compile keyword query error:" + err.Error())
+			}
+		}
+	}
+
+	//闭包
+	var analyzer basic.AnalyzeResponseFunc = func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+		//RSS/Atom只跟进文章链接
+		if IsFeed(httpResp) {
+			return AnalyzeFeed(httpResp)
+		}
+		items, reqs, errs := parseForATag(httpResp, query, b.mainContent, b.sources)
+		//下一页放在最前面, 同深度
+		if doc, err := httpResp.Document(); b.pager != nil && err == nil {
+			next, err := b.pager.NextRequest(httpResp, doc)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			reqs = withNextPage(reqs, next)
+		}
+		return items, reqs, errs
+	}
+	if b.metadata {
+		analyzer = WithMetadata(analyzer)
+	}
 	analyzers := []basic.AnalyzeResponseFunc {
-		//闭包
-		func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
-			items, reqs, errs :=  parseForATag(httpResp, b.userData)
-			return items, reqs, errs
-		},
+		analyzer,
 	}
 	return analyzers
 }
@@ -63,52 +122,56 @@ func (b *BaseSpider) GenItemProcessors() []basic.ProcessItemFunc {
  * 分析出“A”标签,作为新的request
  * 分析出满足条件的结果作为item
  */
-func parseForATag(httpResp *basic.Response, userData interface{}) ([]*basic.Item, []*basic.Request, []error) {
+func parseForATag(httpResp *basic.Response, query *keyword.Query, mainContent bool, sources []string) ([]*basic.Item, []*basic.Request, []error) {
 
 	//对响应做一些处理
 	reqUrl, err := url.Parse(httpResp.ReqUrl) //记录下响应的请求（防止相对URL的问题）
 	if err != nil {
 		return nil, nil, []error{err}
 	}
-	var httpRespBody io.Reader
 
 	itemList := []*basic.Item{}
 	requestList := []*basic.Request{}
 	errs := make([]error, 0)
 
-	//网页编码智能判断, 非utf8 => utf8
-	httpRespBody, contentType, err := convertCharset(httpResp)
-	if err != nil {
-		return nil, nil, []error{err}
-	}
-
-	//开始解析
-	doc, err := goquery.NewDocumentFromReader(httpRespBody)
+	//网页编码智能判断(非utf8 => utf8)并解析, 分析链上只做一次
+	doc, err := httpResp.Document()
 	if err != nil {
 		errs = append(errs, err)
 		return nil, nil, errs
 	}
+	contentType := httpResp.Charset()
 
-	//查找“A”标签并提取链接地址
-	requestList, errs = findATagFromDoc(httpResp, reqUrl, doc)
+	//提取链接地址, 页面上的feed(来源feed)和页面同深度
+	requestList, errs = findATagFromDoc(httpResp, doc, sources...)
 
 	//关键字查找, 记录符合条件的body作为item
-	//如果用户数据非空，则进行匹配校验，否则直接入item队列
+	//如果查询非空，则进行匹配校验，并记录命中的词、次数和上下文，否则直接入item队列
 	imap := make(map[string]interface{})
 	imap["url"] = reqUrl.String()
 	imap["charset"] = contentType
 	imap["depth"] = httpResp.Depth
-	body := doc.Find("body").Text()
+	var body string
+	if mainContent {
+		article := readability.Extract(doc)
+		body = article.Text
+		imap["title"] = article.Title
+		imap["byline"] = article.Byline
+		imap["date"] = article.Date
+	} else {
+		body = doc.Find("body").Text()
+	}
 	imap["body"] = body
 	item := basic.Item(imap)
-	if userData != nil {
-		searchContent, ok := userData.(string)
-		if !ok {
-			err := errors.New(fmt.Sprintf("Unsupported userData=%v", userData))
-			return nil, nil, []error{err}
-		}
-
-		if strings.Contains(body, searchContent) {
+	if query != nil {
+		result := query.Match(body)
+		if result.Matched {
+			item["keyword"] = map[string]interface{}{
+				"query":    query.String(),
+				"terms":    result.Terms,
+				"hits":     result.Hits,
+				"snippets": result.Snippets,
+			}
 			itemList = append(itemList, &item)
 		}
 	} else {
@@ -131,7 +194,11 @@ func processBaseItem(item basic.Item) (result basic.Item, err error) {
 		result[k] = v
 	}
 
-	fmt.Println("深度: ", result["depth"], "结果：", result["url"])
+	if kw, ok := result["keyword"].(map[string]interface{}); ok {
+		fmt.Println("深度: ", result["depth"], "结果：", result["url"], "命中：", kw["hits"])
+	} else {
+		fmt.Println("深度: ", result["depth"], "结果：", result["url"])
+	}
 	return
 }
 
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"github.com/hq-cml/spider-man/helper/log"
 	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/keyword"
 	"io/ioutil"
 	"fmt"
 )
@@ -39,7 +40,7 @@ func TestParseATag(t *testing.T) {
 		resp.Header.Get("content-type"),
 		resp.Request.URL.String(),
 	)
-	items, reqs, errors := parseForATag(httpResp, nil)
+	items, reqs, errors := parseForATag(httpResp, nil, false, nil)
 
 	t.Logf("分析出的URL列表(%d):\n", len(reqs))
 	m := map[string]bool{}
@@ -103,4 +104,50 @@ func TestHttpRequest(t *testing.T) {
 	}
 
 	t.Log(firstHttpReq.URL.Scheme)
-}
\ No newline at end of file
+}
+func TestParseMainContent(t *testing.T) {
+	log.InitLog("", "debug")
+	page := `<html><head><title>新闻标题 - 站点</title></head><body>
+		<div class="menu"><a href="/a.html">张朝阳专栏</a><a href="/b.html">首页</a></div>
+		<div class="content">
+			<p>这是一段足够长的正文内容，用来测试正文抽取，里面提到了周鸿祎，还有一些别的内容。</p>
+			<p>这是第二段足够长的正文内容，继续测试正文抽取的效果，看看导航能不能被去掉。</p>
+		</div></body></html>`
+	resp := basic.NewResponse([]byte(page), 0, "text/html; charset=utf-8", "http://www.360.cn/n/1.html")
+
+	//导航中的关键字不算命中
+	items, reqs, _ := parseForATag(resp, keyword.MustCompile("张朝阳"), true, nil)
+	if len(items) != 0 || len(reqs) != 2 {
+		t.Fatalf("Expect no item and 2 requests, got %d %d", len(items), len(reqs))
+	}
+	items, _, _ = parseForATag(resp, keyword.MustCompile("张朝阳"), false, nil)
+	if len(items) != 1 {
+		t.Fatalf("Expect 1 item without main content, got %d", len(items))
+	}
+
+	items, _, _ = parseForATag(resp, keyword.MustCompile("周鸿祎"), true, nil)
+	if len(items) != 1 || (*items[0])["title"] != "新闻标题" || strings.Contains((*items[0])["body"].(string), "首页") {
+		t.Fatalf("Wrong item: %v", items)
+	}
+}
+
+func TestParseKeyword(t *testing.T) {
+	log.InitLog("", "debug")
+	page := `<html><body><p>３６０公司董事长周鸿祎今天发布了新产品，周鸿祎表示……</p><p>广告</p></body></html>`
+	resp := basic.NewResponse([]byte(page), 0, "text/html; charset=utf-8", "http://www.360.cn/n/1.html")
+
+	items, _, _ := parseForATag(resp, keyword.MustCompile(`(360 OR 奇虎) 周鸿祎`), false, nil)
+	if len(items) != 1 {
+		t.Fatalf("Expect 1 item, got %d", len(items))
+	}
+	kw := (*items[0])["keyword"].(map[string]interface{})
+	hits := kw["hits"].(map[string]int)
+	if hits["360"] != 1 || hits["周鸿祎"] != 2 || len(kw["snippets"].(map[string][]string)["周鸿祎"]) != 2 {
+		t.Fatalf("Wrong keyword result: %v", kw)
+	}
+
+	items, _, _ = parseForATag(resp, keyword.MustCompile(`周鸿祎 -广告`), false, nil)
+	if len(items) != 0 {
+		t.Fatalf("Expect no item, got %d", len(items))
+	}
+}
@@ -2,89 +2,73 @@ package plugin
 
 import (
 	"github.com/hq-cml/spider-man/basic"
-	"io"
 	"strings"
-	"bytes"
-	"bufio"
-	"golang.org/x/net/html/charset"
-	"golang.org/x/text/transform"
-	"github.com/hq-cml/spider-man/helper/log"
 	"github.com/PuerkitoBio/goquery"
 	"net/url"
 	"net/http"
 )
 
-//识别编码并转换到utf8
-func convertCharset(httpResp *basic.Response) (httpRespBody io.Reader, orgCharset string, err error) {
-	//先尝试从http header的content-type中直接猜测
-	//contentType := httpResp.Header.Get("content-type")
-	switch {
-	case strings.Contains(strings.ToLower(httpResp.ContentType), "utf8"),
-		strings.Contains(strings.ToLower(httpResp.ContentType), "utf-8"):
-		httpRespBody = bytes.NewBuffer(httpResp.Body)
-		return httpRespBody, "utf8", nil
+//从document当中扫出全部的链接(<a>, <area>, <link>, <frame>, 脚本等, 见ExtractLinks)，按来源筛选后拼成新请求
+//相对链接按照<base href>(如果有)补全, 不指定来源则全部跟进
+func findATagFromDoc(httpResp *basic.Response, doc *goquery.Document, sources ...string) ([]*basic.Request, []error) {
+	links, errs := ExtractLinks(httpResp, doc)
+	requestList, reqErrs := LinkRequests(httpResp, links, sources...)
+	return requestList, append(errs, reqErrs...)
+}
+
+//检查链接来源, 未知的来源panic
+func checkLinkSources(sources []string) {
+	for _, src := range sources {
+		if !linkSources[src] {
+			panic("Unknown link source: " + src)
+		}
 	}
+}
 
-	//利用golang.org/x/net/html/charset包提供的方法开始猜
-	buf, err := bufio.NewReader(bytes.NewBuffer(httpResp.Body)).Peek(1024)
+//文档的基准Url: <base href>指定的地址, 没有则是请求Url
+func docBaseUrl(reqUrl *url.URL, doc *goquery.Document) *url.URL {
+	href, exists := doc.Find("base[href]").First().Attr("href")
+	if !exists {
+		return reqUrl
+	}
+	baseUrl, err := url.Parse(strings.TrimSpace(href))
 	if err != nil {
-		panic(err)
+		return reqUrl
 	}
-	encoding, charSet, _ := charset.DetermineEncoding(buf, httpResp.ContentType)
+	return reqUrl.ResolveReference(baseUrl)
+}
 
-	//利用http.DetectContentType进行猜测
-	switch {
-	case strings.Contains(strings.ToLower(charSet), "utf8"),
-		strings.Contains(strings.ToLower(charSet), "utf-8"):
-		httpRespBody = bytes.NewBuffer(httpResp.Body)
-		log.Debugln("Determine charset: utf8. Url:", httpResp.ReqUrl, ". Content-Type:", httpResp.ContentType)
-		return httpRespBody, "utf8", nil
+//从选择集中按selector扫出全部链接, 链接取自attr属性, 然后拼成新请求
+func findLinksFromDoc(httpResp *basic.Response, reqUrl *url.URL,
+	sel *goquery.Selection, selector, attr string) ([]*basic.Request, []error) {
 
-	default:
-		//需要转码
-		log.Debugln("Guess charset:", charSet,". Url:", httpResp.ReqUrl, ". Content-Type:", httpResp.ContentType)
-		utf8Body := transform.NewReader(bytes.NewBuffer(httpResp.Body), encoding.NewDecoder())
-		return utf8Body, charSet, nil
-	}
+	hrefs := []string{}
+	sel.Find(selector).Each(func(index int, sel *goquery.Selection) {
+		if href, exists := sel.Attr(attr); exists {
+			hrefs = append(hrefs, href)
+		}
+	})
+	return genRequestsFromLinks(httpResp, reqUrl, hrefs)
 }
 
-//从document当中扫出全部的链接Tag，然后拼成新请求
-func findATagFromDoc(httpResp *basic.Response, reqUrl *url.URL,
-	doc *goquery.Document) ([]*basic.Request, []error) {
+//将链接地址过滤、补全、去重后, 拼成新请求
+func genRequestsFromLinks(httpResp *basic.Response, reqUrl *url.URL,
+	hrefs []string) ([]*basic.Request, []error) {
 
 	errs := make([]error, 0)
 	uniqUrl := map[string]bool{}
 	requestList := []*basic.Request{}
-	//查找“A”标签并提取链接地址
-	doc.Find("a").Each(func(index int, sel *goquery.Selection) {
-		href, exists := sel.Attr("href")
-		// 前期过滤
-		if !exists || href == "" || href == "#" || href == "/" {
-			return
-		}
-		href = strings.TrimSpace(href)
-		lowerHref := strings.ToLower(href)
-		// 暂不支持对Javascript代码的解析。
-		if href == "" || strings.HasPrefix(lowerHref, "javascript") {
-			return
-		}
-
-		aUrl, err := url.Parse(href)
+	for _, href := range hrefs {
+		uurl, ok, err := normalizeLink(reqUrl, href)
 		if err != nil {
 			errs = append(errs, err)
-			return
+			continue
 		}
-		//保证是绝对URL(如果当前是相对URL，则将当前URL拼接到主URL上，保证是绝对URL)
-		if !aUrl.IsAbs() {
-			aUrl = reqUrl.ResolveReference(aUrl)
+		if !ok {
+			continue
 		}
-
-		//去除本页面内部#干扰和重复的url
-		uurl := aUrl.String()
-		uurl = strings.Split(uurl, "#")[0]
-		uurl = strings.TrimRight(uurl, "/")
 		if _, ok := uniqUrl[uurl]; ok {
-			return
+			continue
 		}
 		uniqUrl[uurl] = true
 		httpReq, err := http.NewRequest(http.MethodGet, uurl, nil)
@@ -95,7 +79,40 @@ func findATagFromDoc(httpResp *basic.Response, reqUrl *url.URL,
 			req := basic.NewRequest(httpReq, httpResp.Depth + 1)
 			requestList = append(requestList, req)
 		}
-	})
+	}
 
 	return requestList, errs
-}
\ No newline at end of file
+}
+
+//过滤并补全链接地址, 返回绝对Url; 返回false表示应该忽略这个链接
+func normalizeLink(baseUrl *url.URL, href string) (string, bool, error) {
+	// 前期过滤
+	href = strings.TrimSpace(href)
+	if href == "" || href == "#" || href == "/" {
+		return "", false, nil
+	}
+	lowerHref := strings.ToLower(href)
+	// Javascript代码中的链接由extractJsLinks单独处理
+	if strings.HasPrefix(lowerHref, "javascript") || strings.HasPrefix(lowerHref, "mailto:") ||
+		strings.HasPrefix(lowerHref, "tel:") || strings.HasPrefix(lowerHref, "data:") {
+		return "", false, nil
+	}
+
+	aUrl, err := url.Parse(href)
+	if err != nil {
+		return "", false, err
+	}
+	//保证是绝对URL(如果当前是相对URL，则将当前URL拼接到主URL上，保证是绝对URL)
+	if !aUrl.IsAbs() {
+		aUrl = baseUrl.ResolveReference(aUrl)
+	}
+	if aUrl.Scheme != "http" && aUrl.Scheme != "https" {
+		return "", false, nil
+	}
+
+	//去除本页面内部#干扰
+	uurl := aUrl.String()
+	uurl = strings.Split(uurl, "#")[0]
+	uurl = strings.TrimRight(uurl, "/")
+	return uurl, true, nil
+}
@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/hq-cml/spider-man/basic"
+	"golang.org/x/net/html/charset"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/*
+ * 非HTML文档的内置分析函数, 按名字注册, 由[dispatch]分派表按MIME类型引用
+ * text: 纯文本, 整个文本作为一个条目, 文本中的绝对Url作为新请求
+ * csv:  每行一个条目, 第一行作为表头
+ * xml:  RSS/Atom按feed处理, sitemap跟进<loc>, 其他XML整体作为一个条目(根元素和文本)
+ * json: 整个JSON作为一个条目
+ */
+
+//内置分析函数的名字
+const (
+	ANALYZER_TEXT = "text"
+	ANALYZER_CSV  = "csv"
+	ANALYZER_XML  = "xml"
+	ANALYZER_JSON = "json"
+)
+
+//内置的非HTML文档分析函数, main中和插件的分析函数一起注册
+func DocumentAnalyzers() map[string]basic.AnalyzeResponseFunc {
+	return map[string]basic.AnalyzeResponseFunc{
+		ANALYZER_TEXT: AnalyzeText,
+		ANALYZER_CSV:  AnalyzeCsv,
+		ANALYZER_XML:  AnalyzeXml,
+		ANALYZER_JSON: AnalyzeJson,
+	}
+}
+
+//文档条目的公共字段
+func documentItem(httpResp *basic.Response) basic.Item {
+	return basic.Item{
+		"url":     httpResp.ReqUrl,
+		"depth":   httpResp.Depth,
+		"mime":    httpResp.MimeType,
+		"charset": httpResp.Charset(),
+	}
+}
+
+//纯文本: 转码后的全文作为一个条目, 文本中的绝对Url作为新请求
+func AnalyzeText(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+	body, _, err := httpResp.Utf8Body()
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	text := string(body)
+	item := documentItem(httpResp)
+	item["body"] = text
+
+	reqs, errs := textLinkRequests(httpResp, scriptUrlRe.FindAllString(text, -1))
+	return []*basic.Item{&item}, reqs, errs
+}
+
+//文本中的Url生成请求, 深度加1
+func textLinkRequests(httpResp *basic.Response, urls []string) ([]*basic.Request, []error) {
+	reqUrl, err := url.Parse(httpResp.ReqUrl)
+	if err != nil {
+		return nil, []error{err}
+	}
+	links := []*Link{}
+	errs := make([]error, 0)
+	for _, u := range urls {
+		n, ok, err := normalizeLink(reqUrl, strings.TrimRight(strings.TrimSpace(u), ".,;:"))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			links = append(links, &Link{Url: n, Source: LINK_SRC_TEXT})
+		}
+	}
+	reqs, linkErrs := LinkRequests(httpResp, links)
+	return reqs, append(errs, linkErrs...)
+}
+
+//CSV: 第一行作为表头, 之后每行一个条目, 字段按表头命名(表头为空或者不够的, 命名为col序号)
+//分隔符按第一行猜测: 逗号, 分号或者制表符
+func AnalyzeCsv(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+	body, _, err := httpResp.Utf8Body()
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf")) //BOM
+
+	reader := csv.NewReader(bytes.NewReader(body))
+	reader.Comma = csvDelimiter(body)
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	itemList := []*basic.Item{}
+	var header []string
+	for row := 0; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return itemList, nil, []error{errors.New(fmt.Sprintf("Parse csv error: %s. (ReqUrl=%s)", err, httpResp.ReqUrl))}
+		}
+		if header == nil {
+			header = record
+			continue
+		}
+		fields := make(map[string]string, len(record))
+		for i, v := range record {
+			name := ""
+			if i < len(header) {
+				name = strings.TrimSpace(header[i])
+			}
+			if name == "" {
+				name = "col" + strconv.Itoa(i)
+			}
+			fields[name] = v
+		}
+		item := documentItem(httpResp)
+		item["row"] = row
+		item["fields"] = fields
+		itemList = append(itemList, &item)
+	}
+	return itemList, nil, nil
+}
+
+//按第一行中出现最多的分隔符猜测
+func csvDelimiter(body []byte) rune {
+	line := body
+	if i := bytes.IndexByte(body, '\n'); i >= 0 {
+		line = body[:i]
+	}
+	delimiter, max := ',', bytes.Count(line, []byte{','})
+	for _, d := range []rune{';', '\t'} {
+		if n := bytes.Count(line, []byte{byte(d)}); n > max {
+			delimiter, max = d, n
+		}
+	}
+	return delimiter
+}
+
+//XML: RSS/Atom按feed处理; sitemap(urlset, sitemapindex)跟进<loc>, 不产出条目; 其他XML产出一个条目, 包括根元素和文本
+func AnalyzeXml(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+	if IsFeed(httpResp) {
+		return AnalyzeFeed(httpResp)
+	}
+
+	//按XML声明中的encoding转码
+	d := xml.NewDecoder(bytes.NewReader(httpResp.Body))
+	d.CharsetReader = charset.NewReaderLabel
+	d.Strict = false
+
+	root := ""
+	texts := []string{}
+	locs := []string{}
+	inLoc := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, []error{errors.New(fmt.Sprintf("Parse xml error: %s. (ReqUrl=%s)", err, httpResp.ReqUrl))}
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root == "" {
+				root = t.Name.Local
+			}
+			inLoc = t.Name.Local == "loc"
+		case xml.EndElement:
+			inLoc = false
+		case xml.CharData:
+			s := strings.TrimSpace(string(t))
+			if s == "" {
+				continue
+			}
+			if inLoc {
+				locs = append(locs, s)
+			}
+			texts = append(texts, s)
+		}
+	}
+
+	if root == "urlset" || root == "sitemapindex" {
+		reqs, errs := textLinkRequests(httpResp, locs)
+		return nil, reqs, errs
+	}
+	item := documentItem(httpResp)
+	item["root"] = root
+	item["body"] = strings.Join(texts, " ")
+	return []*basic.Item{&item}, nil, nil
+}
+
+//JSON: 解析结果整体作为一个条目的data
+func AnalyzeJson(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+	data, err := httpResp.JSON()
+	if err != nil {
+		return nil, nil, []error{errors.New(fmt.Sprintf("Parse json error: %s. (ReqUrl=%s)", err, httpResp.ReqUrl))}
+	}
+	item := documentItem(httpResp)
+	item["data"] = data
+	return []*basic.Item{&item}, nil, nil
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"testing"
+)
+
+func TestAnalyzeText(t *testing.T) {
+	body := "更多内容见 http://www.360.cn/n/1.html, 以及https://www.360.cn/about.html。\n"
+	resp := basic.NewResponse([]byte(body), 1, "text/plain; charset=utf-8", "http://www.360.cn/a.txt")
+	items, reqs, errs := AnalyzeText(resp)
+	if len(items) != 1 || len(errs) != 0 || (*items[0])["body"] != body || (*items[0])["mime"] != "text/plain" {
+		t.Fatal("Wrong item:", items, errs)
+	}
+	if len(reqs) != 2 || reqs[0].HttpReq().URL.String() != "http://www.360.cn/n/1.html" || reqs[0].Depth() != 2 {
+		t.Fatal("Wrong requests:", reqs)
+	}
+}
+
+func TestAnalyzeCsv(t *testing.T) {
+	body := "\xef\xbb\xbfname;age;\n老周;50;x\n\"a;b\";1\n"
+	resp := basic.NewResponse([]byte(body), 0, "text/csv", "http://www.360.cn/a.csv")
+	items, _, errs := AnalyzeCsv(resp)
+	if len(items) != 2 || len(errs) != 0 {
+		t.Fatal("Wrong items:", items, errs)
+	}
+	fields := (*items[0])["fields"].(map[string]string)
+	if fields["name"] != "老周" || fields["age"] != "50" || fields["col2"] != "x" || (*items[0])["row"] != 1 {
+		t.Fatal("Wrong fields:", fields)
+	}
+	if fields := (*items[1])["fields"].(map[string]string); fields["name"] != "a;b" {
+		t.Fatal("Wrong quoted field:", fields)
+	}
+}
+
+func TestAnalyzeXml(t *testing.T) {
+	sitemap := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url><loc>http://www.360.cn/n/1.html</loc><lastmod>2019-01-01</lastmod></url>
+	<url><loc>/n/2.html</loc></url>
+</urlset>`
+	resp := basic.NewResponse([]byte(sitemap), 0, "application/xml", "http://www.360.cn/sitemap.xml")
+	items, reqs, errs := AnalyzeXml(resp)
+	if len(items) != 0 || len(reqs) != 2 || len(errs) != 0 || reqs[1].HttpReq().URL.String() != "http://www.360.cn/n/2.html" {
+		t.Fatal("Wrong sitemap:", items, reqs, errs)
+	}
+
+	//gbk编码的"你好"
+	doc := append([]byte(`<?xml version="1.0" encoding="gbk"?><note><to>`), 0xc4, 0xe3, 0xba, 0xc3)
+	doc = append(doc, []byte(`</to><body>hello</body></note>`)...)
+	resp = basic.NewResponse(doc, 0, "text/xml", "http://www.360.cn/note.xml")
+	items, reqs, errs = AnalyzeXml(resp)
+	if len(items) != 1 || len(reqs) != 0 || (*items[0])["root"] != "note" || (*items[0])["body"] != "你好 hello" {
+		t.Fatal("Wrong xml:", items, reqs, errs)
+	}
+
+	//feed按feed处理
+	feed := `<?xml version="1.0"?><rss><channel><item><link>http://www.360.cn/n/3.html</link></item></channel></rss>`
+	resp = basic.NewResponse([]byte(feed), 0, "text/xml", "http://www.360.cn/rss.xml")
+	if _, reqs, _ = AnalyzeXml(resp); len(reqs) != 1 {
+		t.Fatal("Wrong feed:", reqs)
+	}
+}
+
+func TestAnalyzeJson(t *testing.T) {
+	resp := basic.NewResponse([]byte(`{"id": 12345678901234567890}`), 0, "application/json", "http://www.360.cn/api")
+	items, _, errs := AnalyzeJson(resp)
+	if len(items) != 1 || len(errs) != 0 {
+		t.Fatal("Wrong items:", items, errs)
+	}
+	if data := (*items[0])["data"].(map[string]interface{}); data["id"].(interface{ String() string }).String() != "12345678901234567890" {
+		t.Fatal("Wrong data:", data)
+	}
+	resp = basic.NewResponse([]byte(`{`), 0, "application/json", "http://www.360.cn/api")
+	if _, _, errs := AnalyzeJson(resp); len(errs) != 1 {
+		t.Fatal("Should be error")
+	}
+}
@@ -2,25 +2,23 @@ package plugin
 
 import (
 	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/sink"
 	"net/http"
 	"time"
 	"net/url"
-	"io"
-	"github.com/PuerkitoBio/goquery"
 	"strings"
 	"errors"
 	"fmt"
-	"encoding/json"
-	"bytes"
-	"io/ioutil"
 )
 
 /*
  * *EngineSpider实现SpiderPlugin接口
  * 此插件与搜索引擎Spider-Engine打通，爬虫爬取结果=>灌入Spider-Engine
+ * 用户数据为Spider-Engine的地址, 库名、表名和批量写入的参数见配置的[engine]段
  */
 type EngineSpider struct {
 	userData interface{}
+	engine   *sink.Engine
 }
 
 //New
@@ -47,19 +45,74 @@ func (b *EngineSpider) GenResponseAnalysers() []basic.AnalyzeResponseFunc {
 	}
 }
 
+//获得分析规则
+//360站点的页面都交给parse360NewsPage分析, 但只跟进新闻页
+func (b *EngineSpider) GenAnalyzeRules() []basic.AnalyzeRule {
+	return []basic.AnalyzeRule{
+		{
+			Name:          "360news",
+			AnalyzeFuncs:  []basic.AnalyzeResponseFunc{parse360NewsPage},
+			Follow:        true,
+			FollowPattern: `^http://www\.360\.cn/n/`,
+		},
+	}
+}
+
 // 获得条目处理链的序列。
+// 先批量写入Spider-Engine, 写入失败的文档由调度器通过TakeErrors取出, 进入错误通道
 func (b *EngineSpider) GenItemProcessors() []basic.ProcessItemFunc {
+	addr, ok := b.userData.(string)
+	if !ok {
+		panic("Wrong type")
+	}
+	if b.engine == nil {
+		conf := basic.Conf
+		engine, err := sink.NewEngine(sink.EngineOptions{
+			Addr:          addr,
+			Db:            conf.EngineDb,
+			Table:         conf.EngineTable,
+			KeyField:      conf.EngineKeyField,
+			BatchSize:     conf.EngineBatchSize,
+			FlushInterval: time.Duration(conf.EngineInterval) * time.Millisecond,
+			Concurrency:   conf.EngineConcurrency,
+			MaxRetries:    conf.EngineMaxRetries,
+			Backoff:       time.Duration(conf.EngineBackoff) * time.Millisecond,
+			Timeout:       time.Duration(conf.EngineTimeout) * time.Second,
+		})
+		if err != nil {
+			panic("Create engine sink error:" + err.Error())
+		}
+		b.engine = engine
+	}
 	return []basic.ProcessItemFunc{
-		//闭包
-		func(item basic.Item) (basic.Item, error) {
-			addr, ok := b.userData.(string)
-			if !ok {
-				panic("Wrong type")
-			}
-			return processEngineItem(item, addr)
-		},
+		b.engine.Process,
+		processEngineItem,
+	}
+}
+
+//*EngineSpider实现ClosablePlugin接口
+//写入剩余的条目
+func (b *EngineSpider) Close() error {
+	if b.engine == nil {
+		return nil
+	}
+	return b.engine.Close()
+}
 
+//写入失败的文档, 每个错误带有文档的主键
+func (b *EngineSpider) TakeErrors() []error {
+	if b.engine == nil {
+		return nil
 	}
+	return b.engine.TakeErrors()
+}
+
+//写入的摘要
+func (b *EngineSpider) Summary(prefix string) string {
+	if b.engine == nil {
+		return prefix + "None\n"
+	}
+	return b.engine.Summary(prefix)
 }
 
 // 页面分析, 通过分析360新闻页面的Dom元素，爬取规则自然也就完成了
@@ -73,36 +126,21 @@ func parse360NewsPage(httpResp *basic.Response) ([]*basic.Item, []*basic.Request
 	if err != nil {
 		return nil, nil, []error{err}
 	}
-	var httpRespBody io.Reader
 
 	itemList := []*basic.Item{}
 	requestList := []*basic.Request{}
-	requests := []*basic.Request{}
 	errs := make([]error, 0)
 
-	//网页编码智能判断, 非utf8 => utf8
-	httpRespBody, contentType, err := convertCharset(httpResp)
-	if err != nil {
-		return nil, nil, []error{err}
-	}
-
-	//开始解析
-	doc, err := goquery.NewDocumentFromReader(httpRespBody)
+	//网页编码智能判断(非utf8 => utf8)并解析, 分析链上只做一次
+	doc, err := httpResp.Document()
 	if err != nil {
 		errs = append(errs, err)
 		return nil, nil, errs
 	}
+	contentType := httpResp.Charset()
 
-	//查找“A”标签并提取链接地址
-	requestList, errs = findATagFromDoc(httpResp, reqUrl, doc)
-
-
-	//过滤掉不是新闻业的url
-	for _, u := range requestList {
-		if strings.Index(u.HttpReq().URL.String(), "http://www.360.cn/n/") == 0 {
-			requests = append(requests, u)
-		}
-	}
+	//提取全部来源的链接地址, 只跟进新闻页由分析规则保证
+	requestList, errs = findATagFromDoc(httpResp, doc)
 
 	//根据360新闻业的Dom结构，抽取出关键数据
 	content := strings.TrimRight(strings.TrimLeft(doc.Find(".article-content").Find(".content-text").Text(), " \n"), " \n")
@@ -127,77 +165,16 @@ func parse360NewsPage(httpResp *basic.Response) ([]*basic.Item, []*basic.Request
 		itemList = append(itemList, &item)
 	}
 
-	return itemList, requests, errs
+	return itemList, requestList, errs
 }
 
 // 条目处理函数
-// 发送到Spider-Engine
-func processEngineItem(item basic.Item, engineAddr string) (result basic.Item, err error) {
+// 条目已经由处理链的上一环写入Spider-Engine, 这里只输出
+func processEngineItem(item basic.Item) (result basic.Item, err error) {
 	if item == nil {
 		return nil, errors.New("Invalid item!")
 	}
-
-	//生成结果
-	result = make(map[string]interface{})
-	for k, v := range item {
-		result[k] = v
-	}
-
-	//将结果灌入spider-engine引擎
-	key, ok := result["id"].(string)
-	if !ok {
-		return nil, errors.New("Need primary key!")
-	}
-	postOneNews(result, engineAddr, "sp_db", "360news", key)
-	fmt.Println("深度: ", result["depth"], "结果：", result["url"], "标题：", result["title"])
+	fmt.Println("深度: ", item["depth"], "结果：", item["url"], "标题：", item["title"])
 
 	return nil, nil
 }
-
-type EngineResult struct {
-	Code int       `json: "code"`
-	Data string    `json: "data"`
-}
-
-//向Spider-Engine发送一条新闻
-func postOneNews(item basic.Item, engineAddr, db, table, key string) error {
-	bytesData, err := json.Marshal(item)
-	if err != nil {
-		return err
-	}
-	reader := bytes.NewReader(bytesData)
-
-	//新建request
-	addr := fmt.Sprintf("http://%s/%s/%s/%s", engineAddr, db, table, key)
-	request, err := http.NewRequest("POST", addr, reader)
-	if err != nil {
-		return err
-	}
-	request.Header.Set("Content-Type", "application/json;charset=UTF-8")
-
-	//发送
-	client := http.Client{}
-	resp, err := client.Do(request)
-	if err != nil {
-		return err
-	}
-
-	//等待响应
-	respBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-
-	r := EngineResult{}
-	err = json.Unmarshal(respBytes, &r)
-	if err != nil {
-		return err
-	}
-
-	fmt.Println(string(respBytes))
-	if r.Code != 0 {
-		return errors.New(r.Data)
-	}
-
-	return nil
-}
\ No newline at end of file
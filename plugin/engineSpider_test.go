@@ -61,11 +61,5 @@ func TestParse360NewsPage(t *testing.T) {
 		t.Log(err)
 	}
 
-	//发送数据到spider-engine
-	//if len(items) > 0 {
-	//	item := items[0]
-	//	t.Log("数据发送：", postOneNews(*item, "http://192.168.110.133:9528/sp_db/360news/10759"))
-	//}
-
 }
 
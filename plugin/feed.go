@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/feed"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ * RSS/Atom
+ * 1. DiscoverFeeds: 从页面的<link rel=alternate type=application/rss+xml>中发现feed
+ * 2. IsFeed, ParseFeed: 判断响应是否是feed, 解析feed(同一个响应只解析一次)
+ * 3. AnalyzeFeed: 解析feed, 每个entry的链接作为文章请求, entry信息(标题、时间、作者等)放在请求上下文的"feed"中
+ *    文章页分析出的item, 由框架把上下文放到item的"meta"中, 也可以用FeedEntry取出
+ * feed和页面是同一内容的不同表示, feed请求和页面同深度, 文章请求深度加1
+ */
+
+//请求上下文中feed entry信息的key
+const META_FEED = "feed"
+
+//feed请求的Accept
+const feedAccept = "application/rss+xml, application/atom+xml, application/xml;q=0.9, text/xml;q=0.8"
+
+//<link rel=alternate>中表示feed的type
+var feedLinkTypes = map[string]bool{
+	"application/rss+xml":  true,
+	"application/atom+xml": true,
+	"application/rdf+xml":  true,
+}
+
+//是否是指向feed的<link>
+func isFeedLink(rel, typ string) bool {
+	if !feedLinkTypes[strings.ToLower(strings.TrimSpace(typ))] {
+		return false
+	}
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == "alternate" {
+			return true
+		}
+	}
+	return false
+}
+
+//是否是RSS/Atom响应: Content-Type是feed专用的类型, 或者是通用的XML类型且根元素是rss/feed
+func IsFeed(httpResp *basic.Response) bool {
+	switch httpResp.MimeType {
+	case "application/rss+xml", "application/atom+xml", "application/rdf+xml":
+		return true
+	case "text/xml", "application/xml":
+		return feed.Sniff(httpResp.Body)
+	}
+	return false
+}
+
+//解析RSS/Atom, 多个分析函数共享同一个解析结果, 相对链接按请求Url补全
+func ParseFeed(httpResp *basic.Response) (*feed.Feed, error) {
+	v, err := httpResp.CachedFeed(func() (interface{}, error) {
+		return feed.Parse(httpResp.Body, httpResp.ReqUrl)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*feed.Feed), nil
+}
+
+//从页面中发现feed, 生成feed请求
+func DiscoverFeeds(httpResp *basic.Response, doc *goquery.Document) ([]*basic.Request, []error) {
+	reqUrl, err := url.Parse(httpResp.ReqUrl)
+	if err != nil {
+		return nil, []error{err}
+	}
+	baseUrl := docBaseUrl(reqUrl, doc)
+
+	errs := make([]error, 0)
+	uniqUrl := map[string]bool{}
+	requestList := []*basic.Request{}
+	doc.Find("link[rel][type][href]").Each(func(i int, sel *goquery.Selection) {
+		rel, _ := sel.Attr("rel")
+		typ, _ := sel.Attr("type")
+		if !isFeedLink(rel, typ) {
+			return
+		}
+		href, _ := sel.Attr("href")
+		u, ok, err := normalizeLink(baseUrl, href)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if !ok || uniqUrl[u] {
+			return
+		}
+		uniqUrl[u] = true
+		req, err := feedRequest(httpResp, u)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		requestList = append(requestList, req)
+	})
+	return requestList, errs
+}
+
+//feed请求, 和当前页面同深度
+func feedRequest(httpResp *basic.Response, feedUrl string) (*basic.Request, error) {
+	httpReq, err := http.NewRequest(http.MethodGet, feedUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", feedAccept)
+	return basic.NewRequest(httpReq, httpResp.Depth), nil
+}
+
+//feed分析函数: 非feed响应直接返回, feed的每个entry生成一个文章请求
+func AnalyzeFeed(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+	if !IsFeed(httpResp) {
+		return nil, nil, nil
+	}
+	f, err := ParseFeed(httpResp)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	errs := make([]error, 0)
+	uniqUrl := map[string]bool{}
+	requestList := []*basic.Request{}
+	for _, e := range f.Entries {
+		link := strings.Split(e.Link, "#")[0]
+		if link == "" || uniqUrl[link] {
+			continue
+		}
+		uniqUrl[link] = true
+		httpReq, err := http.NewRequest(http.MethodGet, link, nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		req := basic.NewRequest(httpReq, httpResp.Depth+1)
+		req.SetMeta(META_FEED, entryMeta(f, e, httpResp.ReqUrl))
+		requestList = append(requestList, req)
+	}
+	return nil, requestList, errs
+}
+
+//文章页对应的feed entry信息, 不是从feed来的返回false
+func FeedEntry(httpResp *basic.Response) (map[string]interface{}, bool) {
+	m, ok := httpResp.Meta[META_FEED].(map[string]interface{})
+	return m, ok
+}
+
+//entry信息, 发布时间能解析的, 额外给出RFC3339格式
+func entryMeta(f *feed.Feed, e *feed.Entry, feedUrl string) map[string]interface{} {
+	m := map[string]interface{}{
+		"title":      e.Title,
+		"link":       e.Link,
+		"id":         e.Id,
+		"published":  e.Published,
+		"author":     e.Author,
+		"summary":    e.Summary,
+		"categories": e.Categories,
+		"feed_title": f.Title,
+		"feed_url":   feedUrl,
+	}
+	if t, err := feed.ParseTime(e.Published); err == nil {
+		m["published_time"] = t.Format(time.RFC3339)
+	}
+	return m
+}
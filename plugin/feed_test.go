@@ -0,0 +1,74 @@
+package plugin
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"testing"
+)
+
+func TestDiscoverFeeds(t *testing.T) {
+	page := `<html><head>
+		<link rel="alternate" type="application/rss+xml" href="/rss.xml">
+		<link rel="alternate" type="application/atom+xml" href="http://example.com/atom.xml#x">
+		<link rel="alternate" hreflang="en" href="/en/">
+		<link rel="stylesheet" type="text/css" href="/a.css">
+		</head><body></body></html>`
+	resp := basic.NewResponse([]byte(page), 2, "text/html; charset=utf-8", "http://example.com/index.html")
+	doc, _ := resp.Document()
+	reqs, errs := DiscoverFeeds(resp, doc)
+	if len(errs) != 0 || len(reqs) != 2 {
+		t.Fatalf("Expect 2 feeds, got %d %v", len(reqs), errs)
+	}
+	if reqs[0].HttpReq().URL.String() != "http://example.com/rss.xml" || reqs[0].Depth() != 2 ||
+		reqs[0].HttpReq().Header.Get("Accept") == "" || reqs[1].HttpReq().URL.String() != "http://example.com/atom.xml" {
+		t.Fatalf("Wrong feed requests: %v", reqs)
+	}
+
+	//ExtractLinks把feed单独作为一类来源
+	links, _ := ExtractLinks(resp, doc)
+	reqs, _ = LinkRequests(resp, links, LINK_SRC_FEED)
+	if len(reqs) != 2 || reqs[0].Depth() != 2 {
+		t.Fatalf("Wrong feed links: %v", reqs)
+	}
+	if reqs, _ = LinkRequests(resp, links, LINK_SRC_LINK); len(reqs) != 1 || reqs[0].Depth() != 3 {
+		t.Fatalf("Wrong alternate links: %v", reqs)
+	}
+}
+
+func TestAnalyzeFeed(t *testing.T) {
+	rss := `<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>360新闻</title>
+		<item><title>第一条</title><link>/n/1.html</link><author>老周</author><pubDate>Tue, 10 Jun 2003 04:00:00 GMT</pubDate></item>
+		<item><title>重复</title><link>/n/1.html#comments</link></item>
+		<item><title>没有链接</title></item>
+		</channel></rss>`
+	//通用的XML类型, 靠根元素识别
+	resp := basic.NewResponse([]byte(rss), 1, "text/xml", "http://www.360.cn/rss.xml")
+	items, reqs, errs := AnalyzeFeed(resp)
+	if len(items) != 0 || len(errs) != 0 || len(reqs) != 1 {
+		t.Fatalf("Wrong result: %d %d %v", len(items), len(reqs), errs)
+	}
+	req := reqs[0]
+	entry, _ := req.Meta()[META_FEED].(map[string]interface{})
+	if req.HttpReq().URL.String() != "http://www.360.cn/n/1.html" || req.Depth() != 2 ||
+		entry["title"] != "第一条" || entry["author"] != "老周" || entry["feed_title"] != "360新闻" ||
+		entry["published_time"] != "2003-06-10T04:00:00Z" {
+		t.Fatalf("Wrong request: %s %v", req.HttpReq().URL, entry)
+	}
+	//同一个响应只解析一次
+	if f1, _ := ParseFeed(resp); f1 == nil || f1.Title != "360新闻" {
+		t.Fatal("Wrong feed:", f1)
+	} else if f2, _ := ParseFeed(resp); f2 != f1 {
+		t.Fatal("Should share the parsed feed")
+	}
+
+	//文章页取出entry信息
+	article := basic.NewResponse([]byte("<html></html>"), 2, "text/html", "http://www.360.cn/n/1.html")
+	article.Meta = req.Meta()
+	if e, ok := FeedEntry(article); !ok || e["title"] != "第一条" {
+		t.Fatal("Wrong feed entry")
+	}
+
+	//HTML不是feed
+	if _, reqs, _ := AnalyzeFeed(article); reqs != nil {
+		t.Fatal("Expect nothing for html")
+	}
+}
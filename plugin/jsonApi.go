@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"errors"
+	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/jsonpath"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+/*
+ * JSON接口的翻页
+ * 很多站点的内容是从JSON接口加载的, 一页数据之后的请求由响应中的翻页信息决定:
+ * 1. page:   页码, 请求参数每次加1, 当前页没有条目则停止
+ * 2. cursor: 游标, 从响应中取出下一页的游标, 作为请求参数
+ * 3. next:   下一页链接, 从响应中直接取出
+ * 翻页请求和当前请求同深度(翻页不是向下钻取), 翻页数由MaxPages限制
+ */
+const (
+	PAGE_BY_NUMBER = "page"
+	PAGE_BY_CURSOR = "cursor"
+	PAGE_BY_NEXT   = "next"
+)
+
+//请求上下文中记录当前是第几页的key, 首页没有这个key, 视为第1页
+const META_PAGE = "page"
+
+//翻页规则
+type JsonPager struct {
+	Mode     string `json:"mode"`      //page, cursor, next
+	Param    string `json:"param"`     //页码或游标的请求参数名
+	Path     string `json:"path"`      //游标或下一页链接在响应中的JSONPath
+	Start    int    `json:"start"`     //页码模式下, Url中没有页码参数时的当前页码, 默认1
+	StopPath string `json:"stop_path"` //可选, 值为false/null/0/空时停止翻页, 比如$.has_more
+	MaxPages int    `json:"max_pages"` //最多翻多少页(包括首页), 0表示不限制
+	path     *jsonpath.Path
+	stopPath *jsonpath.Path
+}
+
+//校验并编译翻页规则
+func (p *JsonPager) Init() error {
+	var err error
+	switch p.Mode {
+	case PAGE_BY_NUMBER:
+		if p.Param == "" {
+			return errors.New("Page mode needs param!")
+		}
+		if p.Start == 0 {
+			p.Start = 1
+		}
+	case PAGE_BY_CURSOR:
+		if p.Param == "" || p.Path == "" {
+			return errors.New("Cursor mode needs param and path!")
+		}
+	case PAGE_BY_NEXT:
+		if p.Path == "" {
+			return errors.New("Next mode needs path!")
+		}
+	default:
+		return errors.New("Unsupported pagination mode: " + p.Mode)
+	}
+	if p.Path != "" {
+		if p.path, err = jsonpath.Compile(p.Path); err != nil {
+			return err
+		}
+	}
+	if p.StopPath != "" {
+		if p.stopPath, err = jsonpath.Compile(p.StopPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//根据当前响应生成下一页的请求, itemCount是当前页抽取出的条目数
+//没有下一页时返回nil, nil
+func (p *JsonPager) NextRequest(httpResp *basic.Response, data interface{}, itemCount int) (*basic.Request, error) {
+	page := 1
+	if n, ok := httpResp.Meta[META_PAGE].(int); ok {
+		page = n
+	}
+	if p.MaxPages > 0 && page >= p.MaxPages {
+		return nil, nil
+	}
+	if p.stopPath != nil {
+		v, ok := p.stopPath.First(data)
+		if s := jsonpath.ToString(v); !ok || s == "" || s == "false" || s == "0" {
+			return nil, nil
+		}
+	}
+
+	reqUrl, err := url.Parse(httpResp.ReqUrl)
+	if err != nil {
+		return nil, err
+	}
+	var nextUrl *url.URL
+	switch p.Mode {
+	case PAGE_BY_NUMBER:
+		if itemCount == 0 {
+			return nil, nil
+		}
+		q := reqUrl.Query()
+		current := p.Start
+		if v := q.Get(p.Param); v != "" {
+			if current, err = strconv.Atoi(v); err != nil {
+				return nil, errors.New("Bad page param: " + v)
+			}
+		}
+		q.Set(p.Param, strconv.Itoa(current+1))
+		reqUrl.RawQuery = q.Encode()
+		nextUrl = reqUrl
+
+	case PAGE_BY_CURSOR:
+		v, _ := p.path.First(data)
+		cursor := jsonpath.ToString(v)
+		if cursor == "" {
+			return nil, nil
+		}
+		q := reqUrl.Query()
+		//游标没有变化, 说明接口一直返回同一页
+		if q.Get(p.Param) == cursor {
+			return nil, nil
+		}
+		q.Set(p.Param, cursor)
+		reqUrl.RawQuery = q.Encode()
+		nextUrl = reqUrl
+
+	case PAGE_BY_NEXT:
+		v, _ := p.path.First(data)
+		next := jsonpath.ToString(v)
+		if next == "" {
+			return nil, nil
+		}
+		u, err := url.Parse(next)
+		if err != nil {
+			return nil, err
+		}
+		nextUrl = reqUrl.ResolveReference(u)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodGet, nextUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	req := basic.NewRequest(httpReq, httpResp.Depth)
+	for k, v := range httpResp.Meta {
+		req.SetMeta(k, v)
+	}
+	req.SetMeta(META_PAGE, page+1)
+	req.SetCallback(httpResp.Callback)
+	return req, nil
+}
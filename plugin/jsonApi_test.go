@@ -0,0 +1,114 @@
+package plugin
+
+import (
+	"encoding/json"
+	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/log"
+	"testing"
+)
+
+const testApi = `{
+	"data": {
+		"list": [
+			{"id": 9007199254740993, "title": " first ", "url": "/n/1.html"},
+			{"id": 2, "title": "second", "url": "/n/2.html"},
+			{"id": 3, "url": "/n/3.html"}
+		],
+		"cursor": "c2",
+		"next": "/api/list?page=2",
+		"has_more": true
+	}
+}`
+
+func TestJsonPager(t *testing.T) {
+	resp := basic.NewResponse([]byte(testApi), 1, "application/json", "http://example.com/api/list?cursor=c1&size=10")
+	resp.Callback = "list"
+	data, err := resp.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		pager JsonPager
+		want  string
+	}{
+		{JsonPager{Mode: PAGE_BY_NUMBER, Param: "page"}, "http://example.com/api/list?cursor=c1&page=2&size=10"},
+		{JsonPager{Mode: PAGE_BY_CURSOR, Param: "cursor", Path: "$.data.cursor"}, "http://example.com/api/list?cursor=c2&size=10"},
+		{JsonPager{Mode: PAGE_BY_NEXT, Path: "$.data.next"}, "http://example.com/api/list?page=2"},
+		{JsonPager{Mode: PAGE_BY_NEXT, Path: "$.data.next", StopPath: "$.data.missing"}, ""},
+		{JsonPager{Mode: PAGE_BY_CURSOR, Param: "cursor", Path: "$.data.none"}, ""},
+		{JsonPager{Mode: PAGE_BY_NUMBER, Param: "page", MaxPages: 1}, ""},
+	}
+	for i, c := range cases {
+		if err := c.pager.Init(); err != nil {
+			t.Fatal(err)
+		}
+		req, err := c.pager.NextRequest(resp, data, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.want == "" {
+			if req != nil {
+				t.Fatalf("Case %d: expect no next page, got %s", i, req.HttpReq().URL)
+			}
+			continue
+		}
+		if req == nil || req.HttpReq().URL.String() != c.want {
+			t.Fatalf("Case %d: expect %s, got %v", i, c.want, req)
+		}
+		//翻页不增加深度, 沿用分析函数, 记录页数
+		if req.Depth() != 1 || req.Callback() != "list" || req.Meta()[META_PAGE] != 2 {
+			t.Fatalf("Case %d: wrong request: %d %s %v", i, req.Depth(), req.Callback(), req.Meta())
+		}
+	}
+
+	//页码模式下, 空页停止
+	pager := JsonPager{Mode: PAGE_BY_NUMBER, Param: "page"}
+	pager.Init()
+	if req, _ := pager.NextRequest(resp, data, 0); req != nil {
+		t.Fatal("Expect stop on empty page")
+	}
+	if err := (&JsonPager{Mode: PAGE_BY_CURSOR, Param: "cursor"}).Init(); err == nil {
+		t.Fatal("Expect missing path error")
+	}
+}
+
+func TestParseJsonByRules(t *testing.T) {
+	log.InitLog("", "debug")
+	rules, err := ParseSiteRules([]byte(`{"sites": [{
+		"name": "api",
+		"items": "$.data.list[*]",
+		"fields": [
+			{"name": "id", "selector": "json:id"},
+			{"name": "title", "selector": "json:$.title", "required": true}
+		],
+		"follow": [
+			{"selector": "json:$.data.list[*].url", "pattern": "/n/[12]"}
+		],
+		"pagination": {"mode": "cursor", "param": "cursor", "path": "$.data.cursor", "stop_path": "$.data.has_more"}
+	}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := basic.NewResponse([]byte(testApi), 0, "application/json; charset=utf-8", "http://example.com/api/list")
+	items, reqs, errs := parseByRules(resp, rules)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expect 2 items, got %d", len(items))
+	}
+	//大整数保持精度
+	if id := (*items[0])["id"].(json.Number); id.String() != "9007199254740993" || (*items[0])["title"] != "first" {
+		t.Fatalf("Wrong item: %v", *items[0])
+	}
+	if len(reqs) != 3 || reqs[0].HttpReq().URL.String() != "http://example.com/n/1.html" ||
+		reqs[2].HttpReq().URL.String() != "http://example.com/api/list?cursor=c2" {
+		t.Fatalf("Wrong requests: %v", reqs)
+	}
+
+	if _, err := ParseSiteRules([]byte(`{"sites": [{"fields": [{"name": "a", "selector": "json:a"}, {"name": "b", "selector": "h1"}]}]}`)); err == nil {
+		t.Fatal("Expect mixed selector error")
+	}
+}
@@ -0,0 +1,240 @@
+package plugin
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hq-cml/spider-man/basic"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+/*
+ * 全面的链接抽取
+ * 除了<a href>, 还包括<area>, <link rel>, <frame>/<iframe>, <meta http-equiv=refresh>,
+ * HTTP的Link响应头, 内联脚本以及javascript:链接中的Url
+ * 每个链接都标记了来源, 插件可以选择只跟进某几类
+ */
+
+//链接来源
+const (
+	LINK_SRC_A            = "a"
+	LINK_SRC_AREA         = "area"
+	LINK_SRC_LINK         = "link"         //<link rel=next/prev/alternate/canonical>
+	LINK_SRC_FRAME        = "frame"        //<frame>, <iframe>
+	LINK_SRC_META_REFRESH = "meta-refresh" //<meta http-equiv=refresh>
+	LINK_SRC_HEADER       = "header"       //HTTP Link响应头
+	LINK_SRC_SCRIPT       = "script"       //内联脚本和javascript:链接
+	LINK_SRC_FEED         = "feed"         //<link rel=alternate type=application/rss+xml>等, 生成feed请求
+	LINK_SRC_TEXT         = "text"         //纯文本中的Url和sitemap的<loc>
+)
+
+var linkSources = map[string]bool{
+	LINK_SRC_A:            true,
+	LINK_SRC_AREA:         true,
+	LINK_SRC_LINK:         true,
+	LINK_SRC_FRAME:        true,
+	LINK_SRC_META_REFRESH: true,
+	LINK_SRC_HEADER:       true,
+	LINK_SRC_SCRIPT:       true,
+	LINK_SRC_FEED:         true,
+	LINK_SRC_TEXT:         true,
+}
+
+//抽取的链接
+type Link struct {
+	Url    string //补全后的绝对Url
+	Source string //来源
+	Rel    string //<link>和Link响应头的rel
+	Text   string //<a>的文本, <area>的alt
+}
+
+//<link>只抽取这几类rel, 其他的(stylesheet, icon等)不是页面
+var followRels = map[string]bool{
+	"next":      true,
+	"prev":      true,
+	"previous":  true,
+	"alternate": true,
+	"canonical": true,
+}
+
+//脚本中的绝对Url
+var scriptUrlRe = regexp.MustCompile(`https?://[^\s"'<>\\)(\]\[{}]+`)
+
+//javascript:链接中被引号括起来的Url, 比如window.open('/n/1.html')
+var jsQuotedUrlRe = regexp.MustCompile(`['"]((?:https?://|/)[^'"\s]+)['"]`)
+
+//Link响应头中的一项: <url>; rel="next"
+var linkHeaderRe = regexp.MustCompile(`<([^>]*)>([^<]*)`)
+var linkRelRe = regexp.MustCompile(`(?i)rel\s*=\s*"?([^";,]+)"?`)
+
+//抽取页面和响应头中的全部链接, 同一来源的相同Url只保留一个
+//相对链接按照<base href>(如果有)补全
+func ExtractLinks(httpResp *basic.Response, doc *goquery.Document) ([]*Link, []error) {
+	reqUrl, err := url.Parse(httpResp.ReqUrl)
+	if err != nil {
+		return nil, []error{err}
+	}
+	baseUrl := docBaseUrl(reqUrl, doc)
+
+	links := []*Link{}
+	errs := make([]error, 0)
+	uniq := map[string]bool{}
+	add := func(base *url.URL, href, source, rel, text string) {
+		u, ok, err := normalizeLink(base, href)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		if !ok || uniq[source+" "+u] {
+			return
+		}
+		uniq[source+" "+u] = true
+		links = append(links, &Link{Url: u, Source: source, Rel: rel, Text: strings.TrimSpace(text)})
+	}
+
+	//HTTP Link响应头, 相对地址按照请求Url补全
+	for _, l := range parseLinkHeader(httpResp.Header) {
+		if hasFollowRel(l.Rel) {
+			add(reqUrl, l.Url, LINK_SRC_HEADER, l.Rel, "")
+		}
+	}
+
+	doc.Find("a[href], area[href]").Each(func(i int, sel *goquery.Selection) {
+		href, _ := sel.Attr("href")
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(href)), "javascript:") {
+			for _, u := range extractJsLinks(href) {
+				add(baseUrl, u, LINK_SRC_SCRIPT, "", "")
+			}
+			return
+		}
+		if goquery.NodeName(sel) == "area" {
+			alt, _ := sel.Attr("alt")
+			add(baseUrl, href, LINK_SRC_AREA, "", alt)
+		} else {
+			add(baseUrl, href, LINK_SRC_A, "", sel.Text())
+		}
+	})
+
+	doc.Find("link[href][rel]").Each(func(i int, sel *goquery.Selection) {
+		rel, _ := sel.Attr("rel")
+		typ, _ := sel.Attr("type")
+		href, _ := sel.Attr("href")
+		if isFeedLink(rel, typ) {
+			add(baseUrl, href, LINK_SRC_FEED, strings.ToLower(strings.TrimSpace(rel)), "")
+		} else if hasFollowRel(rel) {
+			add(baseUrl, href, LINK_SRC_LINK, strings.ToLower(strings.TrimSpace(rel)), "")
+		}
+	})
+
+	doc.Find("frame[src], iframe[src]").Each(func(i int, sel *goquery.Selection) {
+		src, _ := sel.Attr("src")
+		add(baseUrl, src, LINK_SRC_FRAME, "", "")
+	})
+
+	doc.Find("meta[http-equiv][content]").Each(func(i int, sel *goquery.Selection) {
+		equiv, _ := sel.Attr("http-equiv")
+		if !strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+			return
+		}
+		content, _ := sel.Attr("content")
+		if u := parseMetaRefresh(content); u != "" {
+			add(baseUrl, u, LINK_SRC_META_REFRESH, "", "")
+		}
+	})
+
+	//内联脚本, 只找绝对Url, 相对路径在脚本中太容易误判
+	doc.Find("script").Each(func(i int, sel *goquery.Selection) {
+		if _, exists := sel.Attr("src"); exists {
+			return
+		}
+		script := strings.Replace(sel.Text(), `\/`, `/`, -1) //JSON中转义的/
+		for _, u := range scriptUrlRe.FindAllString(script, -1) {
+			add(baseUrl, strings.TrimRight(u, ".,;:"), LINK_SRC_SCRIPT, "", "")
+		}
+	})
+
+	return links, errs
+}
+
+//按来源筛选链接, 生成新请求, 不指定来源则全部跟进; 不同来源的相同Url只生成一个请求
+//feed链接和当前页面同深度, 其他链接深度加1
+func LinkRequests(httpResp *basic.Response, links []*Link, sources ...string) ([]*basic.Request, []error) {
+	accept := map[string]bool{}
+	for _, s := range sources {
+		accept[s] = true
+	}
+
+	errs := make([]error, 0)
+	uniqUrl := map[string]bool{}
+	requestList := []*basic.Request{}
+	for _, l := range links {
+		if (len(accept) > 0 && !accept[l.Source]) || uniqUrl[l.Url] {
+			continue
+		}
+		uniqUrl[l.Url] = true
+		if l.Source == LINK_SRC_FEED {
+			req, err := feedRequest(httpResp, l.Url)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			requestList = append(requestList, req)
+			continue
+		}
+		httpReq, err := http.NewRequest(http.MethodGet, l.Url, nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		requestList = append(requestList, basic.NewRequest(httpReq, httpResp.Depth+1))
+	}
+	return requestList, errs
+}
+
+//rel可以有多个值, 比如"alternate nofollow"
+func hasFollowRel(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if followRels[r] {
+			return true
+		}
+	}
+	return false
+}
+
+//解析Link响应头: <http://a.com/2>; rel="next", </1>; rel=prev
+func parseLinkHeader(header http.Header) []*Link {
+	links := []*Link{}
+	for _, value := range header["Link"] {
+		for _, m := range linkHeaderRe.FindAllStringSubmatch(value, -1) {
+			rel := ""
+			if r := linkRelRe.FindStringSubmatch(m[2]); r != nil {
+				rel = strings.TrimSpace(r[1])
+			}
+			links = append(links, &Link{Url: strings.TrimSpace(m[1]), Source: LINK_SRC_HEADER, Rel: rel})
+		}
+	}
+	return links
+}
+
+//解析<meta http-equiv=refresh content="5; url=/next.html">中的地址
+func parseMetaRefresh(content string) string {
+	idx := strings.Index(strings.ToLower(content), "url")
+	if idx < 0 {
+		return ""
+	}
+	rest := strings.TrimSpace(content[idx+3:])
+	if !strings.HasPrefix(rest, "=") {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(rest[1:]), `'"`)
+}
+
+//javascript:链接中引号括起来的地址
+func extractJsLinks(js string) []string {
+	hrefs := []string{}
+	for _, m := range jsQuotedUrlRe.FindAllStringSubmatch(js, -1) {
+		hrefs = append(hrefs, m[1])
+	}
+	return hrefs
+}
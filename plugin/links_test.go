@@ -0,0 +1,99 @@
+package plugin
+
+import (
+	"bytes"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hq-cml/spider-man/basic"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testLinksPage = `<html><head>
+<base href="http://cdn.example.com/base/">
+<link rel="next" href="page2.html">
+<link rel="stylesheet" href="style.css">
+<link rel="alternate nofollow" href="/en/">
+<meta http-equiv="Refresh" content="5; URL='/moved.html'">
+<script>var api = "https:\/\/api.example.com\/v1\/list"; location.href = "http://example.com/js.html";</script>
+<script src="http://example.com/app.js"></script>
+</head><body>
+<a href="a.html#top">A</a>
+<a href="javascript:window.open('/popup.html')">popup</a>
+<a href="javascript:void(0)">void</a>
+<a href="mailto:someone@example.com">mail</a>
+<map><area href="/area.html" alt="Area"></map>
+<iframe src="http://example.com/frame.html"></iframe>
+</body></html>`
+
+func TestExtractLinks(t *testing.T) {
+	resp := basic.NewResponse([]byte(testLinksPage), 1, "text/html; charset=utf-8", "http://example.com/dir/index.html")
+	resp.Header = http.Header{}
+	resp.Header.Add("Link", `</p/3>; rel="next", <http://example.com/style.css>; rel=stylesheet`)
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(resp.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, errs := ExtractLinks(resp, doc)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	got := []string{}
+	for _, l := range links {
+		got = append(got, l.Source+" "+l.Url)
+	}
+	sort.Strings(got)
+	want := []string{
+		"a http://cdn.example.com/base/a.html",
+		"area http://cdn.example.com/area.html",
+		"frame http://example.com/frame.html",
+		"header http://example.com/p/3",
+		"link http://cdn.example.com/base/page2.html",
+		"link http://cdn.example.com/en",
+		"meta-refresh http://cdn.example.com/moved.html",
+		"script http://cdn.example.com/popup.html",
+		"script http://example.com/js.html",
+		"script https://api.example.com/v1/list",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("Wrong links:\n%s", strings.Join(got, "\n"))
+	}
+
+	//按来源筛选
+	reqs, _ := LinkRequests(resp, links, LINK_SRC_LINK, LINK_SRC_HEADER)
+	if len(reqs) != 3 || reqs[0].Depth() != 2 {
+		t.Fatalf("Expect 3 requests, got %d", len(reqs))
+	}
+	reqs, _ = LinkRequests(resp, links)
+	if len(reqs) != len(links) {
+		t.Fatalf("Expect %d requests, got %d", len(links), len(reqs))
+	}
+}
+
+func TestFindATagWithBase(t *testing.T) {
+	resp := basic.NewResponse([]byte(testLinksPage), 0, "text/html; charset=utf-8", "http://example.com/dir/index.html")
+	doc, _ := goquery.NewDocumentFromReader(bytes.NewReader(resp.Body))
+	reqUrl, _ := url.Parse(resp.ReqUrl)
+	base := docBaseUrl(reqUrl, doc)
+	reqs, _ := findATagFromDoc(resp, doc, LINK_SRC_A)
+	if base.String() != "http://cdn.example.com/base/" || len(reqs) != 1 ||
+		reqs[0].HttpReq().URL.String() != "http://cdn.example.com/base/a.html" {
+		t.Fatalf("Wrong requests: %v", reqs)
+	}
+
+	//不指定来源则全部跟进, 包括javascript:链接; 没有Link响应头, 共9个链接
+	reqs, _ = findATagFromDoc(resp, doc)
+	found := false
+	for _, req := range reqs {
+		if req.HttpReq().URL.String() == "http://cdn.example.com/popup.html" {
+			found = true
+		}
+	}
+	if !found || len(reqs) != 9 {
+		t.Fatalf("Wrong requests: %v", reqs)
+	}
+}
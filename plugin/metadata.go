@@ -0,0 +1,71 @@
+package plugin
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/metadata"
+	"net/url"
+)
+
+/*
+ * 通用的页面元数据分析
+ * 标题、描述、关键字、canonical、lang、OpenGraph、Twitter card、JSON-LD和microdata
+ * 两种用法:
+ * 1. AnalyzeMetadata: 作为独立的分析函数, 每个HTML页面产出一个元数据item
+ * 2. WithMetadata: 装饰已有的分析函数, 给它产出的每个item加上"metadata"
+ */
+
+//item中元数据的key
+const ITEM_METADATA = "metadata"
+
+//抽取页面元数据, 非HTML响应返回nil
+func PageMetadata(httpResp *basic.Response) (map[string]interface{}, []error) {
+	if len(httpResp.Body) == 0 || httpResp.IsJSON() {
+		return nil, nil
+	}
+	reqUrl, err := url.Parse(httpResp.ReqUrl)
+	if err != nil {
+		return nil, []error{err}
+	}
+	doc, err := httpResp.Document()
+	if err != nil {
+		return nil, []error{err}
+	}
+	m, errs := metadata.Extract(doc, docBaseUrl(reqUrl, doc))
+	return m.ToMap(), errs
+}
+
+//元数据分析函数, 每个HTML页面产出一个item: url, depth, metadata
+func AnalyzeMetadata(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+	m, errs := PageMetadata(httpResp)
+	if m == nil {
+		return nil, nil, errs
+	}
+	item := basic.Item(map[string]interface{}{
+		"url":         httpResp.ReqUrl,
+		"depth":       httpResp.Depth,
+		ITEM_METADATA: m,
+	})
+	return []*basic.Item{&item}, nil, errs
+}
+
+//装饰分析函数, 给它产出的每个item加上页面元数据(item中已有的不覆盖)
+//页面没有产出item时不抽取元数据
+func WithMetadata(f basic.AnalyzeResponseFunc) basic.AnalyzeResponseFunc {
+	return func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+		items, reqs, errs := f(httpResp)
+		if len(items) == 0 {
+			return items, reqs, errs
+		}
+		m, es := PageMetadata(httpResp)
+		errs = append(errs, es...)
+		if m == nil {
+			return items, reqs, errs
+		}
+		for _, item := range items {
+			if _, ok := (*item)[ITEM_METADATA]; !ok {
+				(*item)[ITEM_METADATA] = m
+			}
+		}
+		return items, reqs, errs
+	}
+}
@@ -0,0 +1,38 @@
+package plugin
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"testing"
+)
+
+func TestWithMetadata(t *testing.T) {
+	page := `<html lang="en"><head><title>T</title><base href="http://cdn.example.com/">
+		<link rel="canonical" href="a.html"><meta property="og:type" content="article"></head>
+		<body><p>hello</p></body></html>`
+	resp := basic.NewResponse([]byte(page), 1, "text/html; charset=utf-8", "http://example.com/a.html?from=x")
+
+	f := WithMetadata(func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+		a := basic.Item{"url": httpResp.ReqUrl}
+		b := basic.Item{"url": httpResp.ReqUrl, ITEM_METADATA: "keep"}
+		return []*basic.Item{&a, &b}, nil, nil
+	})
+	items, _, errs := f(resp)
+	if len(errs) != 0 || len(items) != 2 {
+		t.Fatal(errs)
+	}
+	m, _ := (*items[0])[ITEM_METADATA].(map[string]interface{})
+	if m["title"] != "T" || m["lang"] != "en" || m["canonical"] != "http://cdn.example.com/a.html" ||
+		m["opengraph"].(map[string]interface{})["type"] != "article" || (*items[1])[ITEM_METADATA] != "keep" {
+		t.Fatalf("Wrong metadata: %v", *items[0])
+	}
+
+	items, _, _ = AnalyzeMetadata(resp)
+	if len(items) != 1 || (*items[0])["depth"] != 1 {
+		t.Fatalf("Wrong items: %v", items)
+	}
+	//JSON响应没有页面元数据
+	items, _, _ = AnalyzeMetadata(basic.NewResponse([]byte(`{}`), 0, "application/json", "http://example.com/api"))
+	if len(items) != 0 {
+		t.Fatal("Expect no item for json")
+	}
+}
@@ -0,0 +1,315 @@
+package plugin
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hq-cml/spider-man/basic"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+ * HTML列表页的翻页
+ * 按以下顺序识别下一页链接:
+ * 1. 指定的CSS选择器
+ * 2. <link rel=next>, <a rel=next>
+ * 3. 文字是"下一页", "next"等, 或者class/id中含有next的链接
+ * 4. Url中只有一个数字不同且正好加1的链接, 比如?page=2 => ?page=3, list_2.html => list_3.html
+ * 5. 页码条中文字正好是下一页页码的链接, 比如"2"
+ * 翻页请求和当前页同深度(翻页不是向下钻取, 不受grabMaxDepth限制), 改由MaxPages限制每个列表的页数
+ */
+
+//翻页规则
+type Pager struct {
+	Selector string `json:"selector"`  //可选, 下一页链接的CSS选择器, 优先于自动识别
+	MaxPages int    `json:"max_pages"` //每个列表最多翻多少页(包括首页), 0表示不限制
+}
+
+//"下一页"的文字, 比较前去掉空白和两边的箭头
+var nextPageTexts = map[string]bool{
+	"下一页":         true,
+	"下页":          true,
+	"后页":          true,
+	"下一頁":         true,
+	"next":        true,
+	"next page":   true,
+	"older":       true,
+	"older posts": true,
+}
+
+//只有箭头的链接, ">>"通常是末页, 不算
+var nextPageArrows = map[string]bool{
+	">": true,
+	"»": true,
+	"›": true,
+	"→": true,
+}
+
+var nextClassRe = regexp.MustCompile(`(?i)(^|[\s_-])next([\s_-]|$)|nextpage|next-page|page-next|pagenext`)
+var notNextClassRe = regexp.MustCompile(`(?i)prev|last|disabled|first`)
+
+//Url中的数字
+var numberRe = regexp.MustCompile(`\d+`)
+
+//页码条的class/id, 比如pagination, pager, page-nav
+var pagerClassRe = regexp.MustCompile(`(?i)pag`)
+
+//常见的页码参数, 首页的Url中通常没有页码参数
+var pageParams = []string{"page", "p", "pn", "pg", "paged", "pageno", "page_no", "pagenum", "pageindex", "pageNo", "pageNum", "pageIndex"}
+
+//根据当前页生成下一页的请求, 没有下一页或者超过页数限制返回nil, nil
+func (p *Pager) NextRequest(httpResp *basic.Response, doc *goquery.Document) (*basic.Request, error) {
+	page := 1
+	if n, ok := httpResp.Meta[META_PAGE].(int); ok {
+		page = n
+	}
+	if p.MaxPages > 0 && page >= p.MaxPages {
+		return nil, nil
+	}
+
+	next := ""
+	if p.Selector != "" {
+		reqUrl, err := url.Parse(httpResp.ReqUrl)
+		if err != nil {
+			return nil, err
+		}
+		if href, ok := doc.Find(p.Selector).First().Attr("href"); ok {
+			next, _, _ = normalizeLink(docBaseUrl(reqUrl, doc), href)
+		}
+	} else {
+		next = DetectNextPage(httpResp, doc)
+	}
+	if next == "" {
+		return nil, nil
+	}
+
+	httpReq, err := http.NewRequest(http.MethodGet, next, nil)
+	if err != nil {
+		return nil, err
+	}
+	req := basic.NewRequest(httpReq, httpResp.Depth)
+	for k, v := range httpResp.Meta {
+		req.SetMeta(k, v)
+	}
+	req.SetMeta(META_PAGE, page+1)
+	req.SetCallback(httpResp.Callback)
+	return req, nil
+}
+
+//把翻页请求放到请求列表的最前面, 并去掉列表中相同Url的普通请求(深度加1的)
+//调度器按Url去重, 先到的请求生效, 这样下一页才不会计入深度
+func withNextPage(requestList []*basic.Request, next *basic.Request) []*basic.Request {
+	if next == nil {
+		return requestList
+	}
+	nextUrl := strings.TrimRight(strings.Split(next.HttpReq().URL.String(), "#")[0], "/")
+	result := []*basic.Request{next}
+	for _, req := range requestList {
+		u := strings.TrimRight(strings.Split(req.HttpReq().URL.String(), "#")[0], "/")
+		if u != nextUrl {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+//自动识别下一页链接, 返回补全后的绝对Url, 没有返回空
+func DetectNextPage(httpResp *basic.Response, doc *goquery.Document) string {
+	reqUrl, err := url.Parse(httpResp.ReqUrl)
+	if err != nil {
+		return ""
+	}
+	baseUrl := docBaseUrl(reqUrl, doc)
+	current, _, _ := normalizeLink(reqUrl, httpResp.ReqUrl)
+
+	//候选链接: 同host, 不是当前页
+	accept := func(href string) string {
+		u, ok, err := normalizeLink(baseUrl, href)
+		if err != nil || !ok || u == current {
+			return ""
+		}
+		if pu, err := url.Parse(u); err != nil || pu.Host != reqUrl.Host {
+			return ""
+		}
+		return u
+	}
+	anchors := doc.Find("a[href]")
+
+	//rel=next
+	next := ""
+	doc.Find("link[rel][href], a[rel][href]").EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		rel, _ := sel.Attr("rel")
+		if hasRel(rel, "next") {
+			href, _ := sel.Attr("href")
+			next = accept(href)
+		}
+		return next == ""
+	})
+	if next != "" {
+		return next
+	}
+
+	//"下一页"等文字, 以及class/id中有next的链接(包括父节点, 比如<li class="next"><a>)
+	anchors.EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		text := strings.ToLower(strings.Join(strings.Fields(sel.Text()), " "))
+		trimmed := strings.TrimSpace(strings.Trim(text, "<>»›→ "))
+		ci := classAndId(sel) + " " + classAndId(sel.Parent())
+		if nextPageTexts[trimmed] || nextPageArrows[text] ||
+			(nextClassRe.MatchString(ci) && !notNextClassRe.MatchString(ci)) {
+			href, _ := sel.Attr("href")
+			next = accept(href)
+		}
+		return next == ""
+	})
+	if next != "" {
+		return next
+	}
+
+	//Url中只有一个数字不同且正好加1
+	expect := numericNextUrls(reqUrl)
+	anchors.EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		href, _ := sel.Attr("href")
+		if u := accept(href); u != "" {
+			if pu, err := url.Parse(u); err == nil && expect[canonicalUrl(pu)] {
+				next = u
+			}
+		}
+		return next == ""
+	})
+	if next != "" {
+		return next
+	}
+	if u := numericNextUrl(reqUrl, anchors, accept); u != "" {
+		return u
+	}
+
+	//页码条中文字为下一页页码的链接
+	nextNum := strconv.Itoa(currentPageNumber(httpResp, reqUrl) + 1)
+	anchors.EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		if strings.TrimSpace(sel.Text()) == nextNum && inPager(sel) {
+			href, _ := sel.Attr("href")
+			next = accept(href)
+		}
+		return next == ""
+	})
+	return next
+}
+
+//首页的Url中通常没有页码, 第二页是加上页码参数=2
+func numericNextUrls(reqUrl *url.URL) map[string]bool {
+	urls := map[string]bool{}
+	q := reqUrl.Query()
+	for _, name := range pageParams {
+		if _, ok := q[name]; ok {
+			return urls
+		}
+	}
+	for _, name := range pageParams {
+		u := *reqUrl
+		nq := reqUrl.Query()
+		nq.Set(name, "2")
+		u.RawQuery = nq.Encode()
+		u.Fragment = ""
+		urls[canonicalUrl(&u)] = true
+	}
+	return urls
+}
+
+//链接和当前Url相比只有一个数字不同, 且正好加1; 查询参数的顺序不影响比较
+func numericNextUrl(reqUrl *url.URL, anchors *goquery.Selection, accept func(string) string) string {
+	curTokens, curNums := splitNumbers(canonicalUrl(reqUrl))
+	next := ""
+	anchors.EachWithBreak(func(i int, sel *goquery.Selection) bool {
+		href, _ := sel.Attr("href")
+		u := accept(href)
+		if u == "" {
+			return true
+		}
+		pu, err := url.Parse(u)
+		if err != nil {
+			return true
+		}
+		//路径中的数字(比如文章id)加1不一定是翻页, 必须在页码条中或者文字是页码
+		if pu.Path != reqUrl.Path && !inPager(sel) && !isNumber(strings.TrimSpace(sel.Text())) {
+			return true
+		}
+		tokens, nums := splitNumbers(canonicalUrl(pu))
+		if len(nums) != len(curNums) || strings.Join(tokens, "#") != strings.Join(curTokens, "#") {
+			return true
+		}
+		diff := 0
+		plusOne := false
+		for j := range nums {
+			if nums[j] != curNums[j] {
+				diff++
+				a, _ := strconv.Atoi(curNums[j])
+				b, _ := strconv.Atoi(nums[j])
+				plusOne = b == a+1
+			}
+		}
+		if diff == 1 && plusOne {
+			next = u
+		}
+		return next == ""
+	})
+	return next
+}
+
+//按参数名排序的Url, 去掉#和末尾的/
+func canonicalUrl(u *url.URL) string {
+	c := *u
+	c.RawQuery = u.Query().Encode()
+	c.Fragment = ""
+	return strings.TrimRight(c.String(), "/")
+}
+
+//把字符串拆成非数字部分和数字部分
+func splitNumbers(s string) ([]string, []string) {
+	return numberRe.Split(s, -1), numberRe.FindAllString(s, -1)
+}
+
+//当前页码: Url中的页码参数, 其次是上下文中记录的页数, 默认1
+func currentPageNumber(httpResp *basic.Response, reqUrl *url.URL) int {
+	q := reqUrl.Query()
+	for _, name := range pageParams {
+		if n, err := strconv.Atoi(q.Get(name)); err == nil && n > 0 {
+			return n
+		}
+	}
+	if n, ok := httpResp.Meta[META_PAGE].(int); ok {
+		return n
+	}
+	return 1
+}
+
+//链接是否在页码条中, 向上找三层
+func inPager(sel *goquery.Selection) bool {
+	for i, p := 0, sel.Parent(); i < 3 && p.Length() > 0; i, p = i+1, p.Parent() {
+		if pagerClassRe.MatchString(classAndId(p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil && s != ""
+}
+
+func hasRel(rel, want string) bool {
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		if r == want {
+			return true
+		}
+	}
+	return false
+}
+
+func classAndId(sel *goquery.Selection) string {
+	class, _ := sel.Attr("class")
+	id, _ := sel.Attr("id")
+	return strings.TrimSpace(class + " " + id)
+}
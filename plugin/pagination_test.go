@@ -0,0 +1,82 @@
+package plugin
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"net/http"
+	"testing"
+)
+
+func TestDetectNextPage(t *testing.T) {
+	cases := []struct {
+		url  string
+		page string
+		want string
+	}{
+		//rel=next优先
+		{"http://a.com/list", `<link rel="next" href="/list?page=2"><a href="/list?page=9">下一页</a>`, "http://a.com/list?page=2"},
+		{"http://a.com/list", `<a href="/list/2">下一页 &gt;</a>`, "http://a.com/list/2"},
+		{"http://a.com/list", `<a href="/list/2">Next »</a>`, "http://a.com/list/2"},
+		{"http://a.com/list", `<ul><li class="prev"><a href="/list/0">‹</a></li><li class="next"><a href="/list/2">›</a></li></ul>`, "http://a.com/list/2"},
+		//跨站的不算
+		{"http://a.com/list", `<a href="http://b.com/list/2">下一页</a>`, ""},
+		//首页没有页码参数
+		{"http://a.com/list?cat=1", `<a href="/list?page=2&cat=1">2</a>`, "http://a.com/list?page=2&cat=1"},
+		//查询参数中的页码加1
+		{"http://a.com/list?cat=1&pn=3", `<a href="/list?pn=2&cat=1">2</a><a href="/list?cat=1&pn=4">4</a>`, "http://a.com/list?cat=1&pn=4"},
+		//路径中的页码加1, 必须在页码条中
+		{"http://a.com/news/list_2.html", `<div class="pages"><a href="list_1.html">1</a><a href="list_3.html">3</a></div>`, "http://a.com/news/list_3.html"},
+		{"http://a.com/n/100.html", `<a href="/n/101.html">另一篇文章</a>`, ""},
+		//页码条中的页码
+		{"http://a.com/list", `<div class="pagination"><span>1</span><a href="/list-p2">2</a><a href="/list-p3">3</a></div>`, "http://a.com/list-p2"},
+		{"http://a.com/list", `<p>见注释<a href="/note">2</a></p>`, ""},
+	}
+	for i, c := range cases {
+		resp := basic.NewResponse([]byte("<html><head></head><body>"+c.page+"</body></html>"), 1, "text/html; charset=utf-8", c.url)
+		doc, err := resp.Document()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := DetectNextPage(resp, doc); got != c.want {
+			t.Errorf("Case %d: expect %q, got %q", i, c.want, got)
+		}
+	}
+}
+
+func TestPagerNextRequest(t *testing.T) {
+	page := `<html><body><a href="/n/1.html">文章</a><a href="/list?page=2">下一页</a></body></html>`
+	resp := basic.NewResponse([]byte(page), 2, "text/html; charset=utf-8", "http://a.com/list")
+	resp.Meta = map[string]interface{}{"cat": "news"}
+	doc, _ := resp.Document()
+
+	pager := &Pager{MaxPages: 3}
+	next, err := pager.NextRequest(resp, doc)
+	if err != nil || next == nil {
+		t.Fatal("Expect next page", err)
+	}
+	//翻页不增加深度, 记录页数, 保留上下文
+	if next.HttpReq().URL.String() != "http://a.com/list?page=2" || next.Depth() != 2 ||
+		next.Meta()[META_PAGE] != 2 || next.Meta()["cat"] != "news" {
+		t.Fatalf("Wrong next: %s %d %v", next.HttpReq().URL, next.Depth(), next.Meta())
+	}
+
+	//下一页放在最前面, 去掉深度加1的同一个Url
+	a, _ := http.NewRequest(http.MethodGet, "http://a.com/n/1.html", nil)
+	b, _ := http.NewRequest(http.MethodGet, "http://a.com/list?page=2#top", nil)
+	reqs := withNextPage([]*basic.Request{basic.NewRequest(a, 3), basic.NewRequest(b, 3)}, next)
+	if len(reqs) != 2 || reqs[0] != next || reqs[1].HttpReq().URL.Path != "/n/1.html" {
+		t.Fatalf("Wrong requests: %v", reqs)
+	}
+
+	//达到页数上限
+	resp.Meta[META_PAGE] = 3
+	if next, _ := pager.NextRequest(resp, doc); next != nil {
+		t.Fatal("Expect max pages")
+	}
+
+	//指定选择器
+	resp.Meta = nil
+	pager = &Pager{Selector: "a[href^='/n/']"}
+	if next, _ := pager.NextRequest(resp, doc); next == nil || next.HttpReq().URL.String() != "http://a.com/n/1.html" {
+		t.Fatal("Wrong selector next")
+	}
+}
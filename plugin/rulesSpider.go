@@ -0,0 +1,567 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/jsonpath"
+	"github.com/hq-cml/spider-man/helper/xpath"
+	"golang.org/x/net/html"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/*
+ * *RulesSpider实现SpiderPlugin接口
+ * 声明式的通用插件, 不用为每个站点单独写Go代码:
+ * 规则文件(JSON)中按Url模式定义站点, 每个站点定义条目字段的抽取方式(CSS选择器, 属性或文本, 正则后处理, 是否必需)
+ * 以及需要跟进的链接选择器
+ * 选择器默认是CSS选择器, 以"xpath:"开头则是XPath表达式
+ * 选择器以"json:"开头则是JSONPath, 站点按JSON接口处理: 可以用items指定条目列表, 用pagination指定翻页方式
+ * 用法: -p rules -u conf/rules.json
+ */
+type RulesSpider struct {
+	userData interface{}
+}
+
+//规则文件
+type SiteRules struct {
+	Sites []*SiteRule `json:"sites"`
+}
+
+//一个站点(一类页面)的规则, 按顺序匹配, 第一个UrlPattern匹配的站点生效
+type SiteRule struct {
+	Name       string         `json:"name"`
+	UrlPattern string         `json:"url_pattern"` //为空表示匹配所有Url
+	Fields     []*FieldRule   `json:"fields"`      //为空表示只跟进链接, 不产生条目
+	Follow     []*FollowRule  `json:"follow"`
+	Items      string         `json:"items"`       //JSON接口: 条目列表的JSONPath, 每个元素产生一个条目, 字段相对于元素求值
+	Pagination *JsonPager     `json:"pagination"`  //JSON接口: 翻页规则
+	Metadata   bool           `json:"metadata"`    //HTML页面: 条目中是否加上页面元数据和结构化数据
+	Pager      *Pager         `json:"pager"`       //HTML页面: 列表页翻页
+	urlRe      *regexp.Regexp
+	items      *jsonpath.Path
+	json       bool           //是否是JSON接口
+}
+
+//条目字段的抽取规则
+type FieldRule struct {
+	Name     string `json:"name"`
+	Selector string `json:"selector"` //CSS选择器, 或者"xpath:"开头的XPath表达式
+	Attr     string `json:"attr"`     //为空取文本, "html"取内部html, 其他取对应属性
+	Multiple bool   `json:"multiple"` //true则取全部匹配的元素, 结果是列表; 否则只取第一个
+	NoTrim   bool   `json:"no_trim"`  //默认去除首尾空白
+	Regex    string `json:"regex"`    //后处理, 有分组取第一个分组, 否则取整个匹配
+	Required bool   `json:"required"` //必需字段为空, 则不产生条目
+	re       *regexp.Regexp
+	xp       *xpath.Expr
+	jp       *jsonpath.Path
+}
+
+//链接跟进规则
+type FollowRule struct {
+	Selector string   `json:"selector"`
+	Sources  []string `json:"sources"` //不指定selector时, 按来源跟进全面抽取的链接, 比如["a", "link", "frame"]
+	Attr     string `json:"attr"`    //默认href, XPath直接选出属性(比如//a/@href)时无需指定
+	Pattern  string `json:"pattern"` //只跟进匹配的绝对Url, 为空表示全部跟进
+	re       *regexp.Regexp
+	xp       *xpath.Expr
+	jp       *jsonpath.Path
+}
+
+//XPath和JSONPath选择器的前缀
+const (
+	XPATH_PREFIX = "xpath:"
+	JSON_PREFIX  = "json:"
+)
+
+//选择器是XPath则编译, CSS选择器返回nil
+func compileSelector(selector string) (*xpath.Expr, error) {
+	if !strings.HasPrefix(selector, XPATH_PREFIX) {
+		return nil, nil
+	}
+	return xpath.Compile(strings.TrimPrefix(selector, XPATH_PREFIX))
+}
+
+//选择器是JSONPath则编译, 其他选择器返回nil
+func compileJsonSelector(selector string) (*jsonpath.Path, error) {
+	if !strings.HasPrefix(selector, JSON_PREFIX) {
+		return nil, nil
+	}
+	return jsonpath.Compile(strings.TrimPrefix(selector, JSON_PREFIX))
+}
+
+//New
+func NewRulesSpider(v interface{}) basic.SpiderPlugin {
+	return &RulesSpider{
+		userData: v,
+	}
+}
+
+//从文件加载规则, 只支持JSON格式
+func LoadSiteRules(path string) (*SiteRules, error) {
+	if ext := strings.ToLower(filepath.Ext(path)); ext != ".json" {
+		return nil, errors.New(fmt.Sprintf("Rules file must be JSON (*.json), got: %s", path))
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSiteRules(data)
+}
+
+//解析规则并编译其中的正则
+func ParseSiteRules(data []byte) (*SiteRules, error) {
+	rules := &SiteRules{}
+	if err := json.Unmarshal(data, rules); err != nil {
+		return nil, err
+	}
+	if len(rules.Sites) == 0 {
+		return nil, errors.New("No site in rules!")
+	}
+
+	var err error
+	for i, site := range rules.Sites {
+		if site.Name == "" {
+			site.Name = fmt.Sprintf("site%d", i)
+		}
+		if site.UrlPattern != "" {
+			if site.urlRe, err = regexp.Compile(site.UrlPattern); err != nil {
+				return nil, errors.New(fmt.Sprintf("Site %s: bad url_pattern: %s", site.Name, err))
+			}
+		}
+		for _, f := range site.Fields {
+			if f.Name == "" || f.Selector == "" {
+				return nil, errors.New(fmt.Sprintf("Site %s: field needs name and selector", site.Name))
+			}
+			if f.xp, err = compileSelector(f.Selector); err != nil {
+				return nil, errors.New(fmt.Sprintf("Site %s: field %s: %s", site.Name, f.Name, err))
+			}
+			if f.jp, err = compileJsonSelector(f.Selector); err != nil {
+				return nil, errors.New(fmt.Sprintf("Site %s: field %s: %s", site.Name, f.Name, err))
+			}
+			if f.Regex != "" {
+				if f.re, err = regexp.Compile(f.Regex); err != nil {
+					return nil, errors.New(fmt.Sprintf("Site %s: field %s: bad regex: %s", site.Name, f.Name, err))
+				}
+			}
+		}
+		for _, f := range site.Follow {
+			if f.Selector == "" && len(f.Sources) == 0 {
+				return nil, errors.New(fmt.Sprintf("Site %s: follow needs selector or sources", site.Name))
+			}
+			for _, src := range f.Sources {
+				if !linkSources[src] {
+					return nil, errors.New(fmt.Sprintf("Site %s: unknown link source: %s", site.Name, src))
+				}
+			}
+			if f.xp, err = compileSelector(f.Selector); err != nil {
+				return nil, errors.New(fmt.Sprintf("Site %s: follow: %s", site.Name, err))
+			}
+			if f.jp, err = compileJsonSelector(f.Selector); err != nil {
+				return nil, errors.New(fmt.Sprintf("Site %s: follow: %s", site.Name, err))
+			}
+			if f.Attr == "" {
+				f.Attr = "href"
+			}
+			if f.Pattern != "" {
+				if f.re, err = regexp.Compile(f.Pattern); err != nil {
+					return nil, errors.New(fmt.Sprintf("Site %s: bad follow pattern: %s", site.Name, err))
+				}
+			}
+		}
+		if err := initJsonSite(site); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+//JSON接口站点的校验: 不能和CSS/XPath选择器混用
+func initJsonSite(site *SiteRule) error {
+	var err error
+	jsonCnt, selectorCnt := 0, len(site.Fields)+len(site.Follow)
+	for _, f := range site.Fields {
+		if f.jp != nil {
+			jsonCnt++
+		}
+	}
+	for _, f := range site.Follow {
+		if f.jp != nil {
+			jsonCnt++
+		}
+	}
+	site.json = jsonCnt > 0 || site.Items != "" || site.Pagination != nil
+	if !site.json {
+		return nil
+	}
+	if jsonCnt != selectorCnt {
+		return errors.New(fmt.Sprintf("Site %s: json site can only use json: selectors", site.Name))
+	}
+	if site.Items != "" {
+		if site.items, err = jsonpath.Compile(site.Items); err != nil {
+			return errors.New(fmt.Sprintf("Site %s: items: %s", site.Name, err))
+		}
+	}
+	if site.Pagination != nil {
+		if err = site.Pagination.Init(); err != nil {
+			return errors.New(fmt.Sprintf("Site %s: pagination: %s", site.Name, err))
+		}
+	}
+	return nil
+}
+
+//按Url找到第一个匹配的站点
+func (rules *SiteRules) match(u string) *SiteRule {
+	for _, site := range rules.Sites {
+		if site.urlRe == nil || site.urlRe.MatchString(u) {
+			return site
+		}
+	}
+	return nil
+}
+
+//生成HTTP客户端
+func (r *RulesSpider) GenHttpClient() *http.Client {
+	//客户端必须设置一个整体超时时间，否则随着时间推移，会把downloader全部卡死
+	return &http.Client{
+		Transport: http.DefaultTransport,
+		Timeout:   time.Duration(basic.Conf.RequestTimeout) * time.Second,
+	}
+}
+
+//获得响应解析函数的序列
+//规则文件在这里才加载, 因为main中会创建全部插件, 但只有被选中的插件才需要规则
+func (r *RulesSpider) GenResponseAnalysers() []basic.AnalyzeResponseFunc {
+	path, ok := r.userData.(string)
+	if !ok {
+		panic("Wrong type")
+	}
+	rules, err := LoadSiteRules(path)
+	if err != nil {
+		panic("Load rules error:" + err.Error())
+	}
+	return []basic.AnalyzeResponseFunc{
+		//闭包
+		func(httpResp *basic.Response) ([]*basic.Item, []*basic.Request, []error) {
+			return parseByRules(httpResp, rules)
+		},
+	}
+}
+
+// 获得条目处理链的序列。
+func (r *RulesSpider) GenItemProcessors() []basic.ProcessItemFunc {
+	return []basic.ProcessItemFunc{
+		processBaseItem,
+	}
+}
+
+//按规则分析页面
+func parseByRules(httpResp *basic.Response, rules *SiteRules) ([]*basic.Item, []*basic.Request, []error) {
+	//RSS/Atom不需要规则, 跟进文章链接即可; feed由跟进规则的"sources": ["feed"]发现
+	if IsFeed(httpResp) {
+		return AnalyzeFeed(httpResp)
+	}
+	site := rules.match(httpResp.ReqUrl)
+	if site == nil {
+		return nil, nil, nil
+	}
+	if site.json {
+		return parseJsonByRules(httpResp, site)
+	}
+
+	//对响应做一些处理
+	reqUrl, err := url.Parse(httpResp.ReqUrl) //记录下响应的请求（防止相对URL的问题）
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	//网页编码智能判断(非utf8 => utf8)并解析, 分析链上只做一次
+	doc, err := httpResp.Document()
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	contentType := httpResp.Charset()
+
+	itemList := []*basic.Item{}
+	requestList := []*basic.Request{}
+	errs := make([]error, 0)
+
+	//跟进链接, 多个规则抽出的相同Url只保留一个
+	uniqUrl := map[string]bool{}
+	baseUrl := docBaseUrl(reqUrl, doc)
+	var links []*Link
+	for _, f := range site.Follow {
+		var reqs []*basic.Request
+		var es []error
+		switch {
+		case f.Selector == "":
+			if links == nil {
+				links, es = ExtractLinks(httpResp, doc)
+				errs = append(errs, es...)
+			}
+			reqs, es = LinkRequests(httpResp, links, f.Sources...)
+		case f.xp != nil:
+			reqs, es = genRequestsFromLinks(httpResp, baseUrl, xpathLinks(doc, f))
+		default:
+			reqs, es = findLinksFromDoc(httpResp, baseUrl, doc.Selection, f.Selector, f.Attr)
+		}
+		errs = append(errs, es...)
+		requestList = appendFollow(requestList, reqs, f, uniqUrl)
+	}
+
+	//列表页翻页, 下一页同深度
+	if site.Pager != nil {
+		next, err := site.Pager.NextRequest(httpResp, doc)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		requestList = withNextPage(requestList, next)
+	}
+
+	//抽取条目
+	if len(site.Fields) > 0 {
+		imap := make(map[string]interface{})
+		complete := true
+		for _, f := range site.Fields {
+			v, empty := extractField(doc, f)
+			if empty && f.Required {
+				complete = false
+				break
+			}
+			imap[f.Name] = v
+		}
+		if complete {
+			imap["url"] = reqUrl.String()
+			imap["charset"] = contentType
+			imap["depth"] = httpResp.Depth
+			imap["site"] = site.Name
+			if site.Metadata {
+				m, es := PageMetadata(httpResp)
+				errs = append(errs, es...)
+				if m != nil {
+					imap[ITEM_METADATA] = m
+				}
+			}
+			item := basic.Item(imap)
+			itemList = append(itemList, &item)
+		}
+	}
+
+	return itemList, requestList, errs
+}
+
+//按跟进规则的pattern过滤, 并去重
+func appendFollow(requestList, reqs []*basic.Request, f *FollowRule, uniqUrl map[string]bool) []*basic.Request {
+	for _, req := range reqs {
+		u := req.HttpReq().URL.String()
+		if uniqUrl[u] || (f.re != nil && !f.re.MatchString(u)) {
+			continue
+		}
+		uniqUrl[u] = true
+		requestList = append(requestList, req)
+	}
+	return requestList
+}
+
+//按规则分析JSON接口的响应
+func parseJsonByRules(httpResp *basic.Response, site *SiteRule) ([]*basic.Item, []*basic.Request, []error) {
+	reqUrl, err := url.Parse(httpResp.ReqUrl)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+	data, err := httpResp.JSON()
+	if err != nil {
+		return nil, nil, []error{err}
+	}
+
+	itemList := []*basic.Item{}
+	requestList := []*basic.Request{}
+	errs := make([]error, 0)
+
+	//跟进链接
+	uniqUrl := map[string]bool{}
+	for _, f := range site.Follow {
+		hrefs := []string{}
+		for _, v := range f.jp.Find(data) {
+			hrefs = append(hrefs, jsonpath.ToString(v))
+		}
+		reqs, es := genRequestsFromLinks(httpResp, reqUrl, hrefs)
+		errs = append(errs, es...)
+		requestList = appendFollow(requestList, reqs, f, uniqUrl)
+	}
+
+	//抽取条目, 没有指定items则整个响应是一个条目
+	if len(site.Fields) > 0 {
+		roots := []interface{}{data}
+		if site.items != nil {
+			roots = site.items.Find(data)
+		}
+		for _, root := range roots {
+			imap := make(map[string]interface{})
+			complete := true
+			for _, f := range site.Fields {
+				v, empty := extractJsonField(root, f)
+				if empty && f.Required {
+					complete = false
+					break
+				}
+				imap[f.Name] = v
+			}
+			if complete {
+				imap["url"] = reqUrl.String()
+				imap["depth"] = httpResp.Depth
+				imap["site"] = site.Name
+				item := basic.Item(imap)
+				itemList = append(itemList, &item)
+			}
+		}
+	}
+
+	//翻页
+	if site.Pagination != nil {
+		req, err := site.Pagination.NextRequest(httpResp, data, len(itemList))
+		if err != nil {
+			errs = append(errs, err)
+		} else if req != nil {
+			requestList = append(requestList, req)
+		}
+	}
+
+	return itemList, requestList, errs
+}
+
+//抽取一个JSON字段, 返回值以及是否为空
+//字符串做后处理; 数字、布尔、对象等在没有正则时保留原始类型
+func extractJsonField(root interface{}, f *FieldRule) (interface{}, bool) {
+	found := f.jp.Find(root)
+	if !f.Multiple && len(found) > 1 {
+		found = found[:1]
+	}
+	values := []interface{}{}
+	for _, v := range found {
+		if _, isString := v.(string); !isString && f.re == nil {
+			if v != nil {
+				values = append(values, v)
+			}
+			continue
+		}
+		if s, ok := postProcess(jsonpath.ToString(v), f); ok {
+			values = append(values, s)
+		}
+	}
+
+	if f.Multiple {
+		return values, len(values) == 0
+	}
+	if len(values) == 0 {
+		return "", true
+	}
+	return values[0], false
+}
+
+//XPath选出的链接: 属性节点直接取值, 元素取Attr属性
+func xpathLinks(doc *goquery.Document, f *FollowRule) []string {
+	hrefs := []string{}
+	for _, n := range f.xp.Select(doc.Nodes[0]) {
+		if n.IsAttr() {
+			hrefs = append(hrefs, n.Value())
+		} else if n.Type == html.ElementNode {
+			if href, exists := goquery.NewDocumentFromNode(n.Node).Attr(f.Attr); exists {
+				hrefs = append(hrefs, href)
+			}
+		}
+	}
+	return hrefs
+}
+
+//抽取一个字段, 返回值以及是否为空
+func extractField(doc *goquery.Document, f *FieldRule) (interface{}, bool) {
+	values := []string{}
+	if f.xp != nil {
+		nodes := f.xp.Select(doc.Nodes[0])
+		if !f.Multiple && len(nodes) > 1 {
+			nodes = nodes[:1]
+		}
+		for _, n := range nodes {
+			var v string
+			var ok bool
+			if n.IsAttr() || n.Type != html.ElementNode {
+				//属性和文本节点直接取字符串值
+				v, ok = postProcess(n.Value(), f)
+			} else {
+				v, ok = fieldValue(goquery.NewDocumentFromNode(n.Node).Selection, f)
+			}
+			if ok {
+				values = append(values, v)
+			}
+		}
+		//XPath的结果也可以是字符串或者数字, 比如normalize-space(//h1)
+		if nodes == nil {
+			if v, ok := postProcess(strings.Join(f.xp.Values(doc.Nodes[0]), ""), f); ok {
+				values = append(values, v)
+			}
+		}
+	} else {
+		sel := doc.Find(f.Selector)
+		if !f.Multiple {
+			sel = sel.First()
+		}
+		sel.Each(func(index int, s *goquery.Selection) {
+			if v, ok := fieldValue(s, f); ok {
+				values = append(values, v)
+			}
+		})
+	}
+
+	if f.Multiple {
+		return values, len(values) == 0
+	}
+	if len(values) == 0 {
+		return "", true
+	}
+	return values[0], false
+}
+
+//取单个元素的值, 并做后处理
+func fieldValue(s *goquery.Selection, f *FieldRule) (string, bool) {
+	var v string
+	switch f.Attr {
+	case "":
+		v = s.Text()
+	case "html":
+		v, _ = s.Html()
+	default:
+		var exists bool
+		if v, exists = s.Attr(f.Attr); !exists {
+			return "", false
+		}
+	}
+	return postProcess(v, f)
+}
+
+//后处理: 去除首尾空白, 正则抽取
+func postProcess(v string, f *FieldRule) (string, bool) {
+	if !f.NoTrim {
+		v = strings.TrimSpace(v)
+	}
+	if f.re != nil {
+		m := f.re.FindStringSubmatch(v)
+		switch {
+		case m == nil:
+			v = ""
+		case len(m) > 1:
+			v = m[1]
+		default:
+			v = m[0]
+		}
+	}
+	return v, v != ""
+}
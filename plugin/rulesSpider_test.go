@@ -0,0 +1,138 @@
+package plugin
+
+import (
+	"github.com/hq-cml/spider-man/basic"
+	"github.com/hq-cml/spider-man/helper/log"
+	"strings"
+	"testing"
+)
+
+const testRules = `{
+    "sites": [
+        {
+            "name": "article",
+            "url_pattern": "^http://example\\.com/n/\\d+\\.html$",
+            "fields": [
+                {"name": "title", "selector": ".article h1", "required": true},
+                {"name": "id", "selector": "link[rel=canonical]", "attr": "href", "regex": "/n/(\\d+)\\.html"},
+                {"name": "tags", "selector": ".tags span", "multiple": true},
+                {"name": "author", "selector": ".author"}
+            ],
+            "follow": [
+                {"selector": ".related a", "pattern": "/n/"}
+            ]
+        },
+        {
+            "name": "index",
+            "follow": [
+                {"selector": "a"}
+            ]
+        }
+    ]
+}`
+
+const testArticle = `<html><head><meta charset="utf-8">
+<link rel="canonical" href="http://example.com/n/123.html"></head>
+<body><div class="article"><h1>  Hello World
+</h1></div>
+<div class="tags"><span>go</span><span> spider </span></div>
+<div class="related"><a href="/n/124.html">next</a><a href="/about">about</a><a href="/n/124.html#top">dup</a></div>
+<a href="/other">other</a>
+</body></html>`
+
+func TestParseByRules(t *testing.T) {
+	log.InitLog("", "debug")
+	rules, err := ParseSiteRules([]byte(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := basic.NewResponse([]byte(testArticle), 1, "text/html; charset=utf-8", "http://example.com/n/123.html")
+	items, reqs, errs := parseByRules(resp, rules)
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if len(items) != 1 {
+		t.Fatalf("Expect 1 item, got %d", len(items))
+	}
+	item := *items[0]
+	if item["title"] != "Hello World" || item["id"] != "123" || item["author"] != "" || item["site"] != "article" {
+		t.Fatalf("Wrong item: %v", item)
+	}
+	if tags := item["tags"].([]string); len(tags) != 2 || tags[1] != "spider" {
+		t.Fatalf("Wrong tags: %v", item["tags"])
+	}
+	if len(reqs) != 1 || reqs[0].HttpReq().URL.String() != "http://example.com/n/124.html" || reqs[0].Depth() != 2 {
+		t.Fatalf("Wrong requests: %v", reqs)
+	}
+
+	//必需字段缺失则不产生条目, 其他站点规则兜底跟进全部链接
+	resp = basic.NewResponse([]byte(`<html><body><a href="/x">x</a><a href="/y">y</a></body></html>`),
+		0, "text/html; charset=utf-8", "http://example.com/n/1.html")
+	items, reqs, _ = parseByRules(resp, rules)
+	if len(items) != 0 || len(reqs) != 0 {
+		t.Fatalf("Expect nothing, got %d items %d requests", len(items), len(reqs))
+	}
+	resp = basic.NewResponse([]byte(`<html><body><a href="/x">x</a><a href="/y">y</a></body></html>`),
+		0, "text/html; charset=utf-8", "http://example.com/")
+	items, reqs, _ = parseByRules(resp, rules)
+	if len(items) != 0 || len(reqs) != 2 {
+		t.Fatalf("Expect 2 requests, got %d items %d requests", len(items), len(reqs))
+	}
+}
+
+func TestParseByXpathRules(t *testing.T) {
+	log.InitLog("", "debug")
+	rules, err := ParseSiteRules([]byte(`{"sites": [{
+		"name": "article",
+		"fields": [
+			{"name": "title", "selector": "xpath:normalize-space(//div[@class='article']/h1)", "required": true},
+			{"name": "next", "selector": "xpath://div[@class='related']/a[text()='next']", "attr": "href"},
+			{"name": "tags", "selector": "xpath://div[@class='tags']/span/text()", "multiple": true},
+			{"name": "id", "selector": "xpath://link[@rel='canonical']/@href", "regex": "(\\d+)"}
+		],
+		"follow": [
+			{"selector": "xpath://div[@class='related']/a/@href", "pattern": "/n/"},
+			{"selector": "xpath://body/a"}
+		]
+	}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := basic.NewResponse([]byte(testArticle), 1, "text/html; charset=utf-8", "http://example.com/n/123.html")
+	items, reqs, errs := parseByRules(resp, rules)
+	if len(errs) != 0 || len(items) != 1 {
+		t.Fatalf("Expect 1 item, got %d, errs: %v", len(items), errs)
+	}
+	item := *items[0]
+	if item["title"] != "Hello World" || item["next"] != "/n/124.html" || item["id"] != "123" {
+		t.Fatalf("Wrong item: %v", item)
+	}
+	if tags := item["tags"].([]string); len(tags) != 2 || tags[0] != "go" {
+		t.Fatalf("Wrong tags: %v", item["tags"])
+	}
+	if len(reqs) != 2 || reqs[1].HttpReq().URL.String() != "http://example.com/other" {
+		t.Fatalf("Wrong requests: %v", reqs)
+	}
+
+	if _, err := ParseSiteRules([]byte(`{"sites": [{"fields": [{"name": "x", "selector": "xpath://a["}]}]}`)); err == nil {
+		t.Fatal("Expect bad xpath error")
+	}
+}
+
+func TestParseSiteRulesError(t *testing.T) {
+	if _, err := ParseSiteRules([]byte(`{"sites": [{"url_pattern": "("}]}`)); err == nil {
+		t.Fatal("Expect bad url_pattern error")
+	}
+	if _, err := ParseSiteRules([]byte(`{"sites": [{"fields": [{"name": "x"}]}]}`)); err == nil {
+		t.Fatal("Expect missing selector error")
+	}
+	if _, err := LoadSiteRules("../conf/rules.json"); err != nil {
+		t.Fatal(err)
+	}
+	//只支持JSON, 其他扩展名在读文件前就报错
+	if _, err := LoadSiteRules("../conf/rules.yaml"); err == nil || !strings.Contains(err.Error(), "must be JSON") {
+		t.Fatal("Expect non-JSON rules file error:", err)
+	}
+}